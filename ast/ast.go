@@ -3,6 +3,7 @@ package ast
 import (
 	"fmt"
 	"log"
+	"slices"
 
 	"github.com/takoeight0821/anma/token"
 	"github.com/takoeight0821/anma/utils"
@@ -568,6 +569,55 @@ func (i *InfixDecl) Plate(err error, _ func(Node, error) (Node, error)) (Node, e
 
 var _ Node = &InfixDecl{}
 
+// ModuleDecl is `module name (exports...)` at the beginning of a file.
+type ModuleDecl struct {
+	Name    token.Token
+	Exports []token.Token // Exported names. If it is nil, all top-level declarations are exported.
+}
+
+func (m ModuleDecl) String() string {
+	if m.Exports == nil {
+		return utils.Parenthesize("module", m.Name).String()
+	}
+
+	return utils.Parenthesize("module", m.Name, utils.Parenthesize("exports", utils.Concat(m.Exports))).String()
+}
+
+func (m *ModuleDecl) Base() token.Token {
+	return m.Name
+}
+
+func (m *ModuleDecl) Plate(err error, _ func(Node, error) (Node, error)) (Node, error) {
+	return m, err
+}
+
+var _ Node = &ModuleDecl{}
+
+// ImportDecl is `import name (names...)`.
+// Exported names of the module are accessed as `name.x`, and imported names can also be used without qualification.
+type ImportDecl struct {
+	Name  token.Token
+	Names []token.Token // Names used without qualification. If it is nil, all exported names are imported.
+}
+
+func (i ImportDecl) String() string {
+	if i.Names == nil {
+		return utils.Parenthesize("import", i.Name).String()
+	}
+
+	return utils.Parenthesize("import", i.Name, utils.Parenthesize("names", utils.Concat(i.Names))).String()
+}
+
+func (i *ImportDecl) Base() token.Token {
+	return i.Name
+}
+
+func (i *ImportDecl) Plate(err error, _ func(Node, error) (Node, error)) (Node, error) {
+	return i, err
+}
+
+var _ Node = &ImportDecl{}
+
 type This struct {
 	token.Token
 }
@@ -586,6 +636,26 @@ func (t *This) Plate(err error, _ func(Node, error) (Node, error)) (Node, error)
 
 var _ Node = &This{}
 
+// Bad is a placeholder for a part of the source that cannot be parsed.
+// The parser skips the tokens from Where to the point it recovers from the error.
+type Bad struct {
+	Where token.Token // The first skipped token.
+}
+
+func (b Bad) String() string {
+	return utils.Parenthesize("bad", b.Where).String()
+}
+
+func (b *Bad) Base() token.Token {
+	return b.Where
+}
+
+func (b *Bad) Plate(err error, _ func(Node, error) (Node, error)) (Node, error) {
+	return b, err
+}
+
+var _ Node = &Bad{}
+
 // Traverse the [Node] in depth-first order.
 // f is called for each node.
 // If f returns an error, f also must return the original argument n.
@@ -630,3 +700,26 @@ func Universe(n Node) []Node {
 
 	return nodes
 }
+
+// Module is a module in a program, which begins with its declaration.
+type Module struct {
+	Decl  *ModuleDecl
+	Nodes []Node // Declarations after Decl, until the next module declaration.
+}
+
+// SplitModules splits the program at module declarations.
+// It returns declarations before the first module declaration, and the modules in order.
+//
+//tool:ignore
+func SplitModules(program []Node) ([]Node, []Module) {
+	var modules []Module
+	for i := len(program) - 1; i >= 0; i-- {
+		if decl, ok := program[i].(*ModuleDecl); ok {
+			modules = append(modules, Module{Decl: decl, Nodes: program[i+1:]})
+			program = program[:i]
+		}
+	}
+	slices.Reverse(modules)
+
+	return program, modules
+}
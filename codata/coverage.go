@@ -0,0 +1,60 @@
+package codata
+
+import (
+	"maps"
+
+	"github.com/takoeight0821/anma/ast"
+)
+
+// Coverage runs the checks of copatterns scheduled by the last run of [Flat].
+// It must run after name resolution, because it tells constructors apart by their resolved names
+// even if they have the same lexeme, such as constructors of types in different modules.
+type Coverage struct {
+	flat *Flat
+}
+
+// Coverage returns the pass that checks copatterns flattened by f.
+func (f *Flat) Coverage() *Coverage {
+	return &Coverage{flat: f}
+}
+
+func (*Coverage) Name() string {
+	return "codata.Coverage"
+}
+
+// Snapshot returns a function that forgets constructors of programs given to Init after it.
+func (c *Coverage) Snapshot() (restore func()) {
+	constructors := maps.Clone(c.flat.constructors)
+
+	return func() {
+		c.flat.constructors = constructors
+	}
+}
+
+// Init collects constructors declared in the program.
+// Constructors of earlier programs are kept, so that copatterns can match on them in later programs.
+func (c *Coverage) Init(program []ast.Node) error {
+	if c.flat.constructors == nil {
+		c.flat.constructors = make(map[string]*constructor)
+	}
+	maps.Copy(c.flat.constructors, collectConstructors(program))
+
+	return nil
+}
+
+// Run reports warnings of the checks, or returns the first error in [CheckError] mode.
+// It does not change the program.
+func (c *Coverage) Run(program []ast.Node) ([]ast.Node, error) {
+	if c.flat.checks == nil {
+		return program, nil
+	}
+	checks := *c.flat.checks
+	*c.flat.checks = nil
+	for _, check := range checks {
+		if err := check(); err != nil {
+			return program, err
+		}
+	}
+
+	return program, nil
+}
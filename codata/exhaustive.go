@@ -0,0 +1,393 @@
+package codata
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// CheckMode controls how [Flat] reports non-exhaustive copatterns.
+type CheckMode int
+
+const (
+	// CheckWarn reports [NonExhaustiveWarning] and continues.
+	CheckWarn CheckMode = iota
+	// CheckError aborts with [NonExhaustiveError].
+	CheckError
+	// CheckIgnore does not check exhaustiveness.
+	CheckIgnore
+)
+
+// NonExhaustiveError is an error that is returned when copatterns do not cover some values.
+type NonExhaustiveError struct {
+	Missing string
+}
+
+func (e NonExhaustiveError) Error() string {
+	return fmt.Sprintf("non-exhaustive copatterns: `%s` not covered", e.Missing)
+}
+
+func (NonExhaustiveError) Code() string {
+	return "E0301"
+}
+
+func (NonExhaustiveError) Notes() []string {
+	return []string{"add a clause for the missing values, or a clause with wildcards"}
+}
+
+// NonExhaustiveWarning is a warning that is reported instead of [NonExhaustiveError] in [CheckWarn] mode.
+type NonExhaustiveWarning struct {
+	NonExhaustiveError
+}
+
+func (NonExhaustiveWarning) Code() string {
+	return "W0303"
+}
+
+// constructor is a data constructor declared by [ast.TypeDecl].
+type constructor struct {
+	key   string // The resolved name, which is unique among constructors of all types.
+	name  string
+	arity int
+	// siblings are all constructors of the type, including itself.
+	// nil if the type is unknown.
+	siblings []*constructor
+}
+
+func (c *constructor) example() string {
+	args := make([]string, c.arity)
+	for i := range args {
+		args[i] = "_"
+	}
+
+	return c.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// collectConstructors collects constructors declared in the program by their resolved names.
+func collectConstructors(program []ast.Node) map[string]*constructor {
+	constructors := make(map[string]*constructor)
+	for _, node := range program {
+		decl, ok := node.(*ast.TypeDecl)
+		if !ok {
+			continue
+		}
+		siblings := make([]*constructor, 0, len(decl.Types))
+		for _, typ := range decl.Types {
+			call, ok := typ.(*ast.Call)
+			if !ok {
+				continue
+			}
+			name, ok := call.Func.(*ast.Var)
+			if !ok {
+				continue
+			}
+			siblings = append(siblings, &constructor{key: constructorKey(name.Name), name: name.Name.Lexeme, arity: len(call.Args), siblings: nil})
+		}
+		for _, ctor := range siblings {
+			ctor.siblings = siblings
+			constructors[ctor.key] = ctor
+		}
+	}
+
+	return constructors
+}
+
+// step is an observation of a copattern.
+type step struct {
+	field string // Non-empty if the step is a field access.
+	arity int    // The number of arguments if the step is an application.
+}
+
+// describe renders the path to the current position with the given arguments.
+// For example, `vendor(Cons(_, _)).put.put.get`.
+func (f *Flat) describe(args []string) string {
+	var builder strings.Builder
+	builder.WriteString(f.name)
+	for _, s := range f.path {
+		if s.field != "" {
+			builder.WriteString("." + s.field)
+
+			continue
+		}
+		builder.WriteString("(" + strings.Join(args[:s.arity], ", ") + ")")
+		args = args[s.arity:]
+	}
+
+	return builder.String()
+}
+
+// checkExhaustive checks that rows cover all values of the scrutinees.
+func (f *Flat) checkExhaustive(rows [][]ast.Node) error {
+	if f.Exhaustive == CheckIgnore {
+		return nil
+	}
+
+	args, ok := f.exhaust(f.pad(rows), len(f.scrutinees))
+	if !ok {
+		return nil
+	}
+
+	err := NonExhaustiveError{Missing: f.describe(args)}
+	if f.Exhaustive == CheckError {
+		return utils.PosError{Where: f.where, Err: err}
+	}
+	f.report(f.where, NonExhaustiveWarning{NonExhaustiveError: err})
+
+	return nil
+}
+
+// exhaust searches n values that no row matches, following Maranget's usefulness algorithm.
+// It returns examples of such values, or false if rows are exhaustive.
+// nil in rows is a wildcard.
+func (f *Flat) exhaust(rows [][]ast.Node, n int) ([]string, bool) {
+	if n == 0 {
+		return []string{}, len(rows) == 0
+	}
+
+	tuple, ctors, literals := f.heads(rows)
+
+	if tuple != nil {
+		arity := len(tuple.Exprs)
+		args, ok := f.exhaust(specialize(rows, arity, matchTuple(arity)), arity+n-1)
+		if !ok {
+			return nil, false
+		}
+
+		return append([]string{"[" + strings.Join(args[:arity], ", ") + "]"}, args[arity:]...), true
+	}
+
+	if signature, complete := signatureOf(ctors); complete {
+		for _, ctor := range signature {
+			args, ok := f.exhaust(specialize(rows, ctor.arity, f.matchConstructor(ctor)), ctor.arity+n-1)
+			if ok {
+				head := ctor.name + "(" + strings.Join(args[:ctor.arity], ", ") + ")"
+
+				return append([]string{head}, args[ctor.arity:]...), true
+			}
+		}
+
+		return nil, false
+	}
+
+	// Some constructors or literals are missing. Search values in rows that match anything.
+	args, ok := f.exhaust(specialize(rows, 0, matchNothing), n-1)
+	if !ok {
+		return nil, false
+	}
+
+	return append([]string{missingExample(ctors, literals)}, args...), true
+}
+
+// useful reports whether some values match q but no rows.
+// nil in rows and q is a wildcard.
+func (f *Flat) useful(rows [][]ast.Node, q []ast.Node) bool {
+	if len(q) == 0 {
+		return len(rows) == 0
+	}
+
+	switch head := q[0].(type) {
+	case *ast.Call:
+		ctor := f.constructorOf(head)
+
+		return f.useful(specialize(rows, ctor.arity, f.matchConstructor(ctor)), append(normalizeAll(head.Args), q[1:]...))
+	case *ast.Tuple:
+		arity := len(head.Exprs)
+
+		return f.useful(specialize(rows, arity, matchTuple(arity)), append(normalizeAll(head.Exprs), q[1:]...))
+	case *ast.Literal:
+		return f.useful(specialize(rows, 0, matchLiteral(head)), q[1:])
+	}
+
+	// q[0] is a wildcard.
+	tuple, ctors, _ := f.heads(rows)
+	if tuple != nil {
+		arity := len(tuple.Exprs)
+
+		return f.useful(specialize(rows, arity, matchTuple(arity)), append(make([]ast.Node, arity), q[1:]...))
+	}
+	if signature, complete := signatureOf(ctors); complete {
+		return slices.ContainsFunc(signature, func(ctor *constructor) bool {
+			return f.useful(specialize(rows, ctor.arity, f.matchConstructor(ctor)), append(make([]ast.Node, ctor.arity), q[1:]...))
+		})
+	}
+
+	return f.useful(specialize(rows, 0, matchNothing), q[1:])
+}
+
+// heads collects the first patterns of rows.
+func (f *Flat) heads(rows [][]ast.Node) (*ast.Tuple, []*constructor, map[string]bool) {
+	var (
+		tuple    *ast.Tuple
+		ctors    []*constructor
+		literals = make(map[string]bool)
+	)
+	for _, row := range rows {
+		switch head := row[0].(type) {
+		case *ast.Tuple:
+			tuple = head
+		case *ast.Call:
+			ctor := f.constructorOf(head)
+			if !hasConstructor(ctors, ctor) {
+				ctors = append(ctors, ctor)
+			}
+		case *ast.Literal:
+			literals[literalKey(head)] = true
+		}
+	}
+
+	return tuple, ctors, literals
+}
+
+// specialize keeps rows whose first pattern is a wildcard or matches by match, and expands it to arity columns.
+func specialize(rows [][]ast.Node, arity int, match func(ast.Node) ([]ast.Node, bool)) [][]ast.Node {
+	specialized := make([][]ast.Node, 0, len(rows))
+	for _, row := range rows {
+		var args []ast.Node
+		if row[0] == nil {
+			args = make([]ast.Node, arity)
+		} else {
+			var ok bool
+			args, ok = match(row[0])
+			if !ok {
+				continue
+			}
+		}
+		specialized = append(specialized, append(normalizeAll(args), row[1:]...))
+	}
+
+	return specialized
+}
+
+func (f *Flat) matchConstructor(ctor *constructor) func(ast.Node) ([]ast.Node, bool) {
+	return func(head ast.Node) ([]ast.Node, bool) {
+		call, ok := head.(*ast.Call)
+		if !ok || f.constructorOf(call).key != ctor.key {
+			return nil, false
+		}
+
+		return call.Args, true
+	}
+}
+
+func matchTuple(arity int) func(ast.Node) ([]ast.Node, bool) {
+	return func(head ast.Node) ([]ast.Node, bool) {
+		tuple, ok := head.(*ast.Tuple)
+		if !ok || len(tuple.Exprs) != arity {
+			return nil, false
+		}
+
+		return tuple.Exprs, true
+	}
+}
+
+func matchLiteral(literal *ast.Literal) func(ast.Node) ([]ast.Node, bool) {
+	return func(head ast.Node) ([]ast.Node, bool) {
+		other, ok := head.(*ast.Literal)
+
+		return nil, ok && literalKey(other) == literalKey(literal)
+	}
+}
+
+func matchNothing(ast.Node) ([]ast.Node, bool) {
+	return nil, false
+}
+
+// signatureOf returns all constructors of the type of ctors, and whether ctors cover them.
+// If the type is unknown, ctors are assumed to cover it.
+func signatureOf(ctors []*constructor) ([]*constructor, bool) {
+	if len(ctors) == 0 {
+		return nil, false
+	}
+	for _, ctor := range ctors {
+		if ctor.siblings == nil {
+			return ctors, true
+		}
+	}
+	for _, sibling := range ctors[0].siblings {
+		if !hasConstructor(ctors, sibling) {
+			return ctors[0].siblings, false
+		}
+	}
+
+	return ctors[0].siblings, true
+}
+
+// missingExample returns an example value that is not any of ctors and literals.
+func missingExample(ctors []*constructor, literals map[string]bool) string {
+	if len(ctors) > 0 {
+		for _, sibling := range ctors[0].siblings {
+			if !hasConstructor(ctors, sibling) {
+				return sibling.example()
+			}
+		}
+	}
+	for key := range literals {
+		if strings.HasPrefix(key, token.INTEGER.String()+":") {
+			for i := 0; ; i++ {
+				if !literals[token.INTEGER.String()+":"+strconv.Itoa(i)] {
+					return strconv.Itoa(i)
+				}
+			}
+		}
+	}
+
+	return "_"
+}
+
+// literalKey returns a comparable representation of the literal.
+func literalKey(literal *ast.Literal) string {
+	return fmt.Sprintf("%v:%v", literal.Kind, literal.Literal)
+}
+
+func hasConstructor(ctors []*constructor, target *constructor) bool {
+	return slices.ContainsFunc(ctors, func(ctor *constructor) bool { return ctor.key == target.key })
+}
+
+// constructorKey returns the key of the constructor named name.
+// Constructors of different types have different keys even if they have the same lexeme.
+func constructorKey(name token.Token) string {
+	return fmt.Sprintf("%s.%v", name.Lexeme, name.Literal)
+}
+
+// constructorOf returns the constructor of the pattern `C(...)`.
+// Undeclared constructors have no siblings.
+func (f *Flat) constructorOf(call *ast.Call) *constructor {
+	name := token.Token{Kind: token.IDENT, Lexeme: "_", Location: token.Location{}, Literal: nil}
+	if v, ok := call.Func.(*ast.Var); ok {
+		name = v.Name
+	}
+	if ctor, ok := f.constructors[constructorKey(name)]; ok && ctor.arity == len(call.Args) {
+		return ctor
+	}
+
+	return &constructor{key: constructorKey(name), name: name.Lexeme, arity: len(call.Args), siblings: nil}
+}
+
+func normalizeAll(patterns []ast.Node) []ast.Node {
+	normalized := make([]ast.Node, len(patterns))
+	for i, pattern := range patterns {
+		normalized[i] = normalize(pattern)
+	}
+
+	return normalized
+}
+
+// normalize removes parentheses and replaces variables with wildcards.
+func normalize(pattern ast.Node) ast.Node {
+	switch p := pattern.(type) {
+	case *ast.Paren:
+		return normalize(p.Expr)
+	case *ast.Var:
+		return nil
+	case *ast.Tuple, *ast.Call, *ast.Literal:
+		return p
+	default:
+		// Other patterns are checked at runtime.
+		return nil
+	}
+}
@@ -12,10 +12,52 @@ import (
 )
 
 // Flat converts [Codata] to [Object], [Case], and [Lambda].
+// It also checks that copatterns cover all values of the arguments, and reports it by Exhaustive.
+// The checks run in [Coverage] after names are resolved, so that constructors of the same name are told apart.
 type Flat struct {
+	Exhaustive CheckMode
+
 	uniq       int
 	scrutinees []token.Token
 	guards     map[int][]ast.Node
+
+	constructors map[string]*constructor
+	names        map[*ast.Codata]string // Codata -> name of the definition.
+	name         string                 // Name of the current codata, used in messages.
+	where        token.Token            // Location of the current codata.
+	locations    map[int]token.Token    // Clause index -> location of the clause.
+	path         []step                 // Observations from the current codata to the current position.
+	covered      [][]ast.Node           // Guards of clauses that have been matched before the current position.
+	checks       *[]func() error        // Checks of copatterns waiting for Coverage, shared by inner Flats.
+
+	warn     func(utils.Warning)
+	warnings []utils.Warning // Warnings collected if warn is not set.
+}
+
+// inner returns a [Flat] for the subtree of the current position.
+func (f *Flat) inner(scrutinees []token.Token, guards map[int][]ast.Node, path []step, covered [][]ast.Node) *Flat {
+	return &Flat{
+		Exhaustive:   f.Exhaustive,
+		uniq:         f.uniq,
+		scrutinees:   scrutinees,
+		guards:       guards,
+		constructors: f.constructors,
+		names:        f.names,
+		name:         f.name,
+		where:        f.where,
+		locations:    f.locations,
+		path:         path,
+		covered:      covered,
+		checks:       f.checks,
+		warn:         f.warn,
+	}
+}
+
+// check schedules a check of copatterns at the current position for [Coverage].
+// The check is given a copy of f, because f is reused for the next codata.
+func (f *Flat) check(check func(snapshot *Flat) error) {
+	snapshot := *f
+	*f.checks = append(*f.checks, func() error { return check(&snapshot) })
 }
 
 func (f *Flat) genUniq(hint string) string {
@@ -33,6 +75,24 @@ func (*Flat) Init([]ast.Node) error {
 }
 
 func (f *Flat) Run(program []ast.Node) ([]ast.Node, error) {
+	if f.warn == nil {
+		f.warn = f.collect
+	}
+	// Checks of a program that failed in later passes are dropped.
+	f.checks = new([]func() error)
+	if f.constructors == nil {
+		// Coverage fills it before running the checks, which share it.
+		f.constructors = make(map[string]*constructor)
+	}
+	f.names = make(map[*ast.Codata]string)
+	for _, n := range program {
+		if decl, ok := n.(*ast.VarDecl); ok {
+			if codata, ok := decl.Expr.(*ast.Codata); ok {
+				f.names[codata] = decl.Name.Lexeme
+			}
+		}
+	}
+
 	for i, n := range program {
 		var err error
 		program[i], err = f.flat(n)
@@ -77,10 +137,19 @@ func (f *Flat) flatCodata(codata *ast.Codata) (ast.Node, error) {
 	f.uniq = 0
 	f.scrutinees = make([]token.Token, 0)
 	f.guards = make(map[int][]ast.Node)
+	f.path = make([]step, 0)
+	f.covered = make([][]ast.Node, 0)
+	f.where = thisOf(codata.Clauses[0].Pattern)
+	f.name = "#"
+	if name, ok := f.names[codata]; ok {
+		f.name = name
+	}
 
 	plists := make(map[int][]ast.Node)
+	f.locations = make(map[int]token.Token)
 	for i, clause := range codata.Clauses {
 		plists[i] = makePatternList(clause.Pattern)
+		f.locations[i] = thisOf(clause.Pattern)
 	}
 
 	bodys := make(map[int]ast.Node)
@@ -112,6 +181,20 @@ func makePatternList(pattern ast.Node) []ast.Node {
 	}
 }
 
+// thisOf returns the `#` token of the pattern.
+func thisOf(pattern ast.Node) token.Token {
+	switch pattern := pattern.(type) {
+	case *ast.Access:
+		return thisOf(pattern.Receiver)
+	case *ast.Call:
+		return thisOf(pattern.Func)
+	case *ast.Paren:
+		return thisOf(pattern.Expr)
+	default:
+		return pattern.Base()
+	}
+}
+
 func (f *Flat) build(plists map[int][]ast.Node, bodys map[int]ast.Node) (ast.Node, error) {
 	if len(plists) == 0 {
 		panic(fmt.Sprintf("empty plists: %v", plists))
@@ -204,7 +287,23 @@ func (e MismatchError) Error() string {
 	return builder.String()
 }
 
+func (MismatchError) Code() string {
+	return "E0302"
+}
+
 func (f *Flat) buildCase(plists map[int][]ast.Node, bodys map[int]ast.Node) (ast.Node, error) {
+	plistsKeys := make([]int, 0, len(plists))
+	for k := range plists {
+		plistsKeys = append(plistsKeys, k)
+	}
+	slices.Sort(plistsKeys)
+
+	f.check(func(snapshot *Flat) error {
+		snapshot.checkRedundant(plists, plistsKeys)
+
+		return nil
+	})
+
 	if len(f.scrutinees) == 0 {
 		// If there is no scrutinee, generate a body.
 		// Use the topmost body.
@@ -213,12 +312,6 @@ func (f *Flat) buildCase(plists map[int][]ast.Node, bodys map[int]ast.Node) (ast
 		return bodys[topmost], nil
 	}
 
-	plistsKeys := make([]int, 0, len(plists))
-	for k := range plists {
-		plistsKeys = append(plistsKeys, k)
-	}
-	slices.Sort(plistsKeys)
-
 	// restPlists is a map of patterns that are not empty.
 	restPlists := make(map[int][]ast.Node)
 	for _, i := range plistsKeys {
@@ -239,14 +332,25 @@ func (f *Flat) buildCase(plists map[int][]ast.Node, bodys map[int]ast.Node) (ast
 		restBodys[i] = bodys[i]
 	}
 
+	// covered is guards of clauses that end here.
+	// Values reach restBody only if they do not match these guards.
+	covered := slices.Clone(f.covered)
+	for _, i := range plistsKeys {
+		if len(plists[i]) == 0 {
+			covered = append(covered, f.guards[i])
+		}
+	}
+
 	var restBody ast.Node
 	if len(restPlists) != 0 {
-		innerF := &Flat{uniq: f.uniq, scrutinees: f.scrutinees, guards: selectIndicies(restKeys, f.guards)}
+		innerF := f.inner(f.scrutinees, selectIndicies(restKeys, f.guards), f.path, covered)
 		var err error
 		restBody, err = innerF.build(restPlists, restBodys)
 		if err != nil {
 			return nil, err
 		}
+	} else {
+		f.check(func(snapshot *Flat) error { return snapshot.checkExhaustive(covered) })
 	}
 
 	clauses := make([]*ast.CaseClause, 0)
@@ -312,7 +416,8 @@ func (f *Flat) buildObject(plists map[int][]ast.Node, bodys map[int]ast.Node) (a
 
 	objectFields := make([]*ast.Field, 0)
 	for _, name := range fieldsKeys {
-		innerF := &Flat{uniq: f.uniq, scrutinees: f.scrutinees, guards: selectIndicies(fields[name], f.guards)}
+		path := append(slices.Clip(f.path), step{field: name, arity: 0})
+		innerF := f.inner(f.scrutinees, selectIndicies(fields[name], f.guards), path, f.covered)
 		expr, err := innerF.build(selectIndicies(fields[name], rest), bodys)
 		if err != nil {
 			return nil, err
@@ -361,7 +466,8 @@ func (f *Flat) buildLambda(plists map[int][]ast.Node, bodys map[int]ast.Node) (a
 	}
 
 	arity := -1
-	var where token.Token
+	// Parameters are located at the topmost copattern.
+	where := plists[searchTopmost(plists)][0].Base()
 	for _, patterns := range guards {
 		if arity == -1 {
 			arity = len(patterns)
@@ -390,7 +496,8 @@ func (f *Flat) buildLambda(plists map[int][]ast.Node, bodys map[int]ast.Node) (a
 		guards[i] = append(f.guards[i], ps...)
 	}
 
-	innerF := &Flat{uniq: f.uniq, scrutinees: append(f.scrutinees, scrutinees...), guards: guards}
+	path := append(slices.Clip(f.path), step{field: "", arity: arity})
+	innerF := f.inner(append(f.scrutinees, scrutinees...), guards, path, f.covered)
 	body, err := innerF.build(rest, bodys)
 	if err != nil {
 		return nil, err
@@ -410,6 +517,10 @@ func (e InvalidArityError) Error() string {
 	return fmt.Sprintf("invalid arity: %v", e.Guards)
 }
 
+func (InvalidArityError) Code() string {
+	return "E0303"
+}
+
 func popGuard(plists map[int][]ast.Node) (map[int][]ast.Node, map[int][]ast.Node, error) {
 	guards := make(map[int][]ast.Node)
 	rest := make(map[int][]ast.Node)
@@ -433,3 +544,7 @@ type UnexpectedPatternError struct {
 func (e UnexpectedPatternError) Error() string {
 	return fmt.Sprintf("unexpected pattern: %v", e.Pattern)
 }
+
+func (UnexpectedPatternError) Code() string {
+	return "E0304"
+}
@@ -1,14 +1,18 @@
 package codata_test
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/sebdah/goldie/v2"
+	"github.com/takoeight0821/anma/ast"
 	"github.com/takoeight0821/anma/codata"
-	"github.com/takoeight0821/anma/desugarwith"
 	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/parser"
 	"github.com/takoeight0821/anma/utils"
 )
 
@@ -31,24 +35,145 @@ func TestGolden(t *testing.T) {
 			return
 		}
 
-		runner := driver.NewPassRunner()
-		runner.AddPass(&desugarwith.DesugarWith{})
-		runner.AddPass(&codata.Flat{})
+		tokens, err := lexer.Lex(testfile, string(source))
+		if err != nil {
+			t.Errorf("failed to lex %s: %v", testfile, err)
 
-		nodes, err := runner.RunSource(testfile, string(source))
+			return
+		}
+		program, err := parser.NewParser(tokens).ParseDecl()
 		if err != nil {
-			t.Errorf("%s returned error: %v", testfile, err)
+			t.Errorf("failed to parse %s: %v", testfile, err)
 
 			return
 		}
 
+		// Copatterns are checked after resolving names, but the program is printed as Flat returns it.
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
+
 		var builder strings.Builder
-		for _, node := range nodes {
-			builder.WriteString(node.String())
-			builder.WriteString("\n")
+		_, err = runner.RunTrace(program, func(pass driver.Pass, nodes []ast.Node) {
+			if _, ok := pass.(*codata.Flat); !ok {
+				return
+			}
+			for _, node := range nodes {
+				builder.WriteString(node.String())
+				builder.WriteString("\n")
+			}
+		})
+		if err != nil {
+			t.Errorf("%s returned error: %v", testfile, err)
+
+			return
 		}
 
 		g := goldie.New(t)
 		g.Assert(t, testfile, []byte(builder.String()))
 	}
 }
+
+// TestWarnings checks warnings about copatterns in testdata/warnings against the .out file of each source.
+func TestWarnings(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := filepath.Glob("testdata/warnings/*.anma")
+	if err != nil {
+		t.Fatalf("failed to find test files: %v", err)
+	}
+
+	for _, testfile := range testfiles {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", testfile, err)
+		}
+		expected, err := os.ReadFile(testfile + ".out")
+		if err != nil {
+			t.Fatalf("failed to read the expected output of %s: %v", testfile, err)
+		}
+
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
+		if _, err := runner.RunSource(testfile, string(source)); err != nil {
+			t.Errorf("%s returned error: %v", testfile, err)
+
+			continue
+		}
+
+		var builder strings.Builder
+		for _, warning := range runner.Warnings() {
+			builder.WriteString(warning.String())
+			builder.WriteString("\n")
+		}
+		if actual := builder.String(); actual != string(expected) {
+			t.Errorf("%s: unexpected warnings\nexpected:\n%s\nactual:\n%s", testfile, expected, actual)
+		}
+	}
+}
+
+func TestNonExhaustive(t *testing.T) {
+	t.Parallel()
+
+	testfile := "../testdata/non_exhaustive.anma"
+	source, err := os.ReadFile(testfile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", testfile, err)
+	}
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckError, Until: driver.StageResolve, NoPrelude: true})
+
+	_, err = runner.RunSource(testfile, string(source))
+	var nonExhaustive codata.NonExhaustiveError
+	if !errors.As(err, &nonExhaustive) {
+		t.Fatalf("%s must be non-exhaustive, but returned %v", testfile, err)
+	}
+
+	g := goldie.New(t)
+	g.Assert(t, "non_exhaustive.anma.error", []byte(nonExhaustive.Error()))
+}
+
+// TestNonExhaustiveWarning checks that Flat collects warnings if no one receives them.
+func TestNonExhaustiveWarning(t *testing.T) {
+	t.Parallel()
+
+	testfile := "../testdata/non_exhaustive.anma"
+	source, err := os.ReadFile(testfile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", testfile, err)
+	}
+	tokens, err := lexer.Lex(testfile, string(source))
+	if err != nil {
+		t.Fatalf("failed to lex %s: %v", testfile, err)
+	}
+	nodes, err := parser.NewParser(tokens).ParseDecl()
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", testfile, err)
+	}
+
+	flat := codata.Flat{Exhaustive: codata.CheckWarn}
+	if err := flat.Init(nodes); err != nil {
+		t.Fatalf("%s returned error: %v", testfile, err)
+	}
+	nodes, err = flat.Run(nodes)
+	if err != nil {
+		t.Fatalf("%s returned error: %v", testfile, err)
+	}
+	// Coverage tells constructors apart by resolved names, but unresolved names of one program are enough.
+	coverage := flat.Coverage()
+	if err := coverage.Init(nodes); err != nil {
+		t.Fatalf("%s returned error: %v", testfile, err)
+	}
+	if _, err := coverage.Run(nodes); err != nil {
+		t.Fatalf("%s returned error: %v", testfile, err)
+	}
+
+	warnings := flat.Warnings()
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", warnings)
+	}
+	var nonExhaustive codata.NonExhaustiveWarning
+	if !errors.As(warnings[0].Err, &nonExhaustive) || nonExhaustive.Code() != "W0303" {
+		t.Errorf("expected a non-exhaustive warning W0303, got %v", warnings[0])
+	}
+	if warnings := flat.Warnings(); len(warnings) != 0 {
+		t.Errorf("expected warnings to be cleared, got %v", warnings)
+	}
+}
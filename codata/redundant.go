@@ -0,0 +1,204 @@
+package codata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/diag"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// UnreachableClauseError is a warning that is reported when a clause is never selected.
+type UnreachableClauseError struct{}
+
+func (UnreachableClauseError) Error() string {
+	return "unreachable clause"
+}
+
+func (UnreachableClauseError) Code() string {
+	return "W0301"
+}
+
+// ShadowedClauseError is a warning that is reported when some values of a clause are taken by a shorter clause.
+// For example, `#(0).h` takes `f(0).h` from `#(x).h.h`, whichever comes first.
+type ShadowedClauseError struct {
+	Example string
+	By      token.Location
+}
+
+func (e ShadowedClauseError) Error() string {
+	return fmt.Sprintf("clause is partially shadowed: `%s` is selected by the clause at %v", e.Example, e.By)
+}
+
+func (ShadowedClauseError) Code() string {
+	return "W0302"
+}
+
+func (e ShadowedClauseError) Spans() []diag.Span {
+	span := diag.SpanAt(e.By, 1)
+	span.Label = "this clause selects `" + e.Example + "`"
+
+	return []diag.Span{span}
+}
+
+func (f *Flat) SetWarn(warn func(utils.Warning)) {
+	f.warn = warn
+}
+
+// Warnings returns warnings collected since the last call.
+// Flat collects warnings only if no one receives them by [Flat.SetWarn].
+func (f *Flat) Warnings() []utils.Warning {
+	warnings := f.warnings
+	f.warnings = nil
+
+	return warnings
+}
+
+// collect keeps a warning for [Flat.Warnings].
+func (f *Flat) collect(warning utils.Warning) {
+	f.warnings = append(f.warnings, warning)
+}
+
+// report reports a warning.
+func (f *Flat) report(where token.Token, err error) {
+	f.warn(utils.Warning{Where: where, Err: err})
+}
+
+// pad normalizes rows and pads them with wildcards to the number of the scrutinees.
+func (f *Flat) pad(rows [][]ast.Node) [][]ast.Node {
+	padded := make([][]ast.Node, len(rows))
+	for i, row := range rows {
+		padded[i] = make([]ast.Node, len(f.scrutinees))
+		copy(padded[i], normalizeAll(row))
+	}
+
+	return padded
+}
+
+// checkRedundant reports clauses that end here but are never selected,
+// and clauses that continue but are partially shadowed by clauses that end here.
+// keys are indices of plists in order.
+func (f *Flat) checkRedundant(plists map[int][]ast.Node, keys []int) {
+	if len(f.scrutinees) == 0 {
+		// The topmost clause is always selected.
+		topmost := searchTopmost(plists)
+		for _, i := range keys {
+			if i != topmost {
+				f.report(f.locations[i], UnreachableClauseError{})
+			}
+		}
+
+		return
+	}
+
+	rows := f.pad(f.covered)
+	var ended []int
+	for _, i := range keys {
+		if len(plists[i]) != 0 {
+			continue
+		}
+		row := f.pad([][]ast.Node{f.guards[i]})[0]
+		if !f.useful(rows, row) {
+			f.report(f.locations[i], UnreachableClauseError{})
+		}
+		rows = append(rows, row)
+		ended = append(ended, i)
+	}
+
+	for _, i := range keys {
+		if len(plists[i]) == 0 || !f.useful(rows, f.pad([][]ast.Node{f.guards[i]})[0]) {
+			// Unreachable clauses are reported where they end.
+			continue
+		}
+		for _, j := range ended {
+			if example, ok := f.meet(f.guards[i], f.guards[j]); ok {
+				f.report(f.locations[i], ShadowedClauseError{Example: f.describe(example), By: f.locations[j].Location})
+
+				break
+			}
+		}
+	}
+}
+
+// meet returns examples of values that match both of ps and qs.
+func (f *Flat) meet(ps, qs []ast.Node) ([]string, bool) {
+	examples := make([]string, len(ps))
+	for i := range ps {
+		var ok bool
+		examples[i], ok = f.meetPattern(normalize(ps[i]), normalize(qs[i]))
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return examples, true
+}
+
+func (f *Flat) meetPattern(p, q ast.Node) (string, bool) {
+	if p == nil {
+		return show(q), true
+	}
+	if q == nil {
+		return show(p), true
+	}
+
+	switch p := p.(type) {
+	case *ast.Call:
+		q, ok := q.(*ast.Call)
+		if !ok || f.constructorOf(p).key != f.constructorOf(q).key || len(p.Args) != len(q.Args) {
+			return "", false
+		}
+		args, ok := f.meet(p.Args, q.Args)
+
+		return f.constructorOf(p).name + "(" + strings.Join(args, ", ") + ")", ok
+	case *ast.Tuple:
+		q, ok := q.(*ast.Tuple)
+		if !ok || len(p.Exprs) != len(q.Exprs) {
+			return "", false
+		}
+		elems, ok := f.meet(p.Exprs, q.Exprs)
+
+		return "[" + strings.Join(elems, ", ") + "]", ok
+	case *ast.Literal:
+		q, ok := q.(*ast.Literal)
+
+		return show(p), ok && literalKey(p) == literalKey(q)
+	}
+
+	return "", false
+}
+
+// show renders the pattern. Variables are rendered as `_`.
+func show(pattern ast.Node) string {
+	switch p := normalize(pattern).(type) {
+	case *ast.Call:
+		name := "_"
+		if v, ok := p.Func.(*ast.Var); ok {
+			name = v.Name.Lexeme
+		}
+
+		return name + "(" + strings.Join(showAll(p.Args), ", ") + ")"
+	case *ast.Tuple:
+		return "[" + strings.Join(showAll(p.Exprs), ", ") + "]"
+	case *ast.Literal:
+		if s, ok := p.Literal.(string); ok {
+			return strconv.Quote(s)
+		}
+
+		return p.Lexeme
+	default:
+		return "_"
+	}
+}
+
+func showAll(patterns []ast.Node) []string {
+	shown := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		shown[i] = show(pattern)
+	}
+
+	return shown
+}
@@ -6,7 +6,7 @@ import (
 	"testing"
 
 	"github.com/sebdah/goldie/v2"
-	"github.com/takoeight0821/anma/desugarwith"
+	"github.com/takoeight0821/anma/codata"
 	"github.com/takoeight0821/anma/driver"
 	"github.com/takoeight0821/anma/utils"
 )
@@ -29,8 +29,7 @@ func TestGolden(t *testing.T) {
 			return
 		}
 
-		runner := driver.NewPassRunner()
-		runner.AddPass(&desugarwith.DesugarWith{})
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageDesugarWith, NoPrelude: true})
 
 		nodes, err := runner.RunSource(testfile, string(source))
 		if err != nil {
@@ -0,0 +1,213 @@
+// Package diag converts errors and warnings of all passes into structured diagnostics,
+// and renders them with the offending source lines.
+//
+// Errors report their codes by implementing [Coder]. Codes are grouped by the package that reports them:
+//
+//	E01xx lexer
+//	E02xx parser
+//	E03xx codata
+//	E04xx infix
+//	E05xx nameresolve
+//	E06xx typecheck
+//	E07xx eval
+//	E08xx vm
+//	E09xx module
+//
+// Kinds of warnings use the same ranges with the prefix W.
+// An error reported as a warning, such as non-exhaustive copatterns with `-exhaustive=warn`, keeps its code.
+package diag
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// Severity is the seriousness of a [Diagnostic].
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Span is a range of source code on a line.
+// End is the column just after the range.
+type Span struct {
+	Start token.Location
+	End   token.Location
+	Label string // An optional message shown under the span.
+}
+
+// SpanOf returns the span of the token. A multi-line token spans to the end of its first line.
+func SpanOf(t token.Token) Span {
+	width := 0
+	for _, r := range t.Lexeme {
+		if r == '\n' {
+			break
+		}
+		width++
+	}
+
+	return SpanAt(t.Location, width)
+}
+
+// SpanAt returns the span of width runes from loc. The width is at least 1.
+func SpanAt(loc token.Location, width int) Span {
+	end := loc
+	end.Column += max(width, 1)
+
+	return Span{Start: loc, End: end, Label: ""}
+}
+
+// IsZero reports whether the span has no position.
+func (s Span) IsZero() bool {
+	return s.Start.Line == 0
+}
+
+// Diagnostic is an error or a warning with positions and notes.
+type Diagnostic struct {
+	Severity  Severity
+	Code      string // A stable identifier of the kind of the problem, such as "E0501". It may be empty.
+	Message   string
+	Primary   Span // The position of the problem. It is zero if unknown.
+	Secondary []Span
+	Notes     []string
+}
+
+// Error returns a one-line summary like `file:1:2: error[E0501]: message`.
+func (d Diagnostic) Error() string {
+	header := d.header()
+	if d.Primary.IsZero() {
+		return header
+	}
+
+	return fmt.Sprintf("%v: %s", d.Primary.Start, header)
+}
+
+func (d Diagnostic) header() string {
+	if d.Code == "" {
+		return fmt.Sprintf("%v: %s", d.Severity, d.Message)
+	}
+
+	return fmt.Sprintf("%v[%s]: %s", d.Severity, d.Code, d.Message)
+}
+
+// Errors may implement the following interfaces to add details to their diagnostics.
+
+// Coder is an error with a stable code.
+type Coder interface {
+	Code() string
+}
+
+// Spanner is an error that points to other positions related to the problem.
+type Spanner interface {
+	Spans() []Span
+}
+
+// Noter is an error with additional explanations.
+type Noter interface {
+	Notes() []string
+}
+
+// FromError converts err into diagnostics.
+// Errors joined by [errors.Join] become separate diagnostics,
+// and each diagnostic is placed at the innermost [utils.PosError] around it.
+func FromError(err error) []Diagnostic {
+	return collect(err, nil)
+}
+
+// FromWarning converts a warning into a diagnostic.
+func FromWarning(warning utils.Warning) Diagnostic {
+	d := fromLeaf(warning.Err, &warning.Where)
+	d.Severity = Warning
+
+	return d
+}
+
+func collect(err error, where *token.Token) []Diagnostic {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case Diagnostic:
+		return []Diagnostic{e}
+	case utils.PosError:
+		return collect(e.Err, &e.Where)
+	case interface{ Unwrap() []error }:
+		var diagnostics []Diagnostic
+		for _, err := range e.Unwrap() {
+			diagnostics = append(diagnostics, collect(err, where)...)
+		}
+
+		return diagnostics
+	}
+
+	// Look through wrappers such as fmt.Errorf for positions and joined errors.
+	if inner := errors.Unwrap(err); inner != nil && (hasPosition(inner) || isJoined(inner)) {
+		return collect(inner, where)
+	}
+
+	return []Diagnostic{fromLeaf(err, where)}
+}
+
+func hasPosition(err error) bool {
+	var posErr utils.PosError
+	var diagnostic Diagnostic
+
+	return errors.As(err, &posErr) || errors.As(err, &diagnostic)
+}
+
+func isJoined(err error) bool {
+	for err != nil {
+		if _, ok := err.(interface{ Unwrap() []error }); ok {
+			return true
+		}
+		err = errors.Unwrap(err)
+	}
+
+	return false
+}
+
+func fromLeaf(err error, where *token.Token) Diagnostic {
+	d := Diagnostic{
+		Severity:  Error,
+		Code:      "",
+		Message:   err.Error(),
+		Primary:   Span{Start: token.Location{}, End: token.Location{}, Label: ""},
+		Secondary: nil,
+		Notes:     nil,
+	}
+	if where != nil && where.Kind != token.EOF {
+		d.Primary = SpanOf(*where)
+	} else if where != nil {
+		d.Primary = SpanAt(where.Location, 1)
+	}
+
+	var coder Coder
+	if errors.As(err, &coder) {
+		d.Code = coder.Code()
+	}
+	var spanner Spanner
+	if errors.As(err, &spanner) {
+		d.Secondary = spanner.Spans()
+	}
+	var noter Noter
+	if errors.As(err, &noter) {
+		d.Notes = noter.Notes()
+	}
+
+	return d
+}
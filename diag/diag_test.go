@@ -0,0 +1,92 @@
+package diag_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/diag"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/module"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+func TestGolden(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := utils.FindSourceFiles("testdata")
+	if err != nil {
+		t.Errorf("failed to find test files: %v", err)
+
+		return
+	}
+
+	for _, testfile := range testfiles {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", testfile, err)
+
+			return
+		}
+
+		var diagnostics []diag.Diagnostic
+
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckError, Until: driver.StageTypecheck})
+
+		nodes, err := runner.RunSource(testfile, string(source))
+		for _, warning := range runner.Warnings() {
+			diagnostics = append(diagnostics, diag.FromWarning(warning))
+		}
+		if err == nil {
+			err = run(nodes)
+		}
+		diagnostics = append(diagnostics, diag.FromError(err)...)
+
+		sources := diag.Sources{testfile: string(source)}
+		rendered := make([]string, len(diagnostics))
+		for i, d := range diagnostics {
+			rendered[i] = sources.Render(d)
+		}
+		var jsonLines strings.Builder
+		if err := diag.WriteJSON(&jsonLines, diagnostics); err != nil {
+			t.Errorf("%s failed to write JSON: %v", testfile, err)
+		}
+
+		g := goldie.New(t)
+		g.Assert(t, filepath.Base(testfile), []byte(strings.Join(rendered, "\n\n")+"\n"))
+		g.Assert(t, filepath.Base(testfile)+".json", []byte(jsonLines.String()))
+	}
+}
+
+// run evaluates the program and calls main. Exiting is not an error.
+func run(nodes []ast.Node) error {
+	evaluator := eval.NewEvaluator()
+	evaluator.Stdout = io.Discard
+	evaluator.Stdin = strings.NewReader("")
+	for _, node := range nodes {
+		if _, err := evaluator.Eval(node); err != nil {
+			return err
+		}
+	}
+	name, _ := module.MainName(nodes)
+	main, ok := evaluator.SearchMain(name)
+	if !ok {
+		return nil
+	}
+	top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
+	_, err := main.Apply(top)
+	var exitErr eval.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonPosition is a position in the JSON output. Lines and columns are 1-based.
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// jsonSpan is a [Span] in the JSON output. End is the position just after the span.
+type jsonSpan struct {
+	File   string        `json:"file,omitempty"`
+	Line   int           `json:"line,omitempty"`
+	Column int           `json:"column,omitempty"`
+	End    *jsonPosition `json:"end,omitempty"`
+	Label  string        `json:"label,omitempty"`
+}
+
+type jsonDiagnostic struct {
+	jsonSpan
+
+	Severity  string     `json:"severity"`
+	Code      string     `json:"code,omitempty"`
+	Message   string     `json:"message"`
+	Secondary []jsonSpan `json:"secondary,omitempty"`
+	Notes     []string   `json:"notes,omitempty"`
+}
+
+func toJSONSpan(s Span) jsonSpan {
+	if s.IsZero() {
+		return jsonSpan{File: "", Line: 0, Column: 0, End: nil, Label: s.Label}
+	}
+
+	return jsonSpan{
+		File:   s.Start.FilePath,
+		Line:   s.Start.Line,
+		Column: s.Start.Column,
+		End:    &jsonPosition{Line: s.End.Line, Column: s.End.Column},
+		Label:  s.Label,
+	}
+}
+
+// MarshalJSON encodes the diagnostic as an object like
+//
+//	{"file":"main.anma","line":3,"column":9,"end":{"line":3,"column":10},
+//	 "severity":"error","code":"E0501","message":"x is not defined"}
+//
+// Position fields are omitted if the position is unknown.
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	secondary := make([]jsonSpan, 0, len(d.Secondary))
+	for _, span := range d.Secondary {
+		if !span.IsZero() {
+			secondary = append(secondary, toJSONSpan(span))
+		}
+	}
+
+	primary := toJSONSpan(d.Primary)
+	primary.Label = ""
+
+	bytes, err := json.Marshal(jsonDiagnostic{
+		jsonSpan:  primary,
+		Severity:  d.Severity.String(),
+		Code:      d.Code,
+		Message:   d.Message,
+		Secondary: secondary,
+		Notes:     d.Notes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal diagnostic: %w", err)
+	}
+
+	return bytes, nil
+}
+
+// WriteJSON writes the diagnostics to w as JSON Lines, one object per line.
+func WriteJSON(w io.Writer, diagnostics []Diagnostic) error {
+	encoder := json.NewEncoder(w)
+	for _, d := range diagnostics {
+		if err := encoder.Encode(d); err != nil {
+			return fmt.Errorf("write diagnostics: %w", err)
+		}
+	}
+
+	return nil
+}
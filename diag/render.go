@@ -0,0 +1,204 @@
+package diag
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Sources maps file paths to their contents. It renders diagnostics with the source lines.
+type Sources map[string]string
+
+// tabWidth is the width of a tab in rendered source lines.
+const tabWidth = 4
+
+type mark struct {
+	span    Span
+	primary bool
+}
+
+// Render renders the diagnostic like rustc:
+//
+//	error[E0501]: `x` is not defined
+//	 --> main.anma:3:9
+//	  |
+//	3 |     foo(x)
+//	  |         ^
+//	  = note: ...
+//
+// The primary span is underlined with `^`, and secondary spans with `-`.
+// Spans in files not in s are omitted.
+func (s Sources) Render(d Diagnostic) string {
+	var builder strings.Builder
+	builder.WriteString(d.header())
+	builder.WriteString("\n")
+
+	marks := []mark{}
+	if !d.Primary.IsZero() {
+		marks = append(marks, mark{span: d.Primary, primary: true})
+	}
+	for _, span := range d.Secondary {
+		if !span.IsZero() {
+			marks = append(marks, mark{span: span, primary: false})
+		}
+	}
+
+	width := 1
+	for _, m := range marks {
+		width = max(width, len(strconv.Itoa(m.span.Start.Line)))
+	}
+	gutter := strings.Repeat(" ", width)
+
+	if !d.Primary.IsZero() {
+		fmt.Fprintf(&builder, "%s--> %v\n", gutter, d.Primary.Start)
+	}
+
+	s.renderLines(&builder, gutter, d.Primary, marks)
+
+	for _, note := range d.Notes {
+		fmt.Fprintf(&builder, "%s = note: %s\n", gutter, note)
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// renderLines renders source lines with marks, grouped by line.
+// Lines in the file of the primary span come first.
+func (s Sources) renderLines(builder *strings.Builder, gutter string, primary Span, marks []mark) {
+	slices.SortStableFunc(marks, func(a, b mark) int {
+		aOther := a.span.Start.FilePath != primary.Start.FilePath
+		bOther := b.span.Start.FilePath != primary.Start.FilePath
+		if aOther != bOther {
+			if aOther {
+				return 1
+			}
+
+			return -1
+		}
+
+		return cmp.Or(
+			cmp.Compare(a.span.Start.FilePath, b.span.Start.FilePath),
+			cmp.Compare(a.span.Start.Line, b.span.Start.Line),
+			cmp.Compare(a.span.Start.Column, b.span.Start.Column),
+		)
+	})
+
+	file, line := "", 0
+	started := false
+	for i := 0; i < len(marks); {
+		start := marks[i].span.Start
+		j := i
+		for j < len(marks) && marks[j].span.Start.FilePath == start.FilePath && marks[j].span.Start.Line == start.Line {
+			j++
+		}
+		group := marks[i:j]
+		i = j
+
+		text, ok := s.line(start.FilePath, start.Line)
+		if !ok {
+			continue
+		}
+		switch {
+		case !started:
+			fmt.Fprintf(builder, "%s |\n", gutter)
+		case file != start.FilePath:
+			fmt.Fprintf(builder, "%s::: %v\n", gutter, start)
+		case start.Line > line+1:
+			builder.WriteString("...\n")
+		}
+		started = true
+		file, line = start.FilePath, start.Line
+
+		source := fmt.Sprintf("%*d | %s", len(gutter), start.Line, expandTabs(text))
+		builder.WriteString(strings.TrimRight(source, " ") + "\n")
+		for _, markLine := range markLines(text, group) {
+			fmt.Fprintf(builder, "%s | %s\n", gutter, markLine)
+		}
+	}
+}
+
+// line returns the line of the file without the newline.
+func (s Sources) line(path string, line int) (string, bool) {
+	source, ok := s[path]
+	if !ok || line < 1 {
+		return "", false
+	}
+	lines := strings.Split(source, "\n")
+	if line > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimSuffix(lines[line-1], "\r"), true
+}
+
+// markLines returns lines that underline the marks on text, followed by their labels.
+// The rightmost label is on the same line as the underlines, and the others are on the following lines.
+func markLines(text string, marks []mark) []string {
+	columns := displayColumns(text)
+	column := func(col int) int {
+		if col-1 < len(columns) {
+			return columns[col-1]
+		}
+
+		// After the end of the line, such as the end of file.
+		return columns[len(columns)-1] + col - len(columns)
+	}
+
+	var underline []rune
+	for _, m := range marks {
+		start := column(m.span.Start.Column)
+		end := start + 1
+		if m.span.End.Line == m.span.Start.Line && m.span.End.Column > m.span.Start.Column {
+			end = max(end, column(m.span.End.Column))
+		}
+		for len(underline) < end {
+			underline = append(underline, ' ')
+		}
+		char := '-'
+		if m.primary {
+			char = '^'
+		}
+		for k := start; k < end; k++ {
+			if underline[k] != '^' {
+				underline[k] = char
+			}
+		}
+	}
+
+	lines := []string{string(underline)}
+	for k := len(marks) - 1; k >= 0; k-- {
+		label := marks[k].span.Label
+		if label == "" {
+			continue
+		}
+		if k == len(marks)-1 {
+			lines[0] += " " + label
+		} else {
+			lines = append(lines, strings.Repeat(" ", column(marks[k].span.Start.Column))+label)
+		}
+	}
+
+	return lines
+}
+
+// displayColumns returns the 0-based display column of each rune of text and of the end of text.
+func displayColumns(text string) []int {
+	var columns []int
+	col := 0
+	for _, r := range text {
+		columns = append(columns, col)
+		if r == '\t' {
+			col += tabWidth
+		} else {
+			col++
+		}
+	}
+
+	return append(columns, col)
+}
+
+func expandTabs(text string) string {
+	return strings.ReplaceAll(text, "\t", strings.Repeat(" ", tabWidth))
+}
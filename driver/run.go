@@ -5,7 +5,10 @@ import (
 
 	"github.com/takoeight0821/anma/ast"
 	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/module"
 	"github.com/takoeight0821/anma/parser"
+	"github.com/takoeight0821/anma/prelude"
+	"github.com/takoeight0821/anma/utils"
 )
 
 type Pass interface {
@@ -14,22 +17,113 @@ type Pass interface {
 	Run(program []ast.Node) ([]ast.Node, error)
 }
 
+// Warner is a [Pass] that reports warnings.
+// PassRunner gives the pass a function to report warnings when it is added.
+type Warner interface {
+	Pass
+	SetWarn(warn func(utils.Warning))
+}
+
+// Snapshotter is a [Pass] that keeps state across programs, such as declarations of earlier inputs of the REPL.
+type Snapshotter interface {
+	Pass
+	// Snapshot returns a function that restores the current state.
+	Snapshot() (restore func())
+}
+
 type PassRunner struct {
-	passes []Pass
+	passes   []Pass
+	warnings []utils.Warning
+	prelude  bool // Whether to run the prelude before the next program.
+	loaded   int  // Number of declarations of the prelude at the beginning of the last result.
 }
 
 func NewPassRunner() *PassRunner {
-	return &PassRunner{passes: make([]Pass, 0)}
+	return &PassRunner{passes: make([]Pass, 0), warnings: nil, prelude: false, loaded: 0}
+}
+
+// LoadPrelude makes the runner run the prelude before the next program.
+// The result of the program is preceded by the declarations of the prelude, which it can refer to.
+func (r *PassRunner) LoadPrelude() {
+	r.prelude = true
 }
 
 // AddPass adds a pass to the end of the pass list.
 func (r *PassRunner) AddPass(pass Pass) {
+	if warner, ok := pass.(Warner); ok {
+		warner.SetWarn(r.warn)
+	}
 	r.passes = append(r.passes, pass)
 }
 
+// Snapshot returns a function that restores the state of all passes,
+// so that a program that fails does not leave its declarations in later programs.
+func (r *PassRunner) Snapshot() (restore func()) {
+	var restores []func()
+	for _, pass := range r.passes {
+		if snapshotter, ok := pass.(Snapshotter); ok {
+			restores = append(restores, snapshotter.Snapshot())
+		}
+	}
+
+	return func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+func (r *PassRunner) warn(warning utils.Warning) {
+	r.warnings = append(r.warnings, warning)
+}
+
+// Warnings returns warnings reported since the last call.
+// Warnings do not stop the execution.
+func (r *PassRunner) Warnings() []utils.Warning {
+	warnings := r.warnings
+	r.warnings = nil
+
+	return warnings
+}
+
 // Run executes passes in order.
 // If an error occurs, it stops the execution and returns the current program.
 func (r *PassRunner) Run(program []ast.Node) ([]ast.Node, error) {
+	return r.RunTrace(program, func(Pass, []ast.Node) {})
+}
+
+// RunTrace is like [PassRunner.Run], but calls trace with the program after each pass.
+// The prelude is not traced.
+func (r *PassRunner) RunTrace(program []ast.Node, trace func(pass Pass, program []ast.Node)) ([]ast.Node, error) {
+	r.loaded = 0
+	if !r.prelude {
+		return r.runPasses(program, trace)
+	}
+
+	loaded, err := prelude.Parse()
+	if err != nil {
+		return program, fmt.Errorf("prelude: %w", err)
+	}
+	loaded, err = r.runPasses(loaded, func(Pass, []ast.Node) {})
+	if err != nil {
+		return program, fmt.Errorf("prelude: %w", err)
+	}
+	r.prelude = false
+	r.loaded = len(loaded)
+	program, err = r.runPasses(program, trace)
+
+	return append(loaded, program...), err
+}
+
+// SplitPrelude splits the result of the last run into the declarations of the prelude and the others.
+// The prelude is empty unless the run loaded it.
+func (r *PassRunner) SplitPrelude(program []ast.Node) ([]ast.Node, []ast.Node) {
+	n := min(r.loaded, len(program))
+
+	return program[:n], program[n:]
+}
+
+func (r *PassRunner) runPasses(program []ast.Node, trace func(pass Pass, program []ast.Node)) ([]ast.Node, error) {
 	for _, pass := range r.passes {
 		err := pass.Init(program)
 		if err != nil {
@@ -39,6 +133,7 @@ func (r *PassRunner) Run(program []ast.Node) ([]ast.Node, error) {
 		if err != nil {
 			return program, fmt.Errorf("%s run: %w", pass.Name(), err)
 		}
+		trace(pass, program)
 	}
 
 	return program, nil
@@ -51,10 +146,29 @@ func (r *PassRunner) RunSource(filePath, source string) ([]ast.Node, error) {
 		return nil, fmt.Errorf("lex: %w", err)
 	}
 
-	decls, err := parser.NewParser(tokens).ParseDecl()
+	p := parser.NewParser(tokens)
+	decls, err := p.ParseDecl()
+	r.warnings = append(r.warnings, p.Warnings()...)
 	if err != nil {
 		return nil, fmt.Errorf("parse: %w", err)
 	}
 
 	return r.Run(decls)
 }
+
+// RunModule loads the module of the file and the modules it imports, and executes passes in order.
+// It also returns the sources of the loaded files, which are available even if it fails.
+func (r *PassRunner) RunModule(path string) ([]ast.Node, map[string]string, error) {
+	program, err := module.Load(path)
+	r.warnings = append(r.warnings, program.Warnings...)
+	if err != nil {
+		return nil, program.Sources, err
+	}
+
+	if r.prelude {
+		program.Sources[prelude.Path] = prelude.Source
+	}
+	nodes, err := r.Run(program.Nodes)
+
+	return nodes, program.Sources, err
+}
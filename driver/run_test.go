@@ -0,0 +1,43 @@
+package driver_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/driver"
+)
+
+// TestSplitPrelude checks that the program after each stage can be printed without the prelude, as `anma dump` does.
+func TestSplitPrelude(t *testing.T) {
+	t.Parallel()
+
+	const testfile = "../testdata/curry.anma"
+	source, err := os.ReadFile(testfile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", testfile, err)
+	}
+
+	for _, stage := range []driver.Stage{driver.StageParse, driver.StageResolve} {
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: stage})
+		nodes, err := runner.RunSource(testfile, string(source))
+		if err != nil {
+			t.Fatalf("%s returned error: %v", testfile, err)
+		}
+		prelude, program := runner.SplitPrelude(nodes)
+		if len(prelude) == 0 || !strings.HasPrefix(prelude[0].String(), "(module prelude") {
+			t.Errorf("%v: expected the prelude first, got %v", stage, prelude)
+		}
+
+		var builder strings.Builder
+		for _, node := range program {
+			builder.WriteString(node.String())
+			builder.WriteString("\n")
+		}
+
+		g := goldie.New(t)
+		g.Assert(t, "curry."+stage.String(), []byte(builder.String()))
+	}
+}
@@ -0,0 +1,95 @@
+package driver
+
+import (
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/desugarwith"
+	"github.com/takoeight0821/anma/infix"
+	"github.com/takoeight0821/anma/nameresolve"
+	"github.com/takoeight0821/anma/typecheck"
+)
+
+// Stage is a point of the standard pipeline, after which the program can be inspected.
+type Stage int
+
+const (
+	StageParse       Stage = iota // After parsing, before any pass.
+	StageDesugarWith              // After desugaring `with`.
+	StageFlat                     // After flattening codata.
+	StageInfix                    // After resolving infix operators.
+	StageResolve                  // After resolving names and checking copatterns.
+	StageTypecheck                // After checking types. The program is the same as [StageResolve].
+)
+
+var stageNames = []string{"parse", "desugarwith", "flat", "infix", "resolve", "typecheck"}
+
+func (s Stage) String() string {
+	return stageNames[s]
+}
+
+// ParseStage returns the stage of the name, such as "flat".
+func ParseStage(name string) (Stage, error) {
+	for i, stageName := range stageNames {
+		if stageName == name {
+			return Stage(i), nil
+		}
+	}
+
+	return StageParse, UnknownStageError{Name: name}
+}
+
+// Options configures the standard pipeline.
+type Options struct {
+	Exhaustive codata.CheckMode // How to report non-exhaustive copatterns.
+	Until      Stage            // The last stage to run.
+	// Session makes the runner keep definitions of earlier programs for later ones, as in the REPL.
+	// A later top-level declaration shadows an earlier one of the same name.
+	Session bool
+	// NoPrelude makes the runner not load the prelude before the first program.
+	NoPrelude bool
+}
+
+// NewStandardRunner returns a [PassRunner] with the passes of Anma in order until options.Until:
+// desugaring `with`, flattening codata, resolving infix operators, resolving names, checking copatterns
+// and checking types.
+// Copatterns are checked after resolving names, so warnings and errors of them are reported from [StageResolve].
+// Unless options.NoPrelude, the runner loads the prelude before the first program.
+func NewStandardRunner(options Options) *PassRunner {
+	resolver := nameresolve.NewResolver()
+	// Programs shadow the prelude as later inputs of the REPL shadow earlier ones.
+	resolver.Shadow = options.Session || !options.NoPrelude
+	flat := &codata.Flat{Exhaustive: options.Exhaustive}
+	// Passes of each stage after StageParse.
+	stages := [][]Pass{
+		{&desugarwith.DesugarWith{}},
+		{flat},
+		{infix.NewInfixResolver()},
+		{resolver, flat.Coverage()},
+		{typecheck.NewChecker()},
+	}
+
+	runner := NewPassRunner()
+	for _, passes := range stages[:options.Until] {
+		for _, pass := range passes {
+			runner.AddPass(pass)
+		}
+	}
+	if !options.NoPrelude {
+		runner.LoadPrelude()
+	}
+
+	return runner
+}
+
+// Passes returns the passes in order.
+func (r *PassRunner) Passes() []Pass {
+	return r.passes
+}
+
+// UnknownStageError is an error that is returned when a stage name is unknown.
+type UnknownStageError struct {
+	Name string
+}
+
+func (e UnknownStageError) Error() string {
+	return "unknown stage: " + e.Name
+}
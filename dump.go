@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/takoeight0821/anma/diag"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/lexer"
+)
+
+// driverStages returns the names of stages of [driver.NewStandardRunner].
+func driverStages() string {
+	names := make([]string, 0, driver.StageTypecheck+1)
+	for stage := driver.StageParse; stage <= driver.StageTypecheck; stage++ {
+		names = append(names, stage.String())
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// dumpCommand prints the tokens of a file, or the program after a stage.
+func dumpCommand(args []string) int {
+	flags := newFlagSet("dump")
+	var passFlags passFlags
+	passFlags.register(flags)
+	var stageName string
+	flags.StringVar(&stageName, "stage", "", "the stage to print: tokens, "+driverStages())
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	exhaustive, format, err := passFlags.parse()
+	if err != nil {
+		return usageError(flags, err)
+	}
+	if flags.NArg() != 1 {
+		return usageError(flags, errors.New("expected one file"))
+	}
+	var stage driver.Stage
+	if stageName != "tokens" {
+		stage, err = driver.ParseStage(stageName)
+		if err != nil {
+			return usageError(flags, err)
+		}
+	}
+
+	path := flags.Arg(0)
+	if stageName == "tokens" {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+			return exitError
+		}
+		report := reporter{format: format, sources: diag.Sources{path: string(source)}}
+		tokens, err := lexer.Lex(path, string(source))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, report.error(err))
+
+			return exitError
+		}
+		for _, t := range tokens {
+			fmt.Printf("%v %q %v\n", t.Kind, t.Lexeme, t.Location)
+		}
+
+		return exitOK
+	}
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: exhaustive, Until: stage, NoPrelude: passFlags.noPrelude})
+	nodes, sources, err := runner.RunModule(path)
+	report := reporter{format: format, sources: sources}
+	report.warnings(runner.Warnings())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, report.error(err))
+
+		return exitError
+	}
+	// The prelude is the same for every file.
+	_, nodes = runner.SplitPrelude(nodes)
+	for _, node := range nodes {
+		fmt.Println(node)
+	}
+
+	return exitOK
+}
@@ -16,6 +16,10 @@ func (e UndefinedVariableError) Error() string {
 	return fmt.Sprintf("undefined variable `%v`", e.Name)
 }
 
+func (UndefinedVariableError) Code() string {
+	return "E0701"
+}
+
 // InvalidLiteralError is an error that is returned when a token of given literal is invalid.
 type InvalidLiteralError struct {
 	Kind token.Kind
@@ -25,6 +29,10 @@ func (e InvalidLiteralError) Error() string {
 	return fmt.Sprintf("invalid literal `%v`", e.Kind)
 }
 
+func (InvalidLiteralError) Code() string {
+	return "E0702"
+}
+
 // UndefinedFieldError is an error that is returned when a field is not defined.
 type UndefinedFieldError struct {
 	Receiver Object
@@ -35,6 +43,10 @@ func (e UndefinedFieldError) Error() string {
 	return fmt.Sprintf("undefined field `%v` of %s", e.Name, e.Receiver)
 }
 
+func (UndefinedFieldError) Code() string {
+	return "E0703"
+}
+
 type NotObjectError struct {
 	Receiver Value
 }
@@ -43,6 +55,10 @@ func (e NotObjectError) Error() string {
 	return fmt.Sprintf("not an object: %v", e.Receiver)
 }
 
+func (NotObjectError) Code() string {
+	return "E0704"
+}
+
 // InvalidIndexError is an error that is returned when the number of arguments is not equal to expected.
 type InvalidArgumentCountError struct {
 	Expected int
@@ -53,6 +69,10 @@ func (e InvalidArgumentCountError) Error() string {
 	return fmt.Sprintf("invalid argument count: expected %d, actual %d", e.Expected, e.Actual)
 }
 
+func (InvalidArgumentCountError) Code() string {
+	return "E0705"
+}
+
 // NotCallableError is an error that is returned when a value is not Callable.
 type NotCallableError struct {
 	Func Value
@@ -62,6 +82,10 @@ func (e NotCallableError) Error() string {
 	return fmt.Sprintf("not a function: %v", e.Func)
 }
 
+func (NotCallableError) Code() string {
+	return "E0706"
+}
+
 // InvalidArgumentTypeError is an error that is returned when the type of argument is not equal to expected.
 type InvalidArgumentTypeError struct {
 	Expected string
@@ -72,6 +96,10 @@ func (e InvalidArgumentTypeError) Error() string {
 	return fmt.Sprintf("invalid argument type: expected %s, actual %v", e.Expected, e.Actual)
 }
 
+func (InvalidArgumentTypeError) Code() string {
+	return "E0707"
+}
+
 // UndefinedPrimError is an error that is returned when a primitive operator is not defined.
 type UndefinedPrimError struct {
 	Name token.Token
@@ -81,6 +109,10 @@ func (e UndefinedPrimError) Error() string {
 	return fmt.Sprintf("undefined prim `%v`", e.Name)
 }
 
+func (UndefinedPrimError) Code() string {
+	return "E0708"
+}
+
 // PatternMatchError is an error that is returned when a pattern match failed.
 type PatternMatchError struct {
 	Patterns []ast.Node
@@ -91,6 +123,10 @@ func (e PatternMatchError) Error() string {
 	return fmt.Sprintf("pattern match failed: %v = %v", e.Patterns, e.Values)
 }
 
+func (PatternMatchError) Code() string {
+	return "E0709"
+}
+
 // NotConstructorError is an error that is returned when a value is not Constructor.
 type NotConstructorError struct {
 	Node ast.Node
@@ -99,3 +135,92 @@ type NotConstructorError struct {
 func (e NotConstructorError) Error() string {
 	return fmt.Sprintf("not a constructor: %v", e.Node)
 }
+
+func (NotConstructorError) Code() string {
+	return "E0710"
+}
+
+// DivisionByZeroError is an error that is returned when an Int is divided by zero.
+type DivisionByZeroError struct{}
+
+func (DivisionByZeroError) Error() string {
+	return "division by zero"
+}
+
+func (DivisionByZeroError) Code() string {
+	return "E0711"
+}
+
+// IndexOutOfRangeError is an error that is returned when an index is out of the string.
+type IndexOutOfRangeError struct {
+	Index  Int
+	Length int
+}
+
+func (e IndexOutOfRangeError) Error() string {
+	return fmt.Sprintf("index out of range: %v, length %d", e.Index, e.Length)
+}
+
+func (IndexOutOfRangeError) Code() string {
+	return "E0712"
+}
+
+// ParseIntError is an error that is returned when a string is not an integer.
+type ParseIntError struct {
+	Input string
+}
+
+func (e ParseIntError) Error() string {
+	return fmt.Sprintf("cannot parse %q as Int", e.Input)
+}
+
+func (ParseIntError) Code() string {
+	return "E0713"
+}
+
+// FuelExhaustedError is an error that is returned when the evaluation takes more steps than [Limits.Fuel].
+type FuelExhaustedError struct {
+	Location token.Location
+	Fuel     int
+}
+
+func (e FuelExhaustedError) Error() string {
+	return fmt.Sprintf("fuel exhausted after %d steps at %v", e.Fuel, e.Location)
+}
+
+func (FuelExhaustedError) Code() string {
+	return "E0714"
+}
+
+// StackDepthError is an error that is returned when calls are nested deeper than [Limits.Depth].
+type StackDepthError struct {
+	Location token.Location
+	Depth    int
+}
+
+func (e StackDepthError) Error() string {
+	return fmt.Sprintf("call depth exceeds %d at %v", e.Depth, e.Location)
+}
+
+func (StackDepthError) Code() string {
+	return "E0715"
+}
+
+// CanceledError is an error that is returned when the context of the evaluation is done.
+// It wraps the cause, such as [context.DeadlineExceeded].
+type CanceledError struct {
+	Location token.Location
+	Err      error
+}
+
+func (e CanceledError) Error() string {
+	return fmt.Sprintf("evaluation canceled at %v: %v", e.Location, e.Err)
+}
+
+func (CanceledError) Code() string {
+	return "E0716"
+}
+
+func (e CanceledError) Unwrap() error {
+	return e.Err
+}
@@ -3,6 +3,7 @@ package eval
 import (
 	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/takoeight0821/anma/ast"
 	"github.com/takoeight0821/anma/token"
@@ -11,6 +12,12 @@ import (
 
 // Eval evaluates the given node and returns the result.
 func (ev *Evaluator) Eval(node ast.Node) (Value, error) {
+	return ev.trampoline(ev.evalTail(node))
+}
+
+// evalTail evaluates the given node, but calls in tail position are returned as [TailCall] without calling.
+// Subexpressions not in tail position are evaluated by [Evaluator.Eval].
+func (ev *Evaluator) evalTail(node ast.Node) (Value, error) {
 	switch node := node.(type) {
 	case *ast.Var:
 		return ev.evalVar(node)
@@ -34,9 +41,13 @@ func (ev *Evaluator) Eval(node ast.Node) (Value, error) {
 		return Unit(), ev.evalLet(node)
 	case *ast.Seq:
 		var result Value
-		for _, expr := range node.Exprs {
+		for i, expr := range node.Exprs {
 			var err error
-			result, err = ev.Eval(expr)
+			if i == len(node.Exprs)-1 {
+				result, err = ev.evalTail(expr)
+			} else {
+				result, err = ev.Eval(expr)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -59,7 +70,7 @@ func (ev *Evaluator) Eval(node ast.Node) (Value, error) {
 		return Unit(), ev.evalTypeDecl(node)
 	case *ast.VarDecl:
 		return Unit(), ev.evalVarDecl(node)
-	case *ast.InfixDecl:
+	case *ast.InfixDecl, *ast.ModuleDecl, *ast.ImportDecl:
 		return Unit(), nil
 	case *ast.This:
 		panic("unreachable: this cannot appear outside of pattern")
@@ -81,12 +92,19 @@ func (ev *Evaluator) evalLiteral(node *ast.Literal) (Value, error) {
 	//exhaustive:ignore
 	switch node.Kind {
 	case token.INTEGER:
-		v, ok := node.Literal.(int)
+		v, ok := node.Literal.(*big.Int)
+		if !ok {
+			return nil, utils.PosError{Where: node.Base(), Err: InvalidLiteralError{Kind: node.Kind}}
+		}
+
+		return Int{v}, nil
+	case token.FLOAT:
+		v, ok := node.Literal.(float64)
 		if !ok {
 			return nil, utils.PosError{Where: node.Base(), Err: InvalidLiteralError{Kind: node.Kind}}
 		}
 
-		return Int(v), nil
+		return Float(v), nil
 	case token.STRING:
 		v, ok := node.Literal.(string)
 		if !ok {
@@ -100,7 +118,7 @@ func (ev *Evaluator) evalLiteral(node *ast.Literal) (Value, error) {
 }
 
 func (ev *Evaluator) evalParen(node *ast.Paren) (Value, error) {
-	return ev.Eval(node.Expr)
+	return ev.evalTail(node.Expr)
 }
 
 func (ev *Evaluator) evalTuple(node *ast.Tuple) (Value, error) {
@@ -125,9 +143,20 @@ func (ev *Evaluator) evalAccess(node *ast.Access) (Value, error) {
 	switch receiver := receiver.(type) {
 	case Object:
 		if value, ok := receiver.Fields[node.Name.Lexeme]; ok {
-			value, err = runThunk(value)
-			if err != nil {
-				return nil, err
+			if thunk, ok := value.(Thunk); ok {
+				if err := ev.budget.Step(node.Base()); err != nil {
+					return nil, errorAt(node.Base(), err)
+				}
+				if err := ev.budget.Enter(node.Base()); err != nil {
+					return nil, errorAt(node.Base(), err)
+				}
+				ev.calls.push(Frame{Name: thunk.Name, Call: node.Base().Location})
+				value, err = thunk.force()
+				ev.calls.pop()
+				ev.budget.Leave()
+				if err != nil {
+					return nil, err
+				}
 			}
 			receiver.Fields[node.Name.Lexeme] = value
 
@@ -154,23 +183,14 @@ func (ev *Evaluator) evalCall(node *ast.Call) (Value, error) {
 				return nil, err
 			}
 		}
-		v, err := function.Apply(node.Base(), args...)
-		if err != nil {
-			return nil, utils.PosError{Where: node.Base(), Err: err}
-		}
 
-		return v, nil
+		return TailCall{Fn: function, Where: node.Base(), Args: args}, nil
 	default:
 		return nil, utils.PosError{Where: node.Base(), Err: NotCallableError{Func: function}}
 	}
 }
 
 func (ev *Evaluator) evalPrim(node *ast.Prim) (Value, error) {
-	prim := fetchPrim(node.Name)
-	if prim == nil {
-		return nil, utils.PosError{Where: node.Base(), Err: UndefinedPrimError{Name: node.Name}}
-	}
-
 	args := make([]Value, len(node.Args))
 	for i, arg := range node.Args {
 		var err error
@@ -180,30 +200,32 @@ func (ev *Evaluator) evalPrim(node *ast.Prim) (Value, error) {
 		}
 	}
 
-	return prim(ev, args...)
+	return ev.CallPrim(node.Name, args)
 }
 
-func asInt(v Value) (Int, bool) {
-	switch v := v.(type) {
-	case Int:
-		return v, true
-	default:
-		return 0, false
+// CallPrim calls the primitive named name as `prim(name, args...)`.
+// The result may be [TailCall], which the caller must run.
+func (ev *Evaluator) CallPrim(name token.Token, args []Value) (Value, error) {
+	prim, ok := ev.prims[name.Lexeme]
+	if !ok {
+		return nil, utils.PosError{Where: name, Err: UndefinedPrimError{Name: name}}
+	}
+	if prim.arity != Variadic && prim.arity != len(args) {
+		return nil, utils.PosError{Where: name, Err: InvalidArgumentCountError{Expected: prim.arity, Actual: len(args)}}
 	}
+
+	v, err := prim.fn(PrimContext{Evaluator: ev, Where: name}, args)
+	if err != nil {
+		return nil, utils.PosError{Where: name, Err: err}
+	}
+
+	return ev.tags.fromPrim(v), nil
 }
 
 func errorAt(base token.Token, err error) utils.PosError {
 	return utils.PosError{Where: base, Err: err}
 }
 
-func fetchPrim(name token.Token) func(*Evaluator, ...Value) (Value, error) {
-	return func(ev *Evaluator, args ...Value) (Value, error) {
-		p := primitiveEvaluator{Evaluator: ev, where: name}
-
-		return p.primitive(name.Lexeme)(args...)
-	}
-}
-
 type ExitError struct {
 	Code int
 }
@@ -225,12 +247,8 @@ func (ev *Evaluator) evalBinary(node *ast.Binary) (Value, error) {
 			if err != nil {
 				return nil, err
 			}
-			v, err := operator.Apply(node.Base(), left, right)
-			if err != nil {
-				return nil, utils.PosError{Where: node.Base(), Err: err}
-			}
 
-			return v, nil
+			return TailCall{Fn: operator, Where: node.Base(), Args: []Value{left, right}}, nil
 		default:
 			return nil, utils.PosError{Where: node.Base(), Err: NotCallableError{Func: operator}}
 		}
@@ -240,7 +258,7 @@ func (ev *Evaluator) evalBinary(node *ast.Binary) (Value, error) {
 }
 
 func (ev *Evaluator) evalAssert(node *ast.Assert) (Value, error) {
-	return ev.Eval(node.Expr)
+	return ev.evalTail(node.Expr)
 }
 
 // evalLet evaluates the given let expression.
@@ -273,6 +291,7 @@ func (ev *Evaluator) evalLambda(node *ast.Lambda) Function {
 
 	return Function{
 		Evaluator: *ev,
+		Name:      ev.scope,
 		Params:    params,
 		Body:      node.Expr,
 	}
@@ -300,7 +319,7 @@ func (ev *Evaluator) evalCase(node *ast.Case) (Value, error) {
 			for name, v := range env {
 				ev.evEnv.set(name, v)
 			}
-			ret, err := ev.Eval(clause.Expr)
+			ret, err := ev.evalTail(clause.Expr)
 			if err != nil {
 				return nil, err
 			}
@@ -337,13 +356,14 @@ func matchClause(clause *ast.CaseClause, scrs []Value) (map[Name]Value, bool) {
 func (ev *Evaluator) evalObject(node *ast.Object) Object {
 	fields := make(map[string]Value)
 	for _, field := range node.Fields {
-		fields[field.Name] = Thunk{Evaluator: *ev, Body: field.Expr}
+		fields[field.Name] = Thunk{Evaluator: *ev, Name: ev.scope + "." + field.Name, Body: field.Expr}
 	}
 
 	return Object{Fields: fields}
 }
 
 func (ev *Evaluator) evalTypeDecl(node *ast.TypeDecl) error {
+	ev.UseTags(BuiltinTags(node))
 	for _, ctor := range node.Types {
 		err := ev.defineConstructor(ctor)
 		if err != nil {
@@ -371,7 +391,7 @@ func (ev *Evaluator) defineConstructor(node ast.Node) error {
 			// Ignore in evaluation
 			return nil
 		}
-	case *ast.Prim:
+	case *ast.Prim, *ast.Object:
 		// For type checking
 		// Ignore in evaluation
 		return nil
@@ -382,6 +402,9 @@ func (ev *Evaluator) defineConstructor(node ast.Node) error {
 
 func (ev *Evaluator) evalVarDecl(node *ast.VarDecl) error {
 	if node.Expr != nil {
+		// Functions in the definition are named after it.
+		defer func(scope string) { ev.scope = scope }(ev.scope)
+		ev.scope = node.Name.Lexeme
 		v, err := ev.Eval(node.Expr)
 		if err != nil {
 			return err
@@ -9,11 +9,9 @@ import (
 
 	"github.com/sebdah/goldie/v2"
 	"github.com/takoeight0821/anma/codata"
-	"github.com/takoeight0821/anma/desugarwith"
 	"github.com/takoeight0821/anma/driver"
 	"github.com/takoeight0821/anma/eval"
-	"github.com/takoeight0821/anma/infix"
-	"github.com/takoeight0821/anma/nameresolve"
+	"github.com/takoeight0821/anma/module"
 	"github.com/takoeight0821/anma/token"
 	"github.com/takoeight0821/anma/utils"
 )
@@ -36,11 +34,7 @@ func TestGolden(t *testing.T) {
 			return
 		}
 
-		runner := driver.NewPassRunner()
-		runner.AddPass(&desugarwith.DesugarWith{})
-		runner.AddPass(&codata.Flat{})
-		runner.AddPass(infix.NewInfixResolver())
-		runner.AddPass(nameresolve.NewResolver())
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
 
 		nodes, err := runner.RunSource(testfile, string(source))
 		if err != nil {
@@ -64,7 +58,8 @@ func TestGolden(t *testing.T) {
 			}
 		}
 
-		if main, ok := evaluator.SearchMain(); ok {
+		name, _ := module.MainName(nodes)
+		if main, ok := evaluator.SearchMain(name); ok {
 			top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
 			ret, err := main.Apply(top)
 			var exitErr eval.ExitError
@@ -84,3 +79,82 @@ func TestGolden(t *testing.T) {
 		}
 	}
 }
+
+func TestRegisterPrim(t *testing.T) {
+	t.Parallel()
+
+	source := `def main = { prim(print, prim(repeat, "ab", 3)); prim(exit) }`
+	output, err := run(t, "register", source, func(evaluator *eval.Evaluator) {
+		evaluator.RegisterPrim("repeat", 2, func(_ eval.PrimContext, args []eval.Value) (eval.Value, error) {
+			s, err := eval.StringArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			n, err := eval.IntArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+
+			return eval.String(strings.Repeat(s, int(n.Int64()))), nil
+		})
+		evaluator.DisableUnsafePrims()
+	})
+
+	if output != "\"ababab\"\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	var undefined eval.UndefinedPrimError
+	if !errors.As(err, &undefined) || undefined.Name.Lexeme != "exit" {
+		t.Errorf("expected undefined prim `exit`, actual %v", err)
+	}
+}
+
+// TestBuiltinTags checks that data of primitives match only the constructors of the prelude,
+// not other constructors of the same name.
+func TestBuiltinTags(t *testing.T) {
+	t.Parallel()
+
+	source := `type Answer = { Yes(), True() }
+def main = {
+    prim(print, { #(True()) -> "Answer", #(_) -> "Bool" }(1 == 1))
+}`
+	output, err := run(t, "tags.anma", source, func(*eval.Evaluator) {})
+	if err != nil {
+		t.Fatalf("tags.anma returned error: %v", err)
+	}
+	if output != "\"Bool\"\n" {
+		t.Errorf("expected True of Bool not to match True of Answer, got %q", output)
+	}
+}
+
+// run evaluates main of the source and returns the output.
+func run(t *testing.T, path, source string, setup func(*eval.Evaluator)) (string, error) {
+	t.Helper()
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve})
+
+	nodes, err := runner.RunSource(path, source)
+	if err != nil {
+		t.Fatalf("%s returned error: %v", path, err)
+	}
+
+	evaluator := eval.NewEvaluator()
+	var builder strings.Builder
+	evaluator.Stdout = &builder
+	setup(evaluator)
+
+	for _, node := range nodes {
+		if _, err := evaluator.Eval(node); err != nil {
+			t.Fatalf("%s returned error: %v", path, err)
+		}
+	}
+	name, _ := module.MainName(nodes)
+	main, ok := evaluator.SearchMain(name)
+	if !ok {
+		t.Fatalf("%s does not have a main function", path)
+	}
+	top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
+	_, err = main.Apply(top)
+
+	return builder.String(), err
+}
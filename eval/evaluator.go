@@ -3,7 +3,9 @@ package eval
 import (
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/takoeight0821/anma/token"
@@ -13,6 +15,11 @@ type Evaluator struct {
 	*evEnv
 	Stdout io.Writer
 	Stdin  io.Reader
+	prims  map[string]prim // Shared by copies of the evaluator.
+	budget *Budget         // Shared by copies of the evaluator.
+	calls  *callStack      // Shared by copies of the evaluator.
+	tags   *tags           // Shared by copies of the evaluator.
+	scope  string          // The name of functions created by the evaluator.
 }
 
 func NewEvaluator() *Evaluator {
@@ -20,9 +27,39 @@ func NewEvaluator() *Evaluator {
 		evEnv:  newEvEnv(nil),
 		Stdout: os.Stdout,
 		Stdin:  os.Stdin,
+		prims:  builtinPrims(),
+		budget: newBudget(),
+		calls:  &callStack{frames: nil},
+		tags:   newTags(),
+		scope:  "toplevel",
 	}
 }
 
+// Snapshot returns a function that forgets variables defined in the current environment after it,
+// and tags given to [Evaluator.UseTags] after it.
+// The REPL uses it to drop definitions of an input that fails.
+func (ev *Evaluator) Snapshot() (restore func()) {
+	env, values := ev.evEnv, maps.Clone(ev.evEnv.values)
+	resolved, builtin := maps.Clone(ev.tags.resolved), maps.Clone(ev.tags.builtin)
+
+	return func() {
+		// Functions defined before keep the environment, so it is restored in place.
+		env.values = values
+		ev.tags.resolved, ev.tags.builtin = resolved, builtin
+	}
+}
+
+// PrimNames returns names of primitives that `prim(name, ...)` can call, in sorted order.
+func (ev *Evaluator) PrimNames() []string {
+	names := make([]string, 0, len(ev.prims))
+	for name := range ev.prims {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
 type Name string
 
 func tokenToName(t token.Token) Name {
@@ -75,21 +112,35 @@ func (env *evEnv) set(name Name, v Value) {
 	env.values[name] = v
 }
 
-func (env *evEnv) SearchMain() (Function, bool) {
-	if env == nil {
-		return Function{}, false
+// Binding is a variable bound in an environment of [Evaluator].
+type Binding struct {
+	Name  Name
+	Value Value
+}
+
+// Bindings returns variables bound in the environment and its parents.
+// Variables in inner environments come first.
+func (env *evEnv) Bindings() []Binding {
+	var bindings []Binding
+	for ; env != nil; env = env.parent {
+		for name, v := range env.values {
+			bindings = append(bindings, Binding{Name: name, Value: v})
+		}
 	}
 
-	for name, v := range env.values {
-		if strings.HasPrefix(string(name), "main.") {
+	return bindings
+}
+
+// SearchMain returns the function bound to main, which is the resolved name of a `def main`.
+func (env *evEnv) SearchMain(main token.Token) (Function, bool) {
+	name := tokenToName(main)
+	for ; env != nil; env = env.parent {
+		if v, ok := env.values[name]; ok {
 			f, ok := v.(Function)
-			if !ok {
-				return Function{}, false
-			}
 
-			return f, true
+			return f, ok
 		}
 	}
 
-	return env.parent.SearchMain()
+	return Function{}, false
 }
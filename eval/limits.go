@@ -0,0 +1,103 @@
+package eval
+
+import (
+	"context"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/token"
+)
+
+// Limits bounds the work of evaluation. Zero means no limit.
+type Limits struct {
+	Fuel  int // The maximum number of steps. A step is a function call or an evaluation of an object field.
+	Depth int // The maximum depth of calls. Tail calls do not count.
+}
+
+// Budget tracks the work of evaluation against [Limits] and a [context.Context].
+// It is shared by copies of an [Evaluator], and by backends that run with the evaluator, such as the vm package.
+// A nil Budget has no limit.
+type Budget struct {
+	limits Limits
+	steps  int
+	depth  int
+	ctx    context.Context
+}
+
+func newBudget() *Budget {
+	return &Budget{limits: Limits{Fuel: 0, Depth: 0}, steps: 0, depth: 0, ctx: context.Background()}
+}
+
+// Budget returns the budget of the evaluator.
+func (ev *Evaluator) Budget() *Budget {
+	return ev.budget
+}
+
+// SetLimits sets the limits and resets the number of steps taken so far.
+func (ev *Evaluator) SetLimits(limits Limits) {
+	ev.budget.limits = limits
+	ev.budget.steps = 0
+}
+
+// EvalContext is the same as [Evaluator.Eval], but the evaluation stops when ctx is done.
+func (ev *Evaluator) EvalContext(ctx context.Context, node ast.Node) (Value, error) {
+	defer ev.budget.SetContext(ev.budget.SetContext(ctx))
+
+	return ev.Eval(node)
+}
+
+// ApplyContext calls fn, but the evaluation stops when ctx is done.
+// fn must be created by this evaluator or share its [Budget].
+func (ev *Evaluator) ApplyContext(ctx context.Context, fn Callable, where token.Token, args ...Value) (Value, error) {
+	defer ev.budget.SetContext(ev.budget.SetContext(ctx))
+
+	return fn.Apply(where, args...)
+}
+
+// SetContext sets the context checked by [Budget.Step] and returns the previous one.
+func (b *Budget) SetContext(ctx context.Context) context.Context {
+	prev := b.ctx
+	b.ctx = ctx
+
+	return prev
+}
+
+// Step takes a step at where.
+// It fails if the fuel is exhausted or the context is done.
+func (b *Budget) Step(where token.Token) error {
+	if b == nil {
+		return nil
+	}
+	if b.limits.Fuel > 0 {
+		b.steps++
+		if b.steps > b.limits.Fuel {
+			return FuelExhaustedError{Location: where.Location, Fuel: b.limits.Fuel}
+		}
+	}
+	select {
+	case <-b.ctx.Done():
+		return CanceledError{Location: where.Location, Err: context.Cause(b.ctx)}
+	default:
+		return nil
+	}
+}
+
+// Enter enters a call at where. Each successful Enter must be paired with [Budget.Leave].
+func (b *Budget) Enter(where token.Token) error {
+	if b == nil {
+		return nil
+	}
+	if b.limits.Depth > 0 && b.depth >= b.limits.Depth {
+		return StackDepthError{Location: where.Location, Depth: b.limits.Depth}
+	}
+	b.depth++
+
+	return nil
+}
+
+// Leave leaves a call entered by [Budget.Enter].
+func (b *Budget) Leave() {
+	if b == nil {
+		return
+	}
+	b.depth--
+}
@@ -1,127 +1,458 @@
 package eval
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"io"
-
-	"github.com/takoeight0821/anma/token"
-	"github.com/takoeight0821/anma/utils"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
 )
 
-type primitiveEvaluator struct {
-	*Evaluator
-	where token.Token
+// builtinPrims are primitives registered by [NewEvaluator].
+func builtinPrims() map[string]prim {
+	return map[string]prim{
+		"exit":         {arity: 0, fn: exit},
+		"print_cps":    {arity: 2, fn: writeCPS},
+		"read_all_cps": {arity: 1, fn: readAllCPS},
+		"print":        {arity: 1, fn: write},
+		"mul":          {arity: 2, fn: mul},
+		"add":          {arity: 2, fn: add},
+		"sub":          {arity: 2, fn: sub},
+		"div":          {arity: 2, fn: div},
+		"mod":          {arity: 2, fn: mod},
+		"neg":          {arity: 1, fn: neg},
+		"eq":           {arity: 2, fn: eq},
+		"ne":           {arity: 2, fn: ne},
+		"lt":           {arity: 2, fn: compare(func(c int) bool { return c < 0 })},
+		"le":           {arity: 2, fn: compare(func(c int) bool { return c <= 0 })},
+		"gt":           {arity: 2, fn: compare(func(c int) bool { return c > 0 })},
+		"ge":           {arity: 2, fn: compare(func(c int) bool { return c >= 0 })},
+		"concat":       {arity: 2, fn: concat},
+		"length":       {arity: 1, fn: length},
+		"substring":    {arity: 3, fn: substring},
+		"char_at":      {arity: 2, fn: charAt},
+		"index_of":     {arity: 2, fn: indexOf},
+		"to_string":    {arity: 1, fn: toString},
+		"parse_int":    {arity: 1, fn: parseInt},
+		"split":        {arity: 2, fn: split},
+		"chars":        {arity: 1, fn: chars},
+		"join":         {arity: 2, fn: join},
+	}
 }
 
-type primitive func(...Value) (Value, error)
+func exit(PrimContext, []Value) (Value, error) {
+	return nil, ExitError{Code: 0}
+}
 
-func (p *primitiveEvaluator) primitive(name string) primitive {
-	pmap := map[string]primitive{
-		"exit":         p.exit,
-		"print_cps":    p.printCPS,
-		"read_all_cps": p.readAllCPS,
-		"print":        p.print,
-		"mul":          p.mul,
-		"add":          p.add,
+func writeCPS(ctx PrimContext, args []Value) (Value, error) {
+	arg, err := StringArg(args[0])
+	if err != nil {
+		return nil, err
 	}
 
-	return pmap[name]
+	fmt.Fprintf(ctx.Stdout, "%s", arg)
+
+	cont, err := CallableArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.TailCall(cont), nil
 }
 
-func (p *primitiveEvaluator) exit(args ...Value) (Value, error) {
-	if len(args) != 0 {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentCountError{Expected: 0, Actual: len(args)}}
+func readAllCPS(ctx PrimContext, args []Value) (Value, error) {
+	cont, err := CallableArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := ctx.readAll(ctx.Stdin)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, ExitError{Code: 0}
+	return ctx.TailCall(cont, String(bytes)), nil
 }
 
-func (p *primitiveEvaluator) printCPS(args ...Value) (Value, error) {
-	if len(args) != 2 {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentCountError{Expected: 2, Actual: len(args)}}
+// readAll reads r to the end, but returns [CanceledError] as soon as the context of the evaluation is done.
+// Then the read goes on in the background until r returns, and its result is dropped.
+func (ctx PrimContext) readAll(r io.Reader) ([]byte, error) {
+	type result struct {
+		bytes []byte
+		err   error
 	}
+	done := make(chan result, 1)
+	go func() {
+		bytes, err := io.ReadAll(r)
+		done <- result{bytes: bytes, err: err}
+	}()
 
-	arg, ok := args[0].(String)
-	if !ok {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentTypeError{Expected: "String", Actual: args[0]}}
+	select {
+	case res := <-done:
+		return res.bytes, res.err
+	case <-ctx.Context().Done():
+		return nil, CanceledError{Location: ctx.Where.Location, Err: context.Cause(ctx.Context())}
+	}
+}
+
+func write(ctx PrimContext, args []Value) (Value, error) {
+	fmt.Fprintln(ctx.Stdout, args[0])
+
+	return Unit(), nil
+}
+
+func mul(_ PrimContext, args []Value) (Value, error) {
+	return arith(args,
+		func(x, y *big.Int) *big.Int { return new(big.Int).Mul(x, y) },
+		func(x, y float64) float64 { return x * y })
+}
+
+func add(_ PrimContext, args []Value) (Value, error) {
+	return arith(args,
+		func(x, y *big.Int) *big.Int { return new(big.Int).Add(x, y) },
+		func(x, y float64) float64 { return x + y })
+}
+
+func sub(_ PrimContext, args []Value) (Value, error) {
+	return arith(args,
+		func(x, y *big.Int) *big.Int { return new(big.Int).Sub(x, y) },
+		func(x, y float64) float64 { return x - y })
+}
+
+// div truncates the quotient of Ints toward zero.
+func div(_ PrimContext, args []Value) (Value, error) {
+	if err := checkDivisor(args); err != nil {
+		return nil, err
 	}
 
-	fmt.Fprintf(p.Stdout, "%s", string(arg))
+	return arith(args,
+		func(x, y *big.Int) *big.Int { return new(big.Int).Quo(x, y) },
+		func(x, y float64) float64 { return x / y })
+}
 
-	cont, ok := args[1].(Callable)
-	if !ok {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentTypeError{Expected: "Callable", Actual: args[1]}}
+// mod returns the remainder of div, which has the same sign as the dividend.
+func mod(_ PrimContext, args []Value) (Value, error) {
+	if err := checkDivisor(args); err != nil {
+		return nil, err
+	}
+
+	return arith(args,
+		func(x, y *big.Int) *big.Int { return new(big.Int).Rem(x, y) },
+		math.Mod)
+}
+
+// checkDivisor reports an error if the divisor is Int zero.
+// Floats follow IEEE 754.
+func checkDivisor(args []Value) error {
+	if divisor, ok := args[1].(Int); ok && divisor.Sign() == 0 {
+		return DivisionByZeroError{}
+	}
+
+	return nil
+}
+
+func neg(_ PrimContext, args []Value) (Value, error) {
+	switch arg := args[0].(type) {
+	case Int:
+		return Int{new(big.Int).Neg(arg.Int)}, nil
+	case Float:
+		return -arg, nil
+	default:
+		return nil, InvalidArgumentTypeError{Expected: "Int or Float", Actual: args[0]}
+	}
+}
+
+// arith applies a binary arithmetic operator to two Ints or two Floats.
+func arith(args []Value, onInt func(x, y *big.Int) *big.Int, onFloat func(x, y float64) float64) (Value, error) {
+	switch left := args[0].(type) {
+	case Int:
+		right, err := IntArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return Int{onInt(left.Int, right)}, nil
+	case Float:
+		right, err := FloatArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return Float(onFloat(float64(left), right)), nil
+	default:
+		return nil, InvalidArgumentTypeError{Expected: "Int or Float", Actual: args[0]}
+	}
+}
+
+func eq(_ PrimContext, args []Value) (Value, error) {
+	eq, err := equal(args[0], args[1])
+	if err != nil {
+		return nil, err
 	}
 
-	result, err := cont.Apply(p.where)
+	return Bool(eq), nil
+}
+
+func ne(_ PrimContext, args []Value) (Value, error) {
+	eq, err := equal(args[0], args[1])
 	if err != nil {
-		return nil, utils.PosError{Where: p.where, Err: err}
+		return nil, err
+	}
+
+	return Bool(!eq), nil
+}
+
+// equal compares numbers, strings, tuples and data structurally.
+// Functions and objects cannot be compared.
+func equal(left, right Value) (bool, error) {
+	switch left := left.(type) {
+	case Int:
+		right, ok := right.(Int)
+
+		return ok && left.Cmp(right.Int) == 0, nil
+	case Float:
+		right, ok := right.(Float)
+
+		return ok && left == right, nil
+	case String:
+		right, ok := right.(String)
+
+		return ok && left == right, nil
+	case Tuple:
+		right, ok := right.(Tuple)
+		if !ok || len(left) != len(right) {
+			return false, nil
+		}
+
+		return equalAll(left, right)
+	case Data:
+		right, ok := right.(Data)
+		if !ok || left.Tag != right.Tag || len(left.Elems) != len(right.Elems) {
+			return false, nil
+		}
+
+		return equalAll(left.Elems, right.Elems)
+	default:
+		return false, InvalidArgumentTypeError{Expected: "comparable value", Actual: left}
+	}
+}
+
+func equalAll(lefts, rights []Value) (bool, error) {
+	for i := range lefts {
+		eq, err := equal(lefts[i], rights[i])
+		if err != nil || !eq {
+			return false, err
+		}
 	}
 
-	return result, nil
+	return true, nil
 }
 
-func (p *primitiveEvaluator) readAllCPS(args ...Value) (Value, error) {
-	if len(args) != 1 {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentCountError{
-			Expected: 1,
-			Actual:   len(args),
-		}}
+// compare returns a primitive that orders two Ints, Floats or Strings and tests the result of the comparison.
+// Comparisons with NaN are always false.
+func compare(test func(int) bool) PrimFunc {
+	return func(_ PrimContext, args []Value) (Value, error) {
+		switch left := args[0].(type) {
+		case Int:
+			right, err := IntArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+
+			return Bool(test(left.Cmp(right))), nil
+		case Float:
+			right, err := FloatArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			if math.IsNaN(float64(left)) || math.IsNaN(right) {
+				return False(), nil
+			}
+
+			return Bool(test(cmp.Compare(float64(left), right))), nil
+		case String:
+			right, err := StringArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+
+			return Bool(test(strings.Compare(string(left), right))), nil
+		default:
+			return nil, InvalidArgumentTypeError{Expected: "Int, Float or String", Actual: args[0]}
+		}
 	}
-	cont, ok := args[0].(Callable)
+}
+
+// indexArg converts arg to an index of runes in [0, length).
+// If end is true, length itself is also a valid index.
+func indexArg(arg Value, length int, end bool) (int, error) {
+	i, ok := arg.(Int)
 	if !ok {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentTypeError{Expected: "Callable", Actual: args[0]}}
+		return 0, InvalidArgumentTypeError{Expected: "Int", Actual: arg}
+	}
+	limit := int64(length)
+	if end {
+		limit++
 	}
-	bytes, err := io.ReadAll(p.Stdin)
+	if !i.IsInt64() || i.Int64() < 0 || i.Int64() >= limit {
+		return 0, IndexOutOfRangeError{Index: i, Length: length}
+	}
+
+	return int(i.Int64()), nil
+}
+
+func concat(_ PrimContext, args []Value) (Value, error) {
+	left, err := StringArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	right, err := StringArg(args[1])
 	if err != nil {
-		return nil, utils.PosError{Where: p.where, Err: err}
+		return nil, err
 	}
 
-	result, err := cont.Apply(p.where, String(bytes))
+	return String(left + right), nil
+}
+
+// length returns the number of runes.
+func length(_ PrimContext, args []Value) (Value, error) {
+	s, err := StringArg(args[0])
 	if err != nil {
-		return nil, utils.PosError{Where: p.where, Err: err}
+		return nil, err
 	}
 
-	return result, nil
+	return NewInt(int64(utf8.RuneCountInString(s))), nil
 }
 
-func (p *primitiveEvaluator) print(args ...Value) (Value, error) {
-	if len(args) != 1 {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentCountError{Expected: 1, Actual: len(args)}}
+// substring returns runes from start (inclusive) to end (exclusive).
+func substring(_ PrimContext, args []Value) (Value, error) {
+	s, err := StringArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(s)
+	end, err := indexArg(args[2], len(runes), true)
+	if err != nil {
+		return nil, err
+	}
+	// start must not exceed end.
+	start, err := indexArg(args[1], end, true)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Fprintln(p.Stdout, args[0])
 
-	return Unit(), nil
+	return String(runes[start:end]), nil
 }
 
-func (p *primitiveEvaluator) mul(args ...Value) (Value, error) {
-	if len(args) != 2 {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentCountError{Expected: 2, Actual: len(args)}}
+// charAt returns the rune at the index as a String.
+func charAt(_ PrimContext, args []Value) (Value, error) {
+	s, err := StringArg(args[0])
+	if err != nil {
+		return nil, err
 	}
-	left, ok := asInt(args[0])
-	if !ok {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentTypeError{Expected: "Int", Actual: args[0]}}
+	runes := []rune(s)
+	i, err := indexArg(args[1], len(runes), false)
+	if err != nil {
+		return nil, err
 	}
-	right, ok := asInt(args[1])
-	if !ok {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentTypeError{Expected: "Int", Actual: args[1]}}
+
+	return String(runes[i]), nil
+}
+
+// indexOf returns the rune index of the first occurrence of the substring, or -1.
+func indexOf(_ PrimContext, args []Value) (Value, error) {
+	s, err := StringArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	sub, err := StringArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return NewInt(-1), nil
 	}
 
-	return left * right, nil
+	return NewInt(int64(utf8.RuneCountInString(s[:i]))), nil
 }
 
-func (p *primitiveEvaluator) add(args ...Value) (Value, error) {
-	if len(args) != 2 {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentCountError{Expected: 2, Actual: len(args)}}
+// toString converts the value to a String as print does, except that a String is not quoted.
+func toString(_ PrimContext, args []Value) (Value, error) {
+	if s, ok := args[0].(String); ok {
+		return s, nil
 	}
-	left, ok := asInt(args[0])
-	if !ok {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentTypeError{Expected: "Int", Actual: args[0]}}
+
+	return String(args[0].String()), nil
+}
+
+// parseInt parses a decimal integer with an optional sign.
+func parseInt(_ PrimContext, args []Value) (Value, error) {
+	s, err := StringArg(args[0])
+	if err != nil {
+		return nil, err
 	}
-	right, ok := asInt(args[1])
+	i, ok := new(big.Int).SetString(s, 10)
 	if !ok {
-		return nil, utils.PosError{Where: p.where, Err: InvalidArgumentTypeError{Expected: "Int", Actual: args[1]}}
+		return nil, ParseIntError{Input: s}
+	}
+
+	return Int{i}, nil
+}
+
+// split splits the string by the separator into a List of Strings.
+// An empty separator splits after each rune.
+func split(_ PrimContext, args []Value) (Value, error) {
+	s, err := StringArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	sep, err := StringArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return stringList(strings.Split(s, sep)), nil
+}
+
+// chars splits the string into a List of single-rune Strings.
+func chars(_ PrimContext, args []Value) (Value, error) {
+	s, err := StringArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return stringList(strings.Split(s, "")), nil
+}
+
+// join concatenates a List of Strings with the separator.
+func join(ctx PrimContext, args []Value) (Value, error) {
+	values, err := ctx.ListArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	elems := make([]string, len(values))
+	for i, value := range values {
+		elems[i], err = StringArg(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sep, err := StringArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return String(strings.Join(elems, sep)), nil
+}
+
+func stringList(elems []string) Data {
+	values := make([]Value, len(elems))
+	for i, elem := range elems {
+		values[i] = String(elem)
 	}
 
-	return left + right, nil
+	return List(values...)
 }
@@ -0,0 +1,112 @@
+package eval
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/takoeight0821/anma/token"
+)
+
+// PrimFunc is a Go function called by `prim(name, args...)`.
+// Returned errors are reported at the name of the primitive.
+// A primitive that blocks or runs long should return when ctx.Context() is done,
+// because the limits of [Budget] are only checked between primitives.
+type PrimFunc func(ctx PrimContext, args []Value) (Value, error)
+
+// PrimContext is the environment in which a primitive is called.
+type PrimContext struct {
+	*Evaluator
+	Where token.Token // The name of the primitive at the call site.
+}
+
+// Context returns the context of the evaluation, given by [Evaluator.EvalContext] or [Evaluator.ApplyContext].
+func (ctx PrimContext) Context() context.Context {
+	if ctx.budget == nil {
+		return context.Background()
+	}
+
+	return ctx.budget.ctx
+}
+
+// Variadic is the arity of primitives that check the number of arguments by themselves.
+const Variadic = -1
+
+type prim struct {
+	arity int
+	fn    PrimFunc
+}
+
+// UnsafePrims are built-in primitives that terminate the process or read stdin.
+// [Evaluator.DisableUnsafePrims] removes them.
+var UnsafePrims = []string{"exit", "read_all_cps"}
+
+// RegisterPrim makes fn callable as `prim(name, args...)`.
+// If arity is not [Variadic], calls with a different number of arguments fail with [InvalidArgumentCountError].
+// It replaces the primitive of the same name, including built-in ones.
+func (ev *Evaluator) RegisterPrim(name string, arity int, fn PrimFunc) {
+	ev.prims[name] = prim{arity: arity, fn: fn}
+}
+
+// UnregisterPrim removes the primitive. Calling it fails with [UndefinedPrimError].
+func (ev *Evaluator) UnregisterPrim(name string) {
+	delete(ev.prims, name)
+}
+
+// DisableUnsafePrims removes [UnsafePrims].
+// Use it to run untrusted programs in an embedding application.
+func (ev *Evaluator) DisableUnsafePrims() {
+	for _, name := range UnsafePrims {
+		ev.UnregisterPrim(name)
+	}
+}
+
+// Helpers to decode arguments of primitives.
+// They return [InvalidArgumentTypeError] if the argument has a different type.
+
+func StringArg(arg Value) (string, error) {
+	s, ok := arg.(String)
+	if !ok {
+		return "", InvalidArgumentTypeError{Expected: "String", Actual: arg}
+	}
+
+	return string(s), nil
+}
+
+// IntArg returns the integer. It must not be mutated.
+func IntArg(arg Value) (*big.Int, error) {
+	i, ok := arg.(Int)
+	if !ok {
+		return nil, InvalidArgumentTypeError{Expected: "Int", Actual: arg}
+	}
+
+	return i.Int, nil
+}
+
+func FloatArg(arg Value) (float64, error) {
+	f, ok := arg.(Float)
+	if !ok {
+		return 0, InvalidArgumentTypeError{Expected: "Float", Actual: arg}
+	}
+
+	return float64(f), nil
+}
+
+func CallableArg(arg Value) (Callable, error) {
+	c, ok := arg.(Callable)
+	if !ok {
+		return nil, InvalidArgumentTypeError{Expected: "Callable", Actual: arg}
+	}
+
+	return c, nil
+}
+
+// ListArg returns elements of `Cons(v1, Cons(v2, ... Nil()))`.
+// The constructors are [ConsTag] and [NilTag], or those of the List type that the program declares.
+func (ctx PrimContext) ListArg(arg Value) ([]Value, error) {
+	values, ok := fromList(arg, ctx.tags.builtinTag)
+	if !ok {
+		return nil, InvalidArgumentTypeError{Expected: "List", Actual: arg}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,120 @@
+package eval
+
+import "github.com/takoeight0821/anma/ast"
+
+// builtinTypes are types of data that primitives take and return.
+// Type name -> tag of each constructor -> arity.
+var builtinTypes = map[string]map[Name]int{
+	"Bool": {FalseTag: 0, TrueTag: 0},
+	"List": {NilTag: 0, ConsTag: 2},
+}
+
+// BuiltinTags returns the tags of primitives with the resolved names of the constructors that decl declares for them.
+// It returns nil unless decl has the name and the constructors of Bool or List, in any order.
+// A later declaration of the same type replaces an earlier one, as the type checker does.
+func BuiltinTags(decl *ast.TypeDecl) map[Name]Name {
+	ctors, ok := builtinTypes[typeName(decl.Def)]
+	if !ok || len(decl.Types) != len(ctors) {
+		return nil
+	}
+	tags := make(map[Name]Name, len(ctors))
+	for _, typ := range decl.Types {
+		call, ok := typ.(*ast.Call)
+		if !ok {
+			return nil
+		}
+		name, ok := call.Func.(*ast.Var)
+		if !ok {
+			return nil
+		}
+		tag := Name(name.Name.Lexeme)
+		if arity, ok := ctors[tag]; !ok || arity != len(call.Args) {
+			return nil
+		}
+		tags[tag] = tokenToName(name.Name)
+	}
+
+	return tags
+}
+
+// typeName returns the name of the type declared by `type T` or `type T(a, ...)`.
+func typeName(def ast.Node) string {
+	if call, ok := def.(*ast.Call); ok {
+		def = call.Func
+	}
+	if v, ok := def.(*ast.Var); ok {
+		return v.Name.Lexeme
+	}
+
+	return ""
+}
+
+// tags converts tags of data between primitives and programs.
+type tags struct {
+	resolved map[Name]Name // Tag of primitives -> resolved name of the constructor.
+	builtin  map[Name]Name // Resolved name of a constructor -> tag of primitives.
+}
+
+func newTags() *tags {
+	return &tags{resolved: make(map[Name]Name), builtin: make(map[Name]Name)}
+}
+
+// UseTags makes data of primitives have the resolved names given by [BuiltinTags].
+// Data of constructors declared earlier are still accepted by primitives.
+func (ev *Evaluator) UseTags(tags map[Name]Name) {
+	for tag, resolved := range tags {
+		ev.tags.resolved[tag] = resolved
+		ev.tags.builtin[resolved] = tag
+	}
+}
+
+// builtinTag returns the tag of primitives for the tag of data, such as [ConsTag] for the resolved name of `Cons`.
+// Other tags are returned as is.
+func (t *tags) builtinTag(tag Name) Name {
+	if builtin, ok := t.builtin[tag]; ok {
+		return builtin
+	}
+
+	return tag
+}
+
+// fromPrim replaces the tags of primitives in v with the resolved names.
+// Arguments of a tail call are given to the program too.
+// Arguments of primitives are not replaced, so that primitives see the data as the program does.
+func (t *tags) fromPrim(v Value) Value {
+	if call, ok := v.(TailCall); ok {
+		return TailCall{Fn: call.Fn, Where: call.Where, Args: t.replaceAll(call.Args)}
+	}
+
+	return t.replace(v)
+}
+
+// replace replaces tags of primitives in v.
+// It only looks into tuples and data whose tags are replaced, so that large data of other types are not copied.
+func (t *tags) replace(v Value) Value {
+	switch v := v.(type) {
+	case Data:
+		tag, ok := t.resolved[v.Tag]
+		if !ok {
+			return v
+		}
+
+		return Data{Tag: tag, Elems: t.replaceAll(v.Elems)}
+	case Tuple:
+		return Tuple(t.replaceAll(v))
+	default:
+		return v
+	}
+}
+
+func (t *tags) replaceAll(values []Value) []Value {
+	if len(t.resolved) == 0 {
+		return values
+	}
+	replaced := make([]Value, len(values))
+	for i, v := range values {
+		replaced[i] = t.replace(v)
+	}
+
+	return replaced
+}
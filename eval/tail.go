@@ -0,0 +1,81 @@
+package eval
+
+import (
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// TailCall is a call in tail position.
+// Instead of calling Fn in a nested Go call, the evaluator returns it to the nearest trampoline,
+// so that tail calls, including calls of continuations in CPS, run in constant Go stack.
+// It never appears as the result of [Evaluator.Eval] and [Callable.Apply],
+// but [Evaluator.CallPrim] may return it.
+type TailCall struct {
+	Fn    Callable
+	Where token.Token
+	Args  []Value
+}
+
+func (TailCall) String() string {
+	return "<tail call>"
+}
+
+func (TailCall) match(ast.Node) (map[Name]Value, bool) {
+	panic("unreachable: tail call cannot be matched")
+}
+
+var _ Value = TailCall{}
+
+// run calls Fn once. Calls in tail position of Fn are returned as [TailCall].
+func (c TailCall) run(budget *Budget) (Value, error) {
+	if err := budget.Step(c.Where); err != nil {
+		return nil, utils.PosError{Where: c.Where, Err: err}
+	}
+
+	var (
+		v   Value
+		err error
+	)
+	if f, ok := c.Fn.(Function); ok {
+		v, err = f.enter(c.Where, c.Args)
+	} else {
+		v, err = c.Fn.Apply(c.Where, c.Args...)
+	}
+
+	return v, err
+}
+
+// trampoline runs tail calls until a value is returned.
+// The tail calls share one frame of the call stack, and count as one call against [Limits.Depth].
+// The stack trace keeps the frame of the first call below the frame of the last tail call.
+// Errors are returned with the stack trace where they happened.
+func (ev *Evaluator) trampoline(v Value, err error) (Value, error) {
+	if call, ok := v.(TailCall); ok && err == nil {
+		if err := ev.budget.Enter(call.Where); err != nil {
+			return nil, ev.calls.attach(utils.PosError{Where: call.Where, Err: err})
+		}
+		defer ev.budget.Leave()
+		ev.calls.push(Frame{Name: frameName(call.Fn), Call: call.Where.Location})
+		defer ev.calls.pop()
+	}
+
+	for first := true; err == nil; first = false {
+		call, ok := v.(TailCall)
+		if !ok {
+			return v, nil
+		}
+		if !first {
+			ev.calls.tail(Frame{Name: frameName(call.Fn), Call: call.Where.Location})
+		}
+		v, err = call.run(ev.budget)
+	}
+
+	return nil, ev.calls.attach(err)
+}
+
+// TailCall returns a call of fn that the evaluator runs after the primitive returns.
+// Primitives that call continuations, such as `print_cps`, return it to run in constant Go stack.
+func (ctx PrimContext) TailCall(fn Callable, args ...Value) Value {
+	return TailCall{Fn: fn, Where: ctx.Where, Args: args}
+}
@@ -0,0 +1,166 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// Frame is a running call of a function or an evaluation of an object field.
+type Frame struct {
+	Name string         // The name of the definition, such as `fib` or `fib.func` for a lambda in it.
+	Call token.Location // The call site.
+	Tail bool           // Whether the frame is entered by a tail call. The frame below made the call, unless Elided > 0.
+	// Elided is the number of frames that tail calls replaced between the frame and the frame below.
+	// Caller is the call site in the last of them, where the frame below is running.
+	Elided int
+	Caller token.Location
+}
+
+// Replace returns the frame of a tail call from f to next.
+// f is replaced by the result, unless f is the first frame of the tail calls.
+// The first frame stays below, so that the stack trace shows who made the tail calls.
+func (f Frame) Replace(next Frame) Frame {
+	next.Tail = true
+	if f.Tail {
+		next.Elided = f.Elided + 1
+		next.Caller = f.Caller
+		if f.Elided == 0 {
+			next.Caller = f.Call
+		}
+	}
+
+	return next
+}
+
+// callStack is the stack of frames of an [Evaluator]. It is shared by copies of the evaluator.
+// A nil callStack records nothing.
+type callStack struct {
+	frames []Frame
+}
+
+func (s *callStack) push(frame Frame) {
+	if s == nil {
+		return
+	}
+	s.frames = append(s.frames, frame)
+}
+
+// tail replaces the top frame by a tail call. See [Frame.Replace].
+func (s *callStack) tail(frame Frame) {
+	if s == nil || len(s.frames) == 0 {
+		return
+	}
+	top := &s.frames[len(s.frames)-1]
+	if !top.Tail {
+		s.frames = append(s.frames, top.Replace(frame))
+
+		return
+	}
+	*top = top.Replace(frame)
+}
+
+// pop removes the top frame, and the first frame of the tail calls if the top frame is entered by them.
+func (s *callStack) pop() {
+	if s == nil {
+		return
+	}
+	n := len(s.frames) - 1
+	if s.frames[n].Tail {
+		n--
+	}
+	s.frames = s.frames[:n]
+}
+
+// attach attaches the current stack to err unless it already has one.
+func (s *callStack) attach(err error) error {
+	if s == nil {
+		return err
+	}
+
+	return WithTrace(err, append([]Frame(nil), s.frames...))
+}
+
+// frameName returns the name of the frame that calls fn.
+func frameName(fn Callable) string {
+	switch fn := fn.(type) {
+	case Function:
+		return fn.Name
+	case fmt.Stringer:
+		return fn.String()
+	default:
+		return "?"
+	}
+}
+
+// RuntimeError is an error with the Anma-level stack trace where it happened.
+type RuntimeError struct {
+	Err   error
+	Trace []Frame // The outermost frame comes first.
+}
+
+// WithTrace attaches the trace to err unless it already has one.
+// Backends other than [Evaluator], such as the vm package, use it to report the same errors.
+func WithTrace(err error, trace []Frame) error {
+	var runtimeErr RuntimeError
+	if errors.As(err, &runtimeErr) {
+		return err
+	}
+
+	return RuntimeError{Err: err, Trace: trace}
+}
+
+func (e RuntimeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// maxTraceFrames is the number of frames printed by [RuntimeError.Traceback].
+// The middle of a deeper stack is elided.
+const maxTraceFrames = 100
+
+// Traceback renders the stack trace like a goroutine trace of Go, the most recent call first.
+// Each frame shows where it is running: the position of the error, or the call site of the frame above.
+// Frames that tail calls replaced are shown as "(tail calls elided)".
+func (e RuntimeError) Traceback() string {
+	var builder strings.Builder
+	builder.WriteString("anma stack [running]:\n")
+
+	var where fmt.Stringer = unknownLocation{}
+	var posErr utils.PosError
+	if errors.As(e.Err, &posErr) {
+		where = posErr.Where.Location
+	}
+
+	for i := len(e.Trace) - 1; i >= 0; i-- {
+		depth := len(e.Trace) - 1 - i
+		if len(e.Trace) > maxTraceFrames && depth == maxTraceFrames/2 {
+			fmt.Fprintf(&builder, "...%d frames elided...\n", len(e.Trace)-maxTraceFrames)
+		}
+		frame := e.Trace[i]
+		if len(e.Trace) <= maxTraceFrames || depth < maxTraceFrames/2 || i < maxTraceFrames/2 {
+			fmt.Fprintf(&builder, "%s(...)\n\t%v\n", frame.Name, where)
+			if frame.Elided > 0 {
+				builder.WriteString("(tail calls elided)\n")
+			}
+		}
+		where = frame.Call
+		if frame.Elided > 0 {
+			where = frame.Caller
+		}
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+type unknownLocation struct{}
+
+func (unknownLocation) String() string {
+	return "?"
+}
@@ -2,6 +2,8 @@ package eval
 
 import (
 	"fmt"
+	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/takoeight0821/anma/ast"
@@ -65,10 +67,14 @@ func (t Tuple) match(pattern ast.Node) (map[Name]Value, bool) {
 
 var _ Value = Tuple{}
 
-type Int int
+// Int represents an arbitrary-precision integer value.
+// The underlying [big.Int] must not be mutated.
+type Int struct {
+	*big.Int
+}
 
-func (i Int) String() string {
-	return fmt.Sprintf("%d", i)
+func NewInt(v int64) Int {
+	return Int{big.NewInt(v)}
 }
 
 func (i Int) match(pattern ast.Node) (map[Name]Value, bool) {
@@ -79,7 +85,38 @@ func (i Int) match(pattern ast.Node) (map[Name]Value, bool) {
 		if pattern.Kind != token.INTEGER {
 			return nil, false
 		}
-		if v, ok := pattern.Literal.(int); ok && v == int(i) {
+		if v, ok := pattern.Literal.(*big.Int); ok && v.Cmp(i.Int) == 0 {
+			return map[Name]Value{}, true
+		}
+	}
+
+	return nil, false
+}
+
+var _ Value = NewInt(0)
+
+// Float represents a floating-point number value.
+type Float float64
+
+func (f Float) String() string {
+	s := strconv.FormatFloat(float64(f), 'g', -1, 64)
+	// Distinguish from Int.
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+
+	return s
+}
+
+func (f Float) match(pattern ast.Node) (map[Name]Value, bool) {
+	switch pattern := pattern.(type) {
+	case *ast.Var:
+		return map[Name]Value{tokenToName(pattern.Name): f}, true
+	case *ast.Literal:
+		if pattern.Kind != token.FLOAT {
+			return nil, false
+		}
+		if v, ok := pattern.Literal.(float64); ok && v == float64(f) {
 			return map[Name]Value{}, true
 		}
 	}
@@ -87,7 +124,7 @@ func (i Int) match(pattern ast.Node) (map[Name]Value, bool) {
 	return nil, false
 }
 
-var _ Value = Int(0)
+var _ Value = Float(0)
 
 type String string
 
@@ -113,6 +150,7 @@ var _ Value = String("")
 // Function represents a closure value.
 type Function struct {
 	Evaluator
+	Name   string // The name of the definition for stack traces.
 	Params []Name
 	Body   ast.Node
 }
@@ -139,22 +177,22 @@ func (f Function) match(pattern ast.Node) (map[Name]Value, bool) {
 }
 
 func (f Function) Apply(where token.Token, args ...Value) (Value, error) {
+	return f.trampoline(TailCall{Fn: f, Where: where, Args: args}, nil)
+}
+
+// enter binds the arguments and evaluates the body.
+// A call in tail position of the body is returned as [TailCall].
+func (f Function) enter(where token.Token, args []Value) (Value, error) {
 	if len(f.Params) != len(args) {
 		return nil, errorAt(where, InvalidArgumentCountError{Expected: len(f.Params), Actual: len(args)})
 	}
 	f.evEnv = newEvEnv(f.evEnv)
+	f.scope = f.Name + ".func"
 	for i, param := range f.Params {
 		f.evEnv.set(param, args[i])
 	}
 
-	var ret Value
-	ret, err := f.Eval(f.Body)
-	if err != nil {
-		return nil, err
-	}
-	f.evEnv = f.evEnv.parent
-
-	return ret, nil
+	return f.evalTail(f.Body)
 }
 
 var (
@@ -166,6 +204,7 @@ var (
 // It is used to delay the evaluation of object fields.
 type Thunk struct {
 	Evaluator
+	Name string // The name of the field for stack traces.
 	Body ast.Node
 }
 
@@ -182,21 +221,18 @@ func (t Thunk) match(pattern ast.Node) (map[Name]Value, bool) {
 	}
 }
 
-func runThunk(value Value) (Value, error) {
-	switch value := value.(type) {
-	case Thunk:
-		ret, err := value.Eval(value.Body)
-		if err != nil {
-			return nil, err
-		}
-		if _, ok := ret.(Thunk); ok {
-			panic("unreachable: thunk cannot return thunk")
-		}
-
-		return ret, nil
-	default:
-		return value, nil
+// force evaluates the body of the thunk.
+func (t Thunk) force() (Value, error) {
+	t.scope = t.Name + ".func"
+	ret, err := t.Eval(t.Body)
+	if err != nil {
+		return nil, err
 	}
+	if _, ok := ret.(Thunk); ok {
+		panic("unreachable: thunk cannot return thunk")
+	}
+
+	return ret, nil
 }
 
 var _ Value = Thunk{}
@@ -249,7 +285,7 @@ func (d Data) match(pattern ast.Node) (map[Name]Value, bool) {
 	case *ast.Call:
 		switch fn := pattern.Func.(type) {
 		case *ast.Var:
-			if tokenToName(fn.Name) != d.Tag {
+			if !d.is(fn.Name) {
 				return nil, false
 			}
 			matches := make(map[Name]Value)
@@ -275,8 +311,105 @@ func (d Data) match(pattern ast.Node) (map[Name]Value, bool) {
 	return nil, false
 }
 
+// is reports whether the data is constructed by the constructor named name.
+func (d Data) is(name token.Token) bool {
+	return tokenToName(name) == d.Tag
+}
+
 var _ Value = Data{}
 
+// Foreign represents a value implemented outside this package, such as a closure of the vm package.
+// It is callable if Value implements [Callable].
+type Foreign struct {
+	Value fmt.Stringer
+}
+
+func (f Foreign) String() string {
+	return f.Value.String()
+}
+
+func (f Foreign) match(pattern ast.Node) (map[Name]Value, bool) {
+	switch pattern := pattern.(type) {
+	case *ast.Var:
+		return map[Name]Value{tokenToName(pattern.Name): f}, true
+	default:
+		return nil, false
+	}
+}
+
+func (f Foreign) Apply(where token.Token, args ...Value) (Value, error) {
+	fn, ok := f.Value.(Callable)
+	if !ok {
+		return nil, errorAt(where, NotCallableError{Func: f})
+	}
+
+	return fn.Apply(where, args...)
+}
+
+var (
+	_ Value    = Foreign{}
+	_ Callable = Foreign{}
+)
+
+// Tags of data that primitives take and return.
+// They have no unique number. The evaluator replaces them with the constructors of
+// `type Bool = { False(), True() }` and `type List(a) = { Nil(), Cons(a, List(a)) }` declared in the program,
+// and back when it calls primitives. See [BuiltinTags].
+const (
+	TrueTag  Name = "True"
+	FalseTag Name = "False"
+	NilTag   Name = "Nil"
+	ConsTag  Name = "Cons"
+)
+
+func True() Data {
+	return Data{Tag: TrueTag, Elems: nil}
+}
+
+func False() Data {
+	return Data{Tag: FalseTag, Elems: nil}
+}
+
+// Bool converts b to True() or False().
+func Bool(b bool) Data {
+	if b {
+		return True()
+	}
+
+	return False()
+}
+
+// List converts values to `Cons(v1, Cons(v2, ... Nil()))`.
+func List(values ...Value) Data {
+	list := Data{Tag: NilTag, Elems: nil}
+	for i := len(values) - 1; i >= 0; i-- {
+		list = Data{Tag: ConsTag, Elems: []Value{values[i], list}}
+	}
+
+	return list
+}
+
+// fromList converts `Cons(v1, Cons(v2, ... Nil()))` to values.
+// builtin returns [ConsTag] and [NilTag] for the tags of the constructors.
+func fromList(value Value, builtin func(Name) Name) ([]Value, bool) {
+	var values []Value
+	for {
+		data, ok := value.(Data)
+		if !ok {
+			return nil, false
+		}
+		switch {
+		case builtin(data.Tag) == NilTag && len(data.Elems) == 0:
+			return values, true
+		case builtin(data.Tag) == ConsTag && len(data.Elems) == 2:
+			values = append(values, data.Elems[0])
+			value = data.Elems[1]
+		default:
+			return nil, false
+		}
+	}
+}
+
 type Constructor struct {
 	Evaluator
 	Tag    Name
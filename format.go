@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/takoeight0821/anma/diag"
+	"github.com/takoeight0821/anma/format"
+)
+
+// formatCommand formats files. Without files, it formats the standard input.
+func formatCommand(args []string) int {
+	flags := newFlagSet("fmt")
+	write := flags.Bool("w", false, "write the result to the file instead of stdout")
+	check := flags.Bool("check", false, "print the names of files that are not formatted, and exit with status 1 if any")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+
+	if flags.NArg() == 0 {
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+			return exitError
+		}
+		if formatted, ok := formatSource("<stdin>", string(source)); ok {
+			fmt.Print(formatted)
+
+			return exitOK
+		}
+
+		return exitError
+	}
+
+	status := exitOK
+	for _, path := range flags.Args() {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = exitError
+
+			continue
+		}
+		formatted, ok := formatSource(path, string(source))
+		if !ok {
+			status = exitError
+
+			continue
+		}
+		changed := formatted != string(source)
+		if *check && changed {
+			fmt.Println(path)
+			status = exitError
+		}
+		if *write && changed {
+			if err := os.WriteFile(path, []byte(formatted), 0o644); err != nil { //nolint:gosec // Same as a new source file.
+				fmt.Fprintln(os.Stderr, err)
+				status = exitError
+			}
+		}
+		if !*check && !*write {
+			fmt.Print(formatted)
+		}
+	}
+
+	return status
+}
+
+// formatSource formats the source, and prints errors if it has syntax errors.
+func formatSource(path, source string) (string, bool) {
+	formatted, err := format.Source(path, source)
+	if err != nil {
+		report := reporter{format: formatText, sources: diag.Sources{path: source}}
+		fmt.Fprintln(os.Stderr, report.error(err))
+
+		return "", false
+	}
+
+	return formatted, true
+}
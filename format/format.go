@@ -0,0 +1,796 @@
+// Package format prints Anma programs in the canonical layout.
+//
+// The canonical layout is:
+//   - Indentation is four spaces.
+//   - Binary operators, `->`, `<-`, `=` and `:` are surrounded by spaces, and `,` and `;` are followed by a space.
+//   - A codata with one clause is printed on one line if it fits in [Width] columns.
+//     A codata with more than one clause, or a clause with more than one expression, is broken into lines,
+//     and each clause gets a trailing comma. A clause without `->` is printed without its implicit `#()`.
+//   - Constructors of a type and fields of a record type are printed like clauses, but on one line whenever they fit.
+//   - Top-level declarations are separated by a blank line only if they are in the source.
+//
+// Comments are kept next to the tokens around them.
+// A comment on its own line stays on its own line, and a comment after a token stays after it.
+package format
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/parser"
+	"github.com/takoeight0821/anma/token"
+)
+
+// Width is the maximum width of a line that a codata is put on.
+const Width = 80
+
+const indentUnit = "    "
+
+// Source formats the source code of a file.
+// It fails on a syntax error, because the layout of the broken part cannot be kept.
+func Source(filePath, source string) (string, error) {
+	tokens, err := lexer.LexWithComments(filePath, source)
+	if err != nil {
+		return "", err
+	}
+
+	p := newPrinter()
+	for _, t := range tokens {
+		if t.Kind != token.COMMENT {
+			p.index[t.Location] = len(p.code)
+			p.code = append(p.code, t)
+		}
+	}
+	lines := strings.Split(source, "\n")
+	for _, t := range tokens {
+		if t.Kind == token.COMMENT {
+			before := []rune(lines[t.Location.Line-1])[:t.Location.Column-1]
+			p.comments = append(p.comments, comment{
+				Token:   t,
+				ownLine: strings.TrimSpace(string(before)) == "",
+				after:   p.before(t.Location),
+			})
+		}
+	}
+
+	nodes, err := parser.NewParser(p.code).ParseDecl()
+	if err != nil {
+		return "", err
+	}
+
+	for _, node := range nodes {
+		p.decl(node)
+	}
+	p.flushAll()
+
+	return p.String(), nil
+}
+
+// Node formats a node returned by [parser.Parser], which is a declaration, an expression, a pattern or a type.
+// Types must be in declarations or assertions to be printed as types.
+func Node(node ast.Node) string {
+	p := newPrinter()
+	switch node.(type) {
+	case *ast.VarDecl, *ast.TypeDecl, *ast.InfixDecl, *ast.ModuleDecl, *ast.ImportDecl:
+		p.decl(node)
+	default:
+		p.expr(node)
+	}
+
+	return strings.TrimSuffix(p.String(), "\n")
+}
+
+// comment is a comment in the source.
+type comment struct {
+	token.Token
+	ownLine bool // Only spaces precede the comment in its line.
+	after   int  // The index of the last code token before the comment, or -1.
+}
+
+func (c comment) isLine() bool {
+	return strings.HasPrefix(c.Lexeme, "//")
+}
+
+type printer struct {
+	out    []byte
+	indent int
+
+	code     []token.Token          // Tokens except comments.
+	index    map[token.Location]int // Location of a code token -> its index in code.
+	comments []comment              // Comments not printed yet.
+	last     int                    // The index of the last code token printed, or -1.
+	lastLine int                    // The source line where the last printed token or comment ends.
+
+	newline bool // The next output starts on a new line, because a line comment is printed.
+	space   bool // The next token is separated by a space, because a block comment is printed.
+
+	// A measuring printer only prints a codata on one line to measure its width.
+	measuring bool
+	failed    bool // The measured node cannot be printed on one line.
+}
+
+func newPrinter() *printer {
+	return &printer{
+		out:       nil,
+		indent:    0,
+		code:      nil,
+		index:     make(map[token.Location]int),
+		comments:  nil,
+		last:      -1,
+		lastLine:  0,
+		newline:   false,
+		space:     false,
+		measuring: false,
+		failed:    false,
+	}
+}
+
+func (p *printer) String() string {
+	out := bytes.TrimRight(p.out, " \n")
+	if len(out) == 0 {
+		return ""
+	}
+
+	return string(out) + "\n"
+}
+
+// before returns the index of the last code token before loc, or -1.
+func (p *printer) before(loc token.Location) int {
+	last := -1
+	for i, t := range p.code {
+		if !isBefore(t.Location, loc) {
+			break
+		}
+		last = i
+	}
+
+	return last
+}
+
+func isBefore(a, b token.Location) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// endLine returns the line where t ends.
+func endLine(t token.Token) int {
+	return t.Location.Line + strings.Count(t.Lexeme, "\n")
+}
+
+// write writes s on the current line.
+func (p *printer) write(s string) {
+	if p.newline {
+		p.breakLine()
+	}
+	if p.space {
+		p.space = false
+		if s != "" && !strings.ContainsAny(s[:1], ",;)].") {
+			p.blank()
+		}
+	}
+	p.out = append(p.out, s...)
+}
+
+// blank writes a space unless the current line is empty or ends with a space.
+func (p *printer) blank() {
+	if p.newline || p.atLineStart() || p.out[len(p.out)-1] == ' ' {
+		return
+	}
+	p.out = append(p.out, ' ')
+}
+
+func (p *printer) atLineStart() bool {
+	line := p.out[bytes.LastIndexByte(p.out, '\n')+1:]
+
+	return len(bytes.TrimLeft(line, " ")) == 0
+}
+
+// column returns the width of the current line.
+func (p *printer) column() int {
+	return utf8.RuneCount(p.out[bytes.LastIndexByte(p.out, '\n')+1:])
+}
+
+// breakLine starts a new line. Comments after the last token in its source line are printed before it.
+func (p *printer) breakLine() {
+	p.trailing()
+	p.out = bytes.TrimRight(p.out, " ")
+	p.out = append(p.out, '\n')
+	p.out = append(p.out, strings.Repeat(indentUnit, p.indent)...)
+	p.newline = false
+	p.space = false
+}
+
+// startLine starts a new line unless the current line is empty.
+func (p *printer) startLine() {
+	if len(p.out) > 0 && (p.newline || !p.atLineStart()) {
+		p.breakLine()
+	}
+}
+
+// blankLine puts a blank line before the current empty line.
+func (p *printer) blankLine() {
+	out := bytes.TrimRight(p.out, " ")
+	if len(out) == 0 || bytes.HasSuffix(out, []byte("\n\n")) {
+		return
+	}
+	p.out = append(out, '\n')
+	p.out = append(p.out, strings.Repeat(indentUnit, p.indent)...)
+}
+
+// token writes text for t. Comments before t are printed first.
+func (p *printer) token(t token.Token, text string) {
+	p.flush(t.Location)
+	p.write(text)
+	if i, ok := p.index[t.Location]; ok && i > p.last {
+		p.last = i
+	}
+	p.lastLine = max(p.lastLine, endLine(t))
+}
+
+// punct writes text for the code token at i, which is -1 if the token is unknown.
+// Unlike [printer.token], it does not make comments after the token stop trailing the last token.
+func (p *printer) punct(i int, text string) {
+	if i >= 0 {
+		p.flush(p.code[i].Location)
+	}
+	p.write(text)
+}
+
+// flush prints comments before loc.
+func (p *printer) flush(loc token.Location) {
+	for len(p.comments) > 0 && isBefore(p.comments[0].Location, loc) {
+		p.comment()
+	}
+}
+
+// flushAll prints all the rest of comments.
+func (p *printer) flushAll() {
+	p.trailing()
+	for len(p.comments) > 0 {
+		p.comment()
+	}
+}
+
+// trailing prints comments that follow the last token in its source line.
+// Only brackets and delimiters may be between the token and the comments.
+func (p *printer) trailing() {
+	for len(p.comments) > 0 && !p.newline {
+		c := p.comments[0]
+		if c.Location.Line != p.lastLine || !p.onlyPunct(p.last+1, c.after) {
+			return
+		}
+		p.comment()
+	}
+}
+
+// onlyPunct reports whether code[from:to+1] consists of tokens that are printed by [printer.punct] or as keywords.
+func (p *printer) onlyPunct(from, to int) bool {
+	for _, t := range p.code[from : to+1] {
+		//exhaustive:ignore
+		switch t.Kind {
+		case token.IDENT, token.OPERATOR, token.INTEGER, token.FLOAT, token.STRING, token.SHARP,
+			token.DEF, token.TYPE, token.INFIX, token.INFIXL, token.INFIXR, token.LET, token.WITH, token.PRIM:
+			return false
+		}
+	}
+
+	return true
+}
+
+// comment prints the first comment not printed yet.
+func (p *printer) comment() {
+	c := p.comments[0]
+	p.comments = p.comments[1:]
+
+	if c.ownLine {
+		p.startLine()
+		if p.gap(c.Location, c.after) {
+			p.blankLine()
+		}
+		p.out = append(p.out, c.Lexeme...)
+		p.newline = true
+	} else {
+		p.blank()
+		p.write(c.Lexeme)
+		if c.isLine() {
+			p.newline = true
+		} else {
+			p.space = true
+		}
+	}
+	p.lastLine = max(p.lastLine, endLine(c.Token))
+}
+
+// gap reports whether there is a blank line in the source between loc and what is printed before it.
+// after is the index of the last code token before loc.
+func (p *printer) gap(loc token.Location, after int) bool {
+	if len(p.out) == 0 {
+		return false
+	}
+	prev := p.lastLine
+	if after >= 0 {
+		prev = max(prev, endLine(p.code[after]))
+	}
+
+	return loc.Line-prev > 1
+}
+
+// keyword returns the token of the keyword that is before the token at loc in the source.
+// If it is not found, it returns a token without location.
+func (p *printer) keyword(loc token.Location, kind token.Kind, lexeme string) token.Token {
+	if i, ok := p.index[loc]; ok && i > 0 && p.code[i-1].Kind == kind {
+		return p.code[i-1]
+	}
+
+	return token.Token{Kind: kind, Lexeme: lexeme, Location: token.Location{}, Literal: nil}
+}
+
+// keywordOf returns the token of the keyword that begins an expression with node.
+// If it is not found, it returns a token without location.
+func (p *printer) keywordOf(node ast.Node, kind token.Kind, lexeme string) token.Token {
+	if first := p.first(node); first >= 0 {
+		// Brackets of the first pattern or expression are not in the AST.
+		i := first - 1
+		for i >= 0 && (p.code[i].Kind == token.LEFTPAREN || p.code[i].Kind == token.LEFTBRACKET) {
+			i--
+		}
+		if i >= 0 && p.code[i].Kind == kind {
+			return p.code[i]
+		}
+	}
+
+	return token.Token{Kind: kind, Lexeme: lexeme, Location: token.Location{}, Literal: nil}
+}
+
+// first returns the index of the first code token in node, or -1.
+func (p *printer) first(node ast.Node) int {
+	first := -1
+	for _, node := range ast.Universe(node) {
+		if i, ok := p.index[node.Base().Location]; ok && (first < 0 || i < first) {
+			first = i
+		}
+	}
+
+	return first
+}
+
+func (p *printer) decl(node ast.Node) {
+	var keyword token.Token
+	switch node := node.(type) {
+	case *ast.VarDecl:
+		keyword = p.keyword(node.Name.Location, token.DEF, "def")
+	case *ast.TypeDecl:
+		keyword = p.keyword(node.Def.Base().Location, token.TYPE, "type")
+	case *ast.InfixDecl:
+		keyword = node.Assoc
+	case *ast.ModuleDecl:
+		keyword = p.keyword(node.Name.Location, token.MODULE, "module")
+	case *ast.ImportDecl:
+		keyword = p.keyword(node.Name.Location, token.IMPORT, "import")
+	default:
+		log.Panicf("unexpected declaration: %v", node)
+	}
+	p.flush(keyword.Location)
+	p.startLine()
+	if p.gap(keyword.Location, p.before(keyword.Location)) {
+		p.blankLine()
+	}
+	p.token(keyword, keyword.Lexeme)
+	p.blank()
+
+	switch node := node.(type) {
+	case *ast.VarDecl:
+		p.token(node.Name, node.Name.Lexeme)
+		if node.Type != nil {
+			p.blank()
+			p.write(":")
+			p.blank()
+			p.typ(node.Type)
+		}
+		if node.Expr != nil {
+			p.blank()
+			p.write("=")
+			p.blank()
+			p.expr(node.Expr)
+		}
+	case *ast.TypeDecl:
+		p.typ(node.Def)
+		p.blank()
+		p.write("=")
+		p.blank()
+		open, isConstructors := p.typeBody(node)
+		if !isConstructors {
+			p.typ(node.Types[0])
+
+			break
+		}
+		items := make([]func(*printer), len(node.Types))
+		for i, typ := range node.Types {
+			items[i] = func(q *printer) { q.typ(typ) }
+		}
+		p.block(open, p.closing(open), items, false, true)
+	case *ast.InfixDecl:
+		p.token(node.Prec, node.Prec.Lexeme)
+		p.blank()
+		p.token(node.Name, node.Name.Lexeme)
+	case *ast.ModuleDecl:
+		p.token(node.Name, node.Name.Lexeme)
+		p.names(node.Exports)
+	case *ast.ImportDecl:
+		p.token(node.Name, node.Name.Lexeme)
+		p.names(node.Names)
+	}
+}
+
+// names prints the names of a module or import declaration in parentheses.
+// Nil names, which mean all names, are not printed.
+func (p *printer) names(names []token.Token) {
+	if names == nil {
+		return
+	}
+	p.blank()
+	p.write("(")
+	for i, name := range names {
+		if i > 0 {
+			p.write(",")
+			p.blank()
+		}
+		p.token(name, name.Lexeme)
+	}
+	p.write(")")
+}
+
+// typeBody returns the index of `{` that begins the constructors of the type declaration,
+// and whether the type declaration has constructors rather than a type.
+func (p *printer) typeBody(decl *ast.TypeDecl) (int, bool) {
+	if i, ok := p.index[decl.Def.Base().Location]; ok {
+		for i < len(p.code) && p.code[i].Kind != token.EQUAL {
+			i++
+		}
+		if i+1 >= len(p.code) || p.code[i+1].Kind != token.LEFTBRACE {
+			return -1, false
+		}
+		// `{ IDENT :` begins a record type.
+		isRecord := i+3 < len(p.code) && p.code[i+2].Kind == token.IDENT && p.code[i+3].Kind == token.COLON
+
+		return i + 1, !isRecord
+	}
+
+	if len(decl.Types) != 1 {
+		return -1, true
+	}
+	call, ok := decl.Types[0].(*ast.Call)
+	if !ok {
+		return -1, false
+	}
+	_, ok = call.Func.(*ast.Var)
+
+	return -1, ok
+}
+
+// closing returns the index of the bracket that closes the bracket at open, or -1.
+func (p *printer) closing(open int) int {
+	if open < 0 {
+		return -1
+	}
+	depth := 0
+	for i := open; i < len(p.code); i++ {
+		//exhaustive:ignore
+		switch p.code[i].Kind {
+		case token.LEFTPAREN, token.LEFTBRACE, token.LEFTBRACKET:
+			depth++
+		case token.RIGHTPAREN, token.RIGHTBRACE, token.RIGHTBRACKET:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// opening returns the index of `{` that begins the codata, or -1.
+func (p *printer) opening(codata *ast.Codata) int {
+	first := p.first(codata)
+	if first < 0 {
+		return -1
+	}
+	// Skip tokens that begin the first clause but are not in the AST.
+	i := first - 1
+	for i >= 0 {
+		//exhaustive:ignore
+		switch p.code[i].Kind {
+		case token.LEFTPAREN, token.LEFTBRACKET, token.PRIM, token.LET, token.WITH:
+			i--
+
+			continue
+		case token.LEFTBRACE:
+			return i
+		}
+
+		break
+	}
+
+	return -1
+}
+
+// block prints items in braces, separated by commas.
+// Unless broken is true, the items are put on one line if they fit and there are no comments that end a line.
+func (p *printer) block(open, close int, items []func(*printer), broken, trailingComma bool) {
+	if p.measuring {
+		p.failed = p.failed || broken
+	} else if len(items) > 0 && (broken || p.lineCommentIn(open, close) || !p.fits(func(q *printer) { q.block(-1, -1, items, false, false) })) {
+		p.punct(open, "{")
+		p.indent++
+		for i, item := range items {
+			p.breakLine()
+			item(p)
+			if trailingComma || i < len(items)-1 {
+				p.write(",")
+			}
+		}
+		if close >= 0 {
+			p.flush(p.code[close].Location)
+		}
+		p.indent--
+		p.breakLine()
+		p.write("}")
+
+		return
+	}
+
+	p.punct(open, "{")
+	for i, item := range items {
+		if i > 0 {
+			p.write(",")
+		}
+		p.blank()
+		item(p)
+	}
+	if len(items) > 0 {
+		p.blank()
+	}
+	p.punct(close, "}")
+}
+
+// fits reports whether render prints one line that fits in [Width] from the current column.
+func (p *printer) fits(render func(*printer)) bool {
+	q := newPrinter()
+	q.measuring = true
+	render(q)
+
+	return !q.failed && !bytes.ContainsRune(q.out, '\n') && p.column()+utf8.RuneCount(q.out) <= Width
+}
+
+// lineCommentIn reports whether there is a comment that ends a line between the tokens at open and close.
+func (p *printer) lineCommentIn(open, close int) bool {
+	if open < 0 || close < 0 {
+		return false
+	}
+	for _, c := range p.comments {
+		if isBefore(p.code[open].Location, c.Location) && isBefore(c.Location, p.code[close].Location) &&
+			(c.ownLine || c.isLine()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// list prints nodes separated by commas.
+func (p *printer) list(nodes []ast.Node, render func(ast.Node)) {
+	for i, node := range nodes {
+		if i > 0 {
+			p.write(",")
+			p.blank()
+		}
+		render(node)
+	}
+}
+
+// expr prints an expression or a pattern.
+func (p *printer) expr(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Var:
+		p.token(node.Name, node.Name.Lexeme)
+	case *ast.Literal:
+		p.token(node.Token, node.Lexeme)
+	case *ast.This:
+		p.token(node.Token, "#")
+	case *ast.Paren:
+		p.write("(")
+		p.expr(node.Expr)
+		p.write(")")
+	case *ast.Tuple:
+		p.write("[")
+		p.list(node.Exprs, p.expr)
+		p.write("]")
+	case *ast.Access:
+		p.expr(node.Receiver)
+		p.write(".")
+		p.token(node.Name, node.Name.Lexeme)
+	case *ast.Call:
+		p.expr(node.Func)
+		p.write("(")
+		p.list(node.Args, p.expr)
+		p.write(")")
+	case *ast.Prim:
+		p.prim(node, p.expr)
+	case *ast.Binary:
+		p.expr(node.Left)
+		p.blank()
+		p.token(node.Op, node.Op.Lexeme)
+		p.blank()
+		p.expr(node.Right)
+	case *ast.Assert:
+		p.expr(node.Expr)
+		p.blank()
+		p.write(":")
+		p.blank()
+		p.typ(node.Type)
+	case *ast.Let:
+		p.token(p.keywordOf(node.Bind, token.LET, "let"), "let")
+		p.blank()
+		p.expr(node.Bind)
+		p.blank()
+		p.write("=")
+		p.blank()
+		p.expr(node.Body)
+	case *ast.With:
+		first := node.Body
+		if len(node.Binds) > 0 {
+			first = node.Binds[0]
+		}
+		p.token(p.keywordOf(first, token.WITH, "with"), "with")
+		p.blank()
+		if len(node.Binds) > 0 {
+			p.list(node.Binds, p.expr)
+			p.blank()
+			p.write("<-")
+			p.blank()
+		}
+		p.expr(node.Body)
+	case *ast.Codata:
+		p.codata(node)
+	default:
+		log.Panicf("unexpected node: %v", node)
+	}
+}
+
+func (p *printer) prim(prim *ast.Prim, render func(ast.Node)) {
+	keyword := token.Token{Kind: token.PRIM, Lexeme: "prim", Location: token.Location{}, Literal: nil}
+	if i, ok := p.index[prim.Name.Location]; ok && i >= 2 && p.code[i-2].Kind == token.PRIM {
+		keyword = p.code[i-2]
+	}
+	p.token(keyword, "prim")
+	p.write("(")
+	p.token(prim.Name, prim.Name.Lexeme)
+	for _, arg := range prim.Args {
+		p.write(",")
+		p.blank()
+		render(arg)
+	}
+	p.write(")")
+}
+
+func (p *printer) codata(codata *ast.Codata) {
+	broken := len(codata.Clauses) > 1
+	items := make([]func(*printer), len(codata.Clauses))
+	for i, clause := range codata.Clauses {
+		broken = broken || len(body(clause)) > 1
+		items[i] = func(q *printer) { q.clause(clause) }
+	}
+	// The implicit `#()` clause is the only clause in practice, and looks like a block without a trailing comma.
+	trailingComma := len(codata.Clauses) > 1 || !isImplicit(codata.Clauses[0].Pattern)
+
+	open := p.opening(codata)
+	p.block(open, p.closing(open), items, broken, trailingComma)
+}
+
+func (p *printer) clause(clause *ast.CodataClause) {
+	exprs := body(clause)
+	if !isImplicit(clause.Pattern) {
+		p.expr(clause.Pattern)
+		p.blank()
+		p.write("->")
+		if len(exprs) > 1 && !p.measuring {
+			p.indent++
+			p.breakLine()
+			p.seq(exprs)
+			p.indent--
+
+			return
+		}
+		p.blank()
+	}
+	p.seq(exprs)
+}
+
+// seq prints the expressions of a clause, one per line unless it is measured.
+func (p *printer) seq(exprs []ast.Node) {
+	for i, expr := range exprs {
+		if i > 0 {
+			p.write(";")
+			if p.measuring {
+				p.blank()
+			} else {
+				p.breakLine()
+			}
+		}
+		p.expr(expr)
+	}
+}
+
+func body(clause *ast.CodataClause) []ast.Node {
+	if seq, ok := clause.Expr.(*ast.Seq); ok {
+		return seq.Exprs
+	}
+
+	return []ast.Node{clause.Expr}
+}
+
+// isImplicit reports whether the pattern is `#()`, which the parser inserts to a clause without `->`.
+func isImplicit(pattern ast.Node) bool {
+	call, ok := pattern.(*ast.Call)
+	if !ok || len(call.Args) != 0 {
+		return false
+	}
+	_, ok = call.Func.(*ast.This)
+
+	return ok
+}
+
+func (p *printer) typ(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Call:
+		if this, ok := node.Func.(*ast.This); ok {
+			// A parameter list such as `(Int, Int)` in `(Int, Int) -> Int`.
+			p.token(this.Token, "(")
+			p.list(node.Args, p.typ)
+			if len(node.Args) == 1 {
+				p.write(",")
+			}
+			p.write(")")
+
+			return
+		}
+		p.typ(node.Func)
+		p.write("(")
+		p.list(node.Args, p.typ)
+		p.write(")")
+	case *ast.Binary:
+		p.typ(node.Left)
+		p.blank()
+		p.token(node.Op, node.Op.Lexeme)
+		p.blank()
+		p.typ(node.Right)
+	case *ast.Paren:
+		p.write("(")
+		p.typ(node.Expr)
+		p.write(")")
+	case *ast.Tuple:
+		p.write("[")
+		p.list(node.Exprs, p.typ)
+		p.write("]")
+	case *ast.Prim:
+		p.prim(node, p.typ)
+	case *ast.Object:
+		items := make([]func(*printer), len(node.Fields))
+		for i, field := range node.Fields {
+			items[i] = func(q *printer) {
+				q.write(field.Name)
+				q.blank()
+				q.write(":")
+				q.blank()
+				q.typ(field.Expr)
+			}
+		}
+		p.block(-1, -1, items, false, true)
+	default:
+		p.expr(node)
+	}
+}
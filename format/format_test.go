@@ -0,0 +1,87 @@
+package format_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+	"github.com/takoeight0821/anma/format"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/parser"
+	"github.com/takoeight0821/anma/utils"
+)
+
+func TestGolden(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := utils.FindSourceFiles("../testdata")
+	if err != nil {
+		t.Errorf("failed to find test files: %v", err)
+
+		return
+	}
+	fixtures, err := utils.FindSourceFiles("testdata")
+	if err != nil {
+		t.Errorf("failed to find test files: %v", err)
+
+		return
+	}
+
+	for _, testfile := range append(testfiles, fixtures...) {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", testfile, err)
+
+			return
+		}
+
+		formatted, err := format.Source(testfile, string(source))
+		if err != nil {
+			t.Errorf("%s returned error: %v", testfile, err)
+
+			return
+		}
+
+		// Formatting is idempotent.
+		again, err := format.Source(testfile, formatted)
+		if err != nil {
+			t.Errorf("formatted %s returned error: %v", testfile, err)
+
+			return
+		}
+		if again != formatted {
+			t.Errorf("formatting %s is not idempotent:\n%s", testfile, utils.Diff(formatted, again))
+		}
+
+		// The formatted program has the same AST.
+		if expected, actual := parse(t, testfile, string(source)), parse(t, testfile, formatted); expected != actual {
+			t.Errorf("formatting %s changes the AST:\n%s", testfile, utils.Diff(expected, actual))
+		}
+
+		g := goldie.New(t)
+		g.Assert(t, filepath.Base(testfile), []byte(formatted))
+	}
+}
+
+func parse(t *testing.T, path, source string) string {
+	t.Helper()
+
+	tokens, err := lexer.Lex(path, source)
+	if err != nil {
+		t.Fatalf("%s returned error: %v", path, err)
+	}
+	nodes, err := parser.NewParser(tokens).ParseDecl()
+	if err != nil {
+		t.Fatalf("%s returned error: %v", path, err)
+	}
+
+	var builder strings.Builder
+	for _, node := range nodes {
+		builder.WriteString(node.String())
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
@@ -3,8 +3,12 @@ package infix
 import (
 	"fmt"
 	"log"
+	"maps"
+	"math/big"
+	"slices"
 
 	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/diag"
 	"github.com/takoeight0821/anma/token"
 	"github.com/takoeight0821/anma/utils"
 )
@@ -13,19 +17,65 @@ import (
 // In infix.go, we will fix this.
 
 type Resolver struct {
-	decls []*ast.InfixDecl
+	decls   []*ast.InfixDecl                     // Fixities in the current scope.
+	modules map[string][]*ast.InfixDecl          // Module name -> fixities exported by the module.
+	scopes  map[*ast.ModuleDecl][]*ast.InfixDecl // Module -> fixities in the module.
 }
 
 func NewInfixResolver() *Resolver {
-	return &Resolver{decls: make([]*ast.InfixDecl, 0)}
+	return &Resolver{
+		decls:   make([]*ast.InfixDecl, 0),
+		modules: make(map[string][]*ast.InfixDecl),
+		scopes:  make(map[*ast.ModuleDecl][]*ast.InfixDecl),
+	}
 }
 
 func (r *Resolver) Name() string {
 	return "infix.InfixResolver"
 }
 
+// Snapshot returns a function that forgets fixities of programs given to Init after it.
+func (r *Resolver) Snapshot() (restore func()) {
+	decls, modules, scopes := slices.Clone(r.decls), maps.Clone(r.modules), maps.Clone(r.scopes)
+
+	return func() {
+		r.decls, r.modules, r.scopes = decls, modules, scopes
+	}
+}
+
+// Init collects fixities of the program.
+// Each module has fixities declared in it and fixities of operators imported from other modules.
 func (r *Resolver) Init(program []ast.Node) error {
+	nodes, modules := ast.SplitModules(program)
+	for _, m := range modules {
+		// Fixities declared before the program, such as the prelude, are visible in modules.
+		saved := r.decls
+		r.decls = slices.Clone(saved)
+		if err := r.collect(m.Nodes); err != nil {
+			return err
+		}
+		r.scopes[m.Decl] = r.decls
+		r.modules[m.Decl.Name.Lexeme] = r.exports(m)
+		r.decls = saved
+	}
+	// Declarations out of modules, such as inputs of the REPL, see fixities of the last module.
+	if len(modules) > 0 {
+		for _, decl := range r.scopes[modules[len(modules)-1].Decl] {
+			r.add(decl)
+		}
+	}
+
+	return r.collect(nodes)
+}
+
+// collect adds fixities declared or imported in the declarations to the current scope.
+func (r *Resolver) collect(program []ast.Node) error {
 	for _, node := range program {
+		if decl, ok := node.(*ast.ImportDecl); ok {
+			r.importFixities(decl)
+
+			continue
+		}
 		_, err := ast.Traverse(node, func(node ast.Node, _ error) (ast.Node, error) {
 			switch node := node.(type) {
 			case *ast.InfixDecl:
@@ -44,9 +94,51 @@ func (r *Resolver) Init(program []ast.Node) error {
 	return nil
 }
 
-func (r *Resolver) Run(program []ast.Node) ([]ast.Node, error) {
+// exports returns fixities of operators exported by the module.
+// If the module has no export list, it exports fixities declared in the module.
+func (r *Resolver) exports(m ast.Module) []*ast.InfixDecl {
+	var exports []*ast.InfixDecl
+	for _, decl := range r.decls {
+		if (m.Decl.Exports == nil && slices.Contains(m.Nodes, ast.Node(decl))) || hasName(m.Decl.Exports, decl.Name) {
+			exports = append(exports, decl)
+		}
+	}
+
+	return exports
+}
+
+// importFixities adds fixities of operators imported by the declaration.
+func (r *Resolver) importFixities(imp *ast.ImportDecl) {
+	for _, decl := range r.modules[imp.Name.Lexeme] {
+		if imp.Names == nil || hasName(imp.Names, decl.Name) {
+			r.add(decl)
+		}
+	}
+}
+
+func hasName(names []token.Token, name token.Token) bool {
+	return slices.ContainsFunc(names, func(n token.Token) bool { return n.Lexeme == name.Lexeme })
+}
+
+func (r *Resolver) Run(program []ast.Node) (_ []ast.Node, err error) {
+	// mkBinary panics with a [utils.PosError] if operators cannot be associated.
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			posErr, ok := recovered.(utils.PosError)
+			if !ok {
+				panic(recovered)
+			}
+			err = fmt.Errorf("infix: %w", posErr)
+		}
+	}()
+
+	saved := r.decls
+	defer func() { r.decls = saved }()
+
 	for i, node := range program {
-		var err error
+		if decl, ok := node.(*ast.ModuleDecl); ok {
+			r.decls = r.scopes[decl]
+		}
 		program[i], err = ast.Traverse(node, func(node ast.Node, _ error) (ast.Node, error) {
 			switch n := node.(type) {
 			case *ast.Binary:
@@ -65,19 +157,30 @@ func (r *Resolver) Run(program []ast.Node) ([]ast.Node, error) {
 	return program, nil
 }
 
+// add registers the fixity of an operator.
+// It replaces the earlier declaration of the same operator, even if the declaration is in an earlier program.
 func (r *Resolver) add(infix *ast.InfixDecl) {
+	r.decls = slices.DeleteFunc(r.decls, func(decl *ast.InfixDecl) bool {
+		return decl.Name.Lexeme == infix.Name.Lexeme
+	})
 	r.decls = append(r.decls, infix)
 }
 
+// arrowPrec is the precedence of `->` in types. It binds weaker than any operator.
+const arrowPrec = -1
+
 func (r Resolver) prec(op token.Token) int {
+	if op.Kind == token.ARROW {
+		return arrowPrec
+	}
 	for _, decl := range r.decls {
 		if decl.Name.Lexeme == op.Lexeme {
-			literal, ok := decl.Prec.Literal.(int)
-			if !ok {
+			literal, ok := decl.Prec.Literal.(*big.Int)
+			if !ok || !literal.IsInt64() {
 				log.Panicf("invalid precedence: %v", decl.Prec)
 			}
 
-			return literal
+			return int(literal.Int64())
 		}
 	}
 
@@ -85,6 +188,9 @@ func (r Resolver) prec(op token.Token) int {
 }
 
 func (r Resolver) assoc(op token.Token) token.Kind {
+	if op.Kind == token.ARROW {
+		return token.INFIXR
+	}
 	for _, decl := range r.decls {
 		if decl.Name.Lexeme == op.Lexeme {
 			return decl.Assoc.Kind
@@ -139,3 +245,17 @@ type NeedParenError struct {
 func (e NeedParenError) Error() string {
 	return fmt.Sprintf("need parentheses around %v and %v", e.LeftOp, e.RightOp)
 }
+
+func (NeedParenError) Code() string {
+	return "E0401"
+}
+
+func (e NeedParenError) Spans() []diag.Span {
+	return []diag.Span{diag.SpanOf(e.RightOp)}
+}
+
+func (e NeedParenError) Notes() []string {
+	return []string{
+		fmt.Sprintf("`%s` and `%s` have the same precedence but cannot be associated", e.LeftOp.Lexeme, e.RightOp.Lexeme),
+	}
+}
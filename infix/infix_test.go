@@ -7,9 +7,7 @@ import (
 
 	"github.com/sebdah/goldie/v2"
 	"github.com/takoeight0821/anma/codata"
-	"github.com/takoeight0821/anma/desugarwith"
 	"github.com/takoeight0821/anma/driver"
-	"github.com/takoeight0821/anma/infix"
 	"github.com/takoeight0821/anma/utils"
 )
 
@@ -31,10 +29,7 @@ func TestGolden(t *testing.T) {
 			return
 		}
 
-		runner := driver.NewPassRunner()
-		runner.AddPass(&desugarwith.DesugarWith{})
-		runner.AddPass(&codata.Flat{})
-		runner.AddPass(infix.NewInfixResolver())
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageInfix, NoPrelude: true})
 
 		nodes, err := runner.RunSource(testfile, string(source))
 		if err != nil {
@@ -53,3 +48,33 @@ func TestGolden(t *testing.T) {
 		g.Assert(t, testfile, []byte(builder.String()))
 	}
 }
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	inputs := []string{
+		"infixl 6 + infixl 7 *",
+		"def x = 1 + 2 * 3",
+		"infixl 8 +",
+		"def y = 1 + 2 * 3",
+	}
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageInfix, Session: true, NoPrelude: true})
+
+	var builder strings.Builder
+	for _, input := range inputs {
+		nodes, err := runner.RunSource("session", input)
+		if err != nil {
+			t.Errorf("%s returned error: %v", input, err)
+
+			return
+		}
+		for _, node := range nodes {
+			builder.WriteString(node.String())
+			builder.WriteString("\n")
+		}
+	}
+
+	g := goldie.New(t)
+	g.Assert(t, "session", []byte(builder.String()))
+}
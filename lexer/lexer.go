@@ -3,14 +3,30 @@ package lexer
 import (
 	"errors"
 	"fmt"
+	"math/big"
+	"slices"
 	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
 )
 
+// Lex converts the source code into tokens.
+// Comments are skipped.
 func Lex(filePath, source string) ([]token.Token, error) {
+	return lex(filePath, source, false)
+}
+
+// LexWithComments is like [Lex], but keeps comments as [token.COMMENT] tokens.
+// It is for tools that need comments, such as a formatter.
+func LexWithComments(filePath, source string) ([]token.Token, error) {
+	return lex(filePath, source, true)
+}
+
+func lex(filePath, source string, keepComments bool) ([]token.Token, error) {
 	lexer := lexer{
 		source:  source,
 		tokens:  []token.Token{},
@@ -20,6 +36,8 @@ func Lex(filePath, source string) ([]token.Token, error) {
 		filePath: filePath,
 		line:     1,
 		column:   1,
+
+		keepComments: keepComments,
 	}
 
 	var err error
@@ -43,6 +61,8 @@ type lexer struct {
 	filePath string // current file path
 	line     int    // current line number
 	column   int    // current column number
+
+	keepComments bool // if true, comments are added as tokens
 }
 
 func (l lexer) location() token.Location {
@@ -62,10 +82,34 @@ func (l lexer) peek() rune {
 	return runeValue
 }
 
+func (l lexer) peekNext() rune {
+	if l.isAtEnd() {
+		return '\x00'
+	}
+	_, width := utf8.DecodeRuneInString(l.source[l.current:])
+	if l.current+width >= len(l.source) {
+		return '\x00'
+	}
+	runeValue, _ := utf8.DecodeRuneInString(l.source[l.current+width:])
+
+	return runeValue
+}
+
+// isCommentStart reports whether a comment starts at the current position.
+func (l lexer) isCommentStart() bool {
+	return l.peek() == '/' && (l.peekNext() == '/' || l.peekNext() == '*')
+}
+
+// advance consumes a rune and updates the line and column.
 func (l *lexer) advance() rune {
 	runeValue, width := utf8.DecodeRuneInString(l.source[l.current:])
 	l.current += width
-	l.column++
+	if runeValue == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
 
 	return runeValue
 }
@@ -76,12 +120,15 @@ func (l *lexer) addToken(loc token.Location, kind token.Kind, literal any) {
 }
 
 type UnexpectedCharacterError struct {
-	Line int
 	Char rune
 }
 
 func (e UnexpectedCharacterError) Error() string {
-	return fmt.Sprintf("unexpected character: %c at line %d", e.Char, e.Line)
+	return fmt.Sprintf("unexpected character: %c", e.Char)
+}
+
+func (UnexpectedCharacterError) Code() string {
+	return "E0101"
 }
 
 func (l *lexer) scanToken() error {
@@ -89,16 +136,22 @@ func (l *lexer) scanToken() error {
 	loc := l.location()
 	char := l.advance()
 	switch char {
-	case ' ', '\r', '\t':
+	case ' ', '\r', '\t', '\n':
 		// ignore whitespace
-		return nil
-	case '\n':
-		l.line++
-		l.column = 1
-
 		return nil
 	case '"':
 		return l.string(loc)
+	case '`':
+		return l.rawString(loc)
+	case '/':
+		if l.peek() == '/' {
+			return l.lineComment(loc)
+		}
+		if l.peek() == '*' {
+			return l.blockComment(loc)
+		}
+
+		return l.operator(loc)
 	default:
 		if k, ok := getReservedSymbol(char); ok {
 			l.addToken(loc, k, nil)
@@ -106,7 +159,13 @@ func (l *lexer) scanToken() error {
 			return nil
 		}
 		if isDigit(char) {
-			return l.integer(loc)
+			return l.number(loc)
+		}
+		if char == '-' && isDigit(l.peek()) && l.expectsOperand() {
+			// Negative number literal such as `-1`.
+			l.advance()
+
+			return l.number(loc)
 		}
 		if isAlpha(char) {
 			return l.identifier(loc)
@@ -116,40 +175,140 @@ func (l *lexer) scanToken() error {
 		}
 	}
 
-	return UnexpectedCharacterError{Line: l.line, Char: char}
+	return l.errorAt(loc, token.OPERATOR, UnexpectedCharacterError{Char: char})
 }
 
-type UnterminatedStringError struct {
-	Line int
+// errorAt reports err at the lexeme of kind from loc to the current position.
+// Only the first line of a multi-line lexeme is shown.
+func (l *lexer) errorAt(loc token.Location, kind token.Kind, err error) error {
+	lexeme, _, _ := strings.Cut(l.source[l.start:l.current], "\n")
+	where := token.Token{Kind: kind, Lexeme: lexeme, Location: loc, Literal: nil}
+
+	return utils.PosError{Where: where, Err: err}
 }
 
-func (e UnterminatedStringError) Error() string {
-	return fmt.Sprintf("unterminated string at line %d", e.Line)
+type UnterminatedStringError struct{}
+
+func (UnterminatedStringError) Error() string {
+	return "unterminated string"
+}
+
+func (UnterminatedStringError) Code() string {
+	return "E0102"
 }
 
+// string scans a string literal enclosed by `"`.
+// It may span multiple lines, and escape sequences are decoded.
+// Invalid escape sequences are reported after scanning the whole literal.
 func (l *lexer) string(loc token.Location) error {
-	for l.peek() != '"' && !l.isAtEnd() {
-		if l.peek() == '\n' {
-			l.line++
+	var value strings.Builder
+	var errs error
+	for l.peek() != '"' {
+		if l.isAtEnd() {
+			return errors.Join(errs, l.errorAt(loc, token.STRING, UnterminatedStringError{}))
 		}
-		if l.peek() == '\\' {
-			l.advance()
-			if l.isAtEnd() {
-				return UnterminatedStringError{Line: l.line}
-			}
+		if l.peek() != '\\' {
+			value.WriteRune(l.advance())
+
+			continue
 		}
-		l.advance()
+		r, err := l.escape()
+		if err != nil {
+			errs = errors.Join(errs, err)
+
+			continue
+		}
+		value.WriteRune(r)
+	}
+	l.advance() // closing '"'
+
+	if errs != nil {
+		return errs
+	}
+	l.addToken(loc, token.STRING, value.String())
+
+	return nil
+}
+
+// InvalidEscapeError is an error that is returned when a string literal contains an unknown escape sequence.
+type InvalidEscapeError struct {
+	Escape string
+}
+
+func (e InvalidEscapeError) Error() string {
+	return fmt.Sprintf("invalid escape sequence %s", e.Escape)
+}
+
+func (InvalidEscapeError) Code() string {
+	return "E0103"
+}
+
+// escape decodes an escape sequence.
+// Supported sequences are `\n`, `\t`, `\\`, `\"`, and `\u{...}` with 1 to 6 hexadecimal digits.
+func (l *lexer) escape() (rune, error) {
+	start := l.current
+	loc := l.location()
+	invalid := func() error {
+		where := token.Token{Kind: token.STRING, Lexeme: l.source[start:l.current], Location: loc, Literal: nil}
+
+		return utils.PosError{Where: where, Err: InvalidEscapeError{Escape: where.Lexeme}}
 	}
 
+	l.advance() // '\\'
 	if l.isAtEnd() {
-		return UnterminatedStringError{Line: l.line}
+		return 0, invalid()
 	}
+	switch l.advance() {
+	case 'n':
+		return '\n', nil
+	case 't':
+		return '\t', nil
+	case '\\':
+		return '\\', nil
+	case '"':
+		return '"', nil
+	case 'u':
+		if l.peek() != '{' {
+			return 0, invalid()
+		}
+		l.advance()
+		digits := l.current
+		for isHexDigit(l.peek()) {
+			l.advance()
+		}
+		hex := l.source[digits:l.current]
+		if l.peek() != '}' {
+			return 0, invalid()
+		}
+		l.advance()
+		if len(hex) == 0 || len(hex) > 6 {
+			return 0, invalid()
+		}
+		code, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return 0, invalid()
+		}
 
-	r := l.advance()
+		return rune(code), nil
+	default:
+		return 0, invalid()
+	}
+}
+
+func isHexDigit(c rune) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
 
-	if r != '"' {
-		return UnterminatedStringError{Line: l.line}
+// rawString scans a raw string literal enclosed by backquotes.
+// It may span multiple lines, and backslashes have no special meaning.
+func (l *lexer) rawString(loc token.Location) error {
+	for l.peek() != '`' {
+		if l.isAtEnd() {
+			return l.errorAt(loc, token.STRING, UnterminatedStringError{})
+		}
+		l.advance()
 	}
+	l.advance() // closing '`'
 
 	value := l.source[l.start+1 : l.current-1]
 	l.addToken(loc, token.STRING, value)
@@ -157,24 +316,194 @@ func (l *lexer) string(loc token.Location) error {
 	return nil
 }
 
+// lineComment skips a comment until the end of the line.
+func (l *lexer) lineComment(loc token.Location) error {
+	for l.peek() != '\n' && !l.isAtEnd() {
+		l.advance()
+	}
+	if l.keepComments {
+		l.addToken(loc, token.COMMENT, nil)
+	}
+
+	return nil
+}
+
+type UnterminatedCommentError struct{}
+
+func (UnterminatedCommentError) Error() string {
+	return "unterminated comment"
+}
+
+func (UnterminatedCommentError) Code() string {
+	return "E0104"
+}
+
+// blockComment skips a comment enclosed by `/*` and `*/`.
+// Block comments can be nested.
+func (l *lexer) blockComment(loc token.Location) error {
+	l.advance() // skip '*'
+	depth := 1
+	for depth > 0 {
+		if l.isAtEnd() {
+			return l.errorAt(loc, token.COMMENT, UnterminatedCommentError{})
+		}
+		switch {
+		case l.peek() == '/' && l.peekNext() == '*':
+			l.advance()
+			l.advance()
+			depth++
+		case l.peek() == '*' && l.peekNext() == '/':
+			l.advance()
+			l.advance()
+			depth--
+		default:
+			l.advance()
+		}
+	}
+	if l.keepComments {
+		l.addToken(loc, token.COMMENT, nil)
+	}
+
+	return nil
+}
+
 func isDigit(c rune) bool {
 	return c >= '0' && c <= '9'
 }
 
-func (l *lexer) integer(loc token.Location) error {
-	for isDigit(l.peek()) {
+// expectsOperand reports whether the next token is at the beginning of an operand.
+// `-` followed by a digit is a negative number literal only in such a position.
+// For example, `f(-1)` and `x - -1` contain `-1`, but `x -1` does not.
+func (l lexer) expectsOperand() bool {
+	if len(l.tokens) == 0 {
+		return true
+	}
+
+	//exhaustive:ignore
+	switch l.tokens[len(l.tokens)-1].Kind {
+	case token.IDENT, token.INTEGER, token.FLOAT, token.STRING, token.RIGHTPAREN, token.RIGHTBRACKET, token.RIGHTBRACE:
+		return false
+	default:
+		return true
+	}
+}
+
+// InvalidNumberError is an error that is returned when a number literal is malformed.
+type InvalidNumberError struct {
+	Literal string
+}
+
+func (e InvalidNumberError) Error() string {
+	return "invalid number literal " + e.Literal
+}
+
+func (InvalidNumberError) Code() string {
+	return "E0105"
+}
+
+// number scans a number literal.
+// Integers may have a `0x`, `0o`, or `0b` prefix, and are arbitrary-precision [big.Int].
+// Decimal numbers with a fraction or an exponent are float64.
+// Digits may be separated by `_`.
+func (l *lexer) number(loc token.Location) error {
+	digitsStart := l.current - 1 // the first digit is already consumed
+	base := 10
+	if l.source[digitsStart] == '0' {
+		switch l.peek() {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 10 {
+			l.advance()
+			digitsStart = l.current
+		}
+	}
+
+	l.digits(base)
+	isFloat := false
+	if base == 10 && l.peek() == '.' && isDigit(l.peekNext()) {
+		isFloat = true
+		l.advance()
+		l.digits(base)
+	}
+	if base == 10 && (l.peek() == 'e' || l.peek() == 'E') {
+		saved := *l
 		l.advance()
+		if l.peek() == '+' || l.peek() == '-' {
+			l.advance()
+		}
+		if isDigit(l.peek()) {
+			isFloat = true
+			l.digits(base)
+		} else {
+			// Not an exponent. For example, `1e` is `1` followed by `e`.
+			*l = saved
+		}
 	}
 
-	value, err := strconv.Atoi(l.source[l.start:l.current])
-	if err != nil {
-		return fmt.Errorf("invalid integer: %w", err)
+	lexeme := l.source[l.start:l.current]
+	invalid := utils.PosError{
+		Where: token.Token{Kind: token.INTEGER, Lexeme: lexeme, Location: loc, Literal: nil},
+		Err:   InvalidNumberError{Literal: lexeme},
+	}
+	digits := l.source[digitsStart:l.current]
+	if !validSeparators(digits, base) {
+		return invalid
+	}
+	digits = strings.ReplaceAll(digits, "_", "")
+	if strings.HasPrefix(lexeme, "-") {
+		digits = "-" + digits
+	}
+
+	if isFloat {
+		value, err := strconv.ParseFloat(digits, 64)
+		if err != nil {
+			return invalid
+		}
+		l.addToken(loc, token.FLOAT, value)
+
+		return nil
+	}
+
+	value, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return invalid
 	}
 	l.addToken(loc, token.INTEGER, value)
 
 	return nil
 }
 
+// digits consumes digits of the base and separators.
+// Decimal digits are consumed regardless of the base, so that `0b12` is reported as an invalid literal.
+func (l *lexer) digits(base int) {
+	for isDigit(l.peek()) || l.peek() == '_' || (base == 16 && isHexDigit(l.peek())) {
+		l.advance()
+	}
+}
+
+// validSeparators reports whether each `_` is between two digits.
+func validSeparators(digits string, base int) bool {
+	isDigitOf := isDigit
+	if base == 16 {
+		isDigitOf = isHexDigit
+	}
+	for i, c := range digits {
+		if c != '_' {
+			continue
+		}
+		if i == 0 || i == len(digits)-1 || !isDigitOf(rune(digits[i-1])) || !isDigitOf(rune(digits[i+1])) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func isAlpha(c rune) bool {
 	return unicode.IsLetter(c) || c == '_'
 }
@@ -196,7 +525,15 @@ func (l *lexer) identifier(loc token.Location) error {
 }
 
 func getKeyword(str string) (token.Kind, bool) {
-	keywords := map[string]token.Kind{
+	if k, ok := keywordTable()[str]; ok {
+		return k, true
+	}
+
+	return token.IDENT, false
+}
+
+func keywordTable() map[string]token.Kind {
+	return map[string]token.Kind{
 		"->":     token.ARROW,
 		"<-":     token.BACKARROW,
 		"|":      token.BAR,
@@ -204,26 +541,33 @@ func getKeyword(str string) (token.Kind, bool) {
 		"case":   token.CASE,
 		"def":    token.DEF,
 		"fn":     token.FN,
+		"import": token.IMPORT,
 		"infix":  token.INFIX,
 		"infixl": token.INFIXL,
 		"infixr": token.INFIXR,
 		"let":    token.LET,
+		"module": token.MODULE,
 		"prim":   token.PRIM,
 		"type":   token.TYPE,
 		"with":   token.WITH,
 	}
+}
 
-	if k, ok := keywords[str]; ok {
-		return k, true
+// Keywords returns all keywords in sorted order, including symbols such as `->`.
+func Keywords() []string {
+	keywords := make([]string, 0, len(keywordTable()))
+	for keyword := range keywordTable() {
+		keywords = append(keywords, keyword)
 	}
+	slices.Sort(keywords)
 
-	return token.IDENT, false
+	return keywords
 }
 
 func isSymbol(c rune) bool {
 	_, isReserved := getReservedSymbol(c)
 
-	return c != '_' && !isReserved && (unicode.IsSymbol(c) || unicode.IsPunct(c))
+	return c != '_' && c != '"' && c != '`' && !isReserved && (unicode.IsSymbol(c) || unicode.IsPunct(c))
 }
 
 func getReservedSymbol(char rune) (token.Kind, bool) {
@@ -249,7 +593,7 @@ func getReservedSymbol(char rune) (token.Kind, bool) {
 }
 
 func (l *lexer) operator(loc token.Location) error {
-	for isSymbol(l.peek()) {
+	for isSymbol(l.peek()) && !l.isCommentStart() {
 		l.advance()
 	}
 
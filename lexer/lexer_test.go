@@ -3,6 +3,7 @@ package lexer_test
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -45,3 +46,68 @@ func TestGolden(t *testing.T) {
 		g.Assert(t, testfile, []byte(builder.String()))
 	}
 }
+
+func TestWithComments(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := utils.FindSourceFiles("testdata")
+	if err != nil {
+		t.Errorf("failed to find test files: %v", err)
+
+		return
+	}
+
+	for _, testfile := range testfiles {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", testfile, err)
+
+			return
+		}
+
+		tokens, err := lexer.LexWithComments(testfile, string(source))
+		if err != nil {
+			t.Errorf("%s returned error: %v", testfile, err)
+
+			return
+		}
+
+		var builder strings.Builder
+		for _, token := range tokens {
+			fmt.Fprintf(&builder, "%v %q %v %#v\n", token.Kind, token.Lexeme, token.Location, token.Literal)
+		}
+
+		g := goldie.New(t)
+		g.Assert(t, filepath.Base(testfile), []byte(builder.String()))
+	}
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := filepath.Glob("testdata/*.error")
+	if err != nil {
+		t.Errorf("failed to find test files: %v", err)
+
+		return
+	}
+
+	for _, testfile := range testfiles {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", testfile, err)
+
+			return
+		}
+
+		_, err = lexer.Lex(testfile, string(source))
+		if err == nil {
+			t.Errorf("%s must return error", testfile)
+
+			return
+		}
+
+		g := goldie.New(t)
+		g.Assert(t, filepath.Base(testfile), []byte(err.Error()+"\n"))
+	}
+}
@@ -0,0 +1,398 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/diag"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/module"
+	"github.com/takoeight0821/anma/nameresolve"
+	"github.com/takoeight0821/anma/parser"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/typecheck"
+)
+
+// declKind is the kind of a name declared at the top level.
+type declKind int
+
+const (
+	declLocal declKind = iota // Not declared at the top level.
+	declFunction
+	declType
+	declConstructor
+)
+
+// occurrence is an identifier or an operator in the source resolved by [nameresolve.Resolver].
+type occurrence struct {
+	name  token.Token // The occurrence in the source.
+	def   token.Token // The token that defines the name.
+	hover string      // Markdown shown on hover.
+}
+
+// document is an open text document and the result of analyzing it.
+// Locations of tokens in the document have its URI as the file path.
+type document struct {
+	uri         string
+	version     int
+	lines       []string
+	tokens      []token.Token // Tokens including comments.
+	decls       map[token.Location]declKind
+	symbols     []DocumentSymbol
+	occurrences []occurrence
+	diagnostics []Diagnostic
+}
+
+// analyze lexes, parses and runs the passes of [driver.PassRunner] on the text.
+// Syntax errors do not stop the analysis of the rest of the document.
+func analyze(uri string, version int, text string) *document {
+	doc := &document{
+		uri:         uri,
+		version:     version,
+		lines:       strings.Split(text, "\n"),
+		tokens:      nil,
+		decls:       make(map[token.Location]declKind),
+		symbols:     nil,
+		occurrences: nil,
+		diagnostics: []Diagnostic{},
+	}
+
+	tokens, lexErr := lexer.LexWithComments(uri, text)
+	doc.tokens = tokens
+	code := make([]token.Token, 0, len(tokens))
+	for _, t := range tokens {
+		if t.Kind != token.COMMENT {
+			code = append(code, t)
+		}
+	}
+
+	p := parser.NewParser(code)
+	nodes, parseErr := p.ParseDecl()
+	doc.collectDecls(nodes, code)
+	for _, warning := range p.Warnings() {
+		doc.diagnostics = append(doc.diagnostics, doc.diagnostic(diag.FromWarning(warning)))
+	}
+	if err := errors.Join(lexErr, parseErr); err != nil {
+		doc.report(err)
+
+		return doc
+	}
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageTypecheck})
+	// The resolver and the checker are kept to find definitions and types.
+	var resolver *nameresolve.Resolver
+	var checker *typecheck.Checker
+	for _, pass := range runner.Passes() {
+		switch pass := pass.(type) {
+		case *nameresolve.Resolver:
+			resolver = pass
+		case *typecheck.Checker:
+			checker = pass
+		}
+	}
+
+	program, err := loadImports(uri, text, nodes)
+	if err == nil {
+		program, err = runner.Run(program)
+	}
+	for _, warning := range runner.Warnings() {
+		doc.diagnostics = append(doc.diagnostics, doc.diagnostic(diag.FromWarning(warning)))
+	}
+	doc.report(err)
+	doc.collectOccurrences(program, code, resolver, checker)
+
+	return doc
+}
+
+// loadImports loads the modules imported by the document from the directory of the file.
+// The document itself is read from the text, which may not be saved yet.
+func loadImports(uri, text string, nodes []ast.Node) ([]ast.Node, error) {
+	if !slices.ContainsFunc(nodes, func(node ast.Node) bool {
+		_, ok := node.(*ast.ImportDecl)
+
+		return ok
+	}) {
+		return nodes, nil
+	}
+
+	dir := "."
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		dir = filepath.Dir(u.Path)
+	}
+	loader := module.Loader{
+		Dir: dir,
+		ReadFile: func(path string) ([]byte, error) {
+			if path == uri {
+				return []byte(text), nil
+			}
+
+			return os.ReadFile(path)
+		},
+	}
+	program, err := loader.Load(uri)
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+
+	return program.Nodes, nil
+}
+
+func (d *document) report(err error) {
+	for _, dg := range diag.FromError(err) {
+		d.diagnostics = append(d.diagnostics, d.diagnostic(dg))
+	}
+}
+
+// collectDecls collects top-level declarations and their symbols before the passes rewrite them.
+func (d *document) collectDecls(nodes []ast.Node, code []token.Token) {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ast.VarDecl:
+			d.decls[node.Name.Location] = declFunction
+			d.symbols = append(d.symbols, d.symbol(node.Name, "", SymbolFunction, code))
+		case *ast.TypeDecl:
+			name := node.Def.Base()
+			d.decls[name.Location] = declType
+			symbol := d.symbol(name, "", SymbolClass, code)
+			for _, typ := range node.Types {
+				if call, ok := typ.(*ast.Call); ok {
+					if ctor, ok := call.Func.(*ast.Var); ok {
+						d.decls[ctor.Name.Location] = declConstructor
+						nameRange := d.tokenRange(ctor.Name)
+						symbol.Children = append(symbol.Children, DocumentSymbol{
+							Name: ctor.Name.Lexeme, Detail: "", Kind: SymbolConstructor,
+							Range: nameRange, SelectionRange: nameRange, Children: nil,
+						})
+					}
+				}
+			}
+			d.symbols = append(d.symbols, symbol)
+		case *ast.InfixDecl:
+			detail := node.Assoc.Lexeme + " " + node.Prec.Lexeme
+			d.symbols = append(d.symbols, d.symbol(node.Name, detail, SymbolOperator, code))
+		}
+	}
+}
+
+// symbol returns the symbol of the declaration that declares name.
+// Its range spans from the keyword of the declaration to the token before the next declaration.
+func (d *document) symbol(name token.Token, detail string, kind SymbolKind, code []token.Token) DocumentSymbol {
+	index := 0
+	for i, t := range code {
+		if t.Location == name.Location {
+			index = i
+		}
+	}
+	start := index
+	for start > 0 && !isDeclKeyword(code[start].Kind) {
+		start--
+	}
+	end := index
+	for end+1 < len(code) && code[end+1].Kind != token.EOF && !isDeclKeyword(code[end+1].Kind) {
+		end++
+	}
+
+	return DocumentSymbol{
+		Name:   name.Lexeme,
+		Detail: detail,
+		Kind:   kind,
+		Range: Range{
+			Start: d.position(code[start].Location),
+			End:   d.tokenRange(code[end]).End,
+		},
+		SelectionRange: d.tokenRange(name),
+		Children:       nil,
+	}
+}
+
+func isDeclKeyword(kind token.Kind) bool {
+	//exhaustive:ignore
+	switch kind {
+	case token.DEF, token.TYPE, token.INFIX, token.INFIXL, token.INFIXR, token.MODULE, token.IMPORT:
+		return true
+	default:
+		return false
+	}
+}
+
+// collectOccurrences collects identifiers and operators resolved in the program.
+// Names introduced by the passes are ignored, because they do not appear in the source.
+func (d *document) collectOccurrences(
+	program []ast.Node, code []token.Token, resolver *nameresolve.Resolver, checker *typecheck.Checker,
+) {
+	source := make(map[token.Location]token.Token)
+	for _, t := range code {
+		if t.Kind == token.IDENT || t.Kind == token.OPERATOR {
+			source[t.Location] = t
+		}
+	}
+
+	seen := make(map[token.Location]bool)
+	for _, node := range program {
+		for _, node := range ast.Universe(node) {
+			var name token.Token
+			switch node := node.(type) {
+			case *ast.Var:
+				name = node.Name
+			case *ast.Binary:
+				name = node.Op
+			case *ast.VarDecl:
+				name = node.Name
+			default:
+				continue
+			}
+			id, ok := name.Literal.(int)
+			if !ok || seen[name.Location] || source[name.Location].Lexeme != name.Lexeme {
+				continue
+			}
+			def, ok := resolver.Definition(id)
+			if !ok {
+				continue
+			}
+			seen[name.Location] = true
+			d.occurrences = append(d.occurrences, occurrence{
+				name:  source[name.Location],
+				def:   def,
+				hover: d.hover(def, name, checker),
+			})
+		}
+	}
+}
+
+// hover returns the declaration of def in Markdown.
+func (d *document) hover(def, resolved token.Token, checker *typecheck.Checker) string {
+	var typ string
+	if scheme, ok := checker.TypeOf(resolved); ok {
+		typ = " : " + scheme.String()
+	}
+	if def.Location.FilePath != d.uri {
+		return fmt.Sprintf("```anma\n%s%s\n```\ndefined in %s", def.Lexeme, typ, def.Location.FilePath)
+	}
+
+	switch d.decls[def.Location] {
+	case declFunction:
+		return fmt.Sprintf("```anma\ndef %s%s\n```", def.Lexeme, typ)
+	case declType:
+		return fmt.Sprintf("```anma\ntype %s\n```", def.Lexeme)
+	case declConstructor:
+		return fmt.Sprintf("```anma\n%s%s\n```\nconstructor", def.Lexeme, typ)
+	case declLocal:
+		return fmt.Sprintf("```anma\n%s%s\n```\nlocal variable defined at line %d", def.Lexeme, typ, def.Location.Line)
+	}
+
+	return ""
+}
+
+// occurrenceAt returns the occurrence at the position.
+func (d *document) occurrenceAt(pos Position) (occurrence, bool) {
+	for _, occ := range d.occurrences {
+		r := d.tokenRange(occ.name)
+		if r.Start.Line == pos.Line && r.Start.Character <= pos.Character && pos.Character < r.End.Character {
+			return occ, true
+		}
+	}
+
+	return occurrence{name: token.Token{}, def: token.Token{}, hover: ""}, false
+}
+
+// diagnostic converts a diagnostic of the diag package.
+// Notes are appended to the message.
+func (d *document) diagnostic(dg diag.Diagnostic) Diagnostic {
+	var r Range
+	message := dg.Message
+	if !dg.Primary.IsZero() {
+		if file := dg.Primary.Start.FilePath; file != "" && file != d.uri {
+			// An error in an imported module is shown at the beginning of the document.
+			message = fmt.Sprintf("%s: %s", file, message)
+		} else {
+			r = d.spanRange(dg.Primary)
+		}
+	}
+	severity := SeverityError
+	if dg.Severity == diag.Warning {
+		severity = SeverityWarning
+	}
+	for _, note := range dg.Notes {
+		message += "\nnote: " + note
+	}
+	var related []DiagnosticRelatedInformation
+	for _, span := range dg.Secondary {
+		if span.IsZero() {
+			continue
+		}
+		label := span.Label
+		if label == "" {
+			label = dg.Message
+		}
+		related = append(related, DiagnosticRelatedInformation{
+			Location: Location{URI: span.Start.FilePath, Range: d.spanRange(span)},
+			Message:  label,
+		})
+	}
+
+	return Diagnostic{
+		Range:              r,
+		Severity:           severity,
+		Code:               dg.Code,
+		Source:             "anma",
+		Message:            message,
+		RelatedInformation: related,
+	}
+}
+
+// position converts a location to a position in UTF-16 code units.
+func (d *document) position(loc token.Location) Position {
+	line := loc.Line - 1
+	if line < 0 || line >= len(d.lines) {
+		return Position{Line: max(line, 0), Character: 0}
+	}
+
+	character := 0
+	column := 1
+	for _, r := range d.lines[line] {
+		if column >= loc.Column {
+			break
+		}
+		character += utf16Len(r)
+		column++
+	}
+	// A location after the end of the line, such as the end of file.
+	character += max(loc.Column-column, 0)
+
+	return Position{Line: line, Character: character}
+}
+
+// utf16Len returns the number of UTF-16 code units that encode r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2 // A surrogate pair.
+	}
+
+	return 1
+}
+
+func (d *document) spanRange(span diag.Span) Range {
+	return Range{Start: d.position(span.Start), End: d.position(span.End)}
+}
+
+// tokenRange returns the range of the token. A multi-line token ends on its last line.
+func (d *document) tokenRange(t token.Token) Range {
+	end := t.Location
+	lines := strings.Split(t.Lexeme, "\n")
+	if len(lines) > 1 {
+		end.Line += len(lines) - 1
+		end.Column = 1
+	}
+	end.Column += utf8.RuneCountInString(lines[len(lines)-1])
+
+	return Range{Start: d.position(t.Location), End: d.position(end)}
+}
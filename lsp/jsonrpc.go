@@ -0,0 +1,92 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// message is a JSON-RPC 2.0 request, notification or response.
+// A notification has no ID, and a response has no method.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+// ResponseError is the error of a response.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// Error codes defined by JSON-RPC and LSP.
+const (
+	codeParseError           = -32700
+	codeInvalidParams        = -32602
+	codeMethodNotFound       = -32601
+	codeInvalidRequest       = -32600
+	codeServerNotInitialized = -32002
+)
+
+// Conn reads and writes JSON-RPC messages framed by the base protocol of LSP:
+// a Content-Length header, an empty line, and the JSON content.
+type Conn struct {
+	reader *textproto.Reader
+	writer io.Writer
+}
+
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{reader: textproto.NewReader(bufio.NewReader(r)), writer: w}
+}
+
+// Read reads the content of the next message.
+func (c *Conn) Read() ([]byte, error) {
+	header, err := c.reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return nil, InvalidHeaderError{Header: header.Get("Content-Length")}
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(c.reader.R, content); err != nil {
+		return nil, fmt.Errorf("read content: %w", err)
+	}
+
+	return content, nil
+}
+
+// Write writes a message with its header.
+func (c *Conn) Write(msg any) error {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+	if _, err := fmt.Fprintf(c.writer, "Content-Length: %d\r\n\r\n%s", len(content), content); err != nil {
+		return fmt.Errorf("write message: %w", err)
+	}
+
+	return nil
+}
+
+// InvalidHeaderError is an error that is returned when a message does not have a valid Content-Length.
+type InvalidHeaderError struct {
+	Header string
+}
+
+func (e InvalidHeaderError) Error() string {
+	return fmt.Sprintf("invalid Content-Length: %q", e.Header)
+}
@@ -0,0 +1,377 @@
+package lsp_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/takoeight0821/anma/lsp"
+)
+
+const uri = "file:///test.anma"
+
+const source = `type Int = prim(int)
+type List(a) = { Nil(), Cons(a, List(a)) }
+
+infixl 6 +
+def + : (Int, Int) -> Int = { (x, y) -> prim(add, x, y) }
+
+// double adds x to itself.
+def double = { #(x) -> x + x }
+def main = { #() -> prim(print, double(21)) }
+`
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	client := start(t)
+	client.initialize()
+	client.notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{URI: uri, LanguageID: "anma", Version: 1, Text: source},
+	})
+	diagnostics := client.diagnostics()
+	if len(diagnostics.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %+v", diagnostics.Diagnostics)
+	}
+
+	t.Run("definition", func(t *testing.T) {
+		// `x` in `x + x` refers to the parameter.
+		var location *lsp.Location
+		client.request("textDocument/definition", at(7, 23), &location)
+		expected := &lsp.Location{URI: uri, Range: rng(7, 17, 7, 18)}
+		if diff := cmp.Diff(expected, location); diff != "" {
+			t.Errorf("definition of x mismatch (-want +got):\n%s", diff)
+		}
+
+		// `double` in main refers to the top-level definition.
+		client.request("textDocument/definition", at(8, 33), &location)
+		expected = &lsp.Location{URI: uri, Range: rng(7, 4, 7, 10)}
+		if diff := cmp.Diff(expected, location); diff != "" {
+			t.Errorf("definition of double mismatch (-want +got):\n%s", diff)
+		}
+
+		// `+` refers to the operator definition.
+		client.request("textDocument/definition", at(7, 25), &location)
+		expected = &lsp.Location{URI: uri, Range: rng(4, 4, 4, 5)}
+		if diff := cmp.Diff(expected, location); diff != "" {
+			t.Errorf("definition of + mismatch (-want +got):\n%s", diff)
+		}
+
+		// There is no name in a comment.
+		client.request("textDocument/definition", at(6, 5), &location)
+		if location != nil {
+			t.Errorf("expected no definition, actual %+v", location)
+		}
+	})
+
+	t.Run("hover", func(t *testing.T) {
+		var hover *lsp.Hover
+		client.request("textDocument/hover", at(7, 23), &hover)
+		if hover == nil || !strings.Contains(hover.Contents.Value, "local variable") {
+			t.Errorf("unexpected hover on x: %+v", hover)
+		}
+		client.request("textDocument/hover", at(4, 4), &hover)
+		if hover == nil || !strings.Contains(hover.Contents.Value, "def + : (Int, Int) -> Int") {
+			t.Errorf("unexpected hover on +: %+v", hover)
+		}
+	})
+
+	t.Run("documentSymbol", func(t *testing.T) {
+		var symbols []lsp.DocumentSymbol
+		client.request("textDocument/documentSymbol", lsp.DocumentSymbolParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+		}, &symbols)
+		var names []string
+		for _, symbol := range symbols {
+			names = append(names, symbol.Name)
+			for _, child := range symbol.Children {
+				names = append(names, symbol.Name+"."+child.Name)
+			}
+		}
+		expected := []string{"Int", "List", "List.Nil", "List.Cons", "+", "+", "double", "main"}
+		if diff := cmp.Diff(expected, names); diff != "" {
+			t.Errorf("symbols mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(rng(7, 0, 7, 30), symbols[4].Range); diff != "" {
+			t.Errorf("range of double mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("semanticTokens", func(t *testing.T) {
+		var tokens lsp.SemanticTokens
+		client.request("textDocument/semanticTokens/full", lsp.SemanticTokensParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+		}, &tokens)
+		if len(tokens.Data)%5 != 0 {
+			t.Fatalf("invalid semantic tokens: %v", tokens.Data)
+		}
+		// The first token is `type` at 0:0, a keyword.
+		if diff := cmp.Diff([]int{0, 0, 4, 0, 0}, tokens.Data[:5]); diff != "" {
+			t.Errorf("first token mismatch (-want +got):\n%s", diff)
+		}
+		// The comment is line 6.
+		line := 0
+		found := false
+		for i := 0; i < len(tokens.Data); i += 5 {
+			line += tokens.Data[i]
+			if line == 6 && tokens.Data[i+3] == 9 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("comment token not found: %v", tokens.Data)
+		}
+	})
+
+	t.Run("didChange", func(t *testing.T) {
+		client.notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+			TextDocument: lsp.VersionedTextDocumentIdentifier{URI: uri, Version: 2},
+			ContentChanges: []lsp.TextDocumentContentChangeEvent{
+				{Text: "def f = { #(x) -> x +, }\ndef g = { #() -> y }\ndef main = { #() -> f(1) "},
+			},
+		})
+		diagnostics := client.diagnostics()
+		var codes []string
+		for _, d := range diagnostics.Diagnostics {
+			codes = append(codes, d.Code)
+		}
+		// Both syntax errors are reported.
+		if diff := cmp.Diff([]string{"E0201", "E0202"}, codes); diff != "" {
+			t.Errorf("diagnostic codes mismatch (-want +got):\n%s", diff)
+		}
+		if *diagnostics.Version != 2 {
+			t.Errorf("unexpected version: %d", *diagnostics.Version)
+		}
+
+		client.notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+			TextDocument: lsp.VersionedTextDocumentIdentifier{URI: uri, Version: 3},
+			ContentChanges: []lsp.TextDocumentContentChangeEvent{
+				{Text: "def main = { #() -> y }"},
+			},
+		})
+		diagnostics = client.diagnostics()
+		if len(diagnostics.Diagnostics) != 1 || diagnostics.Diagnostics[0].Code != "E0501" ||
+			diagnostics.Diagnostics[0].Range != rng(0, 20, 0, 21) {
+			t.Errorf("unexpected diagnostics: %+v", diagnostics.Diagnostics)
+		}
+	})
+
+	client.request("shutdown", nil, nil)
+	client.notify("exit", nil)
+	if err := client.wait(); err != nil {
+		t.Errorf("server returned error: %v", err)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	t.Parallel()
+
+	client := start(t)
+	client.initialize()
+	client.notify("exit", nil)
+	if err := client.wait(); !errors.As(err, &lsp.ExitWithoutShutdownError{}) {
+		t.Errorf("expected exit without shutdown, actual %v", err)
+	}
+}
+
+func TestImports(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	lib := "module lib(double)\ndef double = { #(x) -> prim(add, x, x) }\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.anma"), []byte(lib), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := start(t)
+	client.initialize()
+
+	// The document is not saved, and imports a module in the same directory.
+	main := "file://" + filepath.Join(dir, "main.anma")
+	client.notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{
+			URI: main, LanguageID: "anma", Version: 1,
+			Text: "import lib\ndef main = { #() -> lib.double(21) }\n",
+		},
+	})
+	if diagnostics := client.diagnostics(); len(diagnostics.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %+v", diagnostics.Diagnostics)
+	}
+
+	missing := "file://" + filepath.Join(dir, "missing.anma")
+	client.notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{URI: missing, LanguageID: "anma", Version: 1, Text: "import nowhere\n"},
+	})
+	diagnostics := client.diagnostics()
+	if len(diagnostics.Diagnostics) != 1 || diagnostics.Diagnostics[0].Code != "E0901" {
+		t.Errorf("expected a module not found, actual %+v", diagnostics.Diagnostics)
+	}
+}
+
+// client is a scripted client of the language server.
+type client struct {
+	t        *testing.T
+	conn     *lsp.Conn
+	nextID   int
+	messages chan response
+	done     chan error
+}
+
+type response struct {
+	ID     *int               `json:"id"`
+	Method string             `json:"method"`
+	Params json.RawMessage    `json:"params"`
+	Result json.RawMessage    `json:"result"`
+	Error  *lsp.ResponseError `json:"error"`
+}
+
+// start starts a server connected to a new client.
+func start(t *testing.T) *client {
+	t.Helper()
+
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+	server := lsp.NewServer(toServer, fromServer)
+	c := &client{
+		t:        t,
+		conn:     lsp.NewConn(toClient, fromClient),
+		nextID:   0,
+		messages: make(chan response, 16),
+		done:     make(chan error, 1),
+	}
+	go func() {
+		c.done <- server.Serve()
+		fromServer.Close()
+	}()
+	// The server blocks until its messages are read, so messages are read in the background.
+	go func() {
+		defer close(c.messages)
+		for {
+			content, err := c.conn.Read()
+			if err != nil {
+				return
+			}
+			var msg response
+			if err := json.Unmarshal(content, &msg); err != nil {
+				t.Errorf("invalid message %s: %v", content, err)
+
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		fromClient.Close()
+	})
+
+	return c
+}
+
+func (c *client) initialize() {
+	var result lsp.InitializeResult
+	c.request("initialize", map[string]any{"processId": nil, "rootUri": nil, "capabilities": map[string]any{}}, &result)
+	if !result.Capabilities.DefinitionProvider || len(result.Capabilities.SemanticTokensProvider.Legend.TokenTypes) == 0 {
+		c.t.Errorf("unexpected capabilities: %+v", result.Capabilities)
+	}
+	c.notify("initialized", map[string]any{})
+}
+
+// request sends a request and decodes its result into result.
+func (c *client) request(method string, params, result any) {
+	c.t.Helper()
+
+	c.nextID++
+	id := c.nextID
+	if err := c.conn.Write(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+		c.t.Fatalf("failed to send %s: %v", method, err)
+	}
+	for {
+		msg := c.receive()
+		if msg.ID == nil || *msg.ID != id {
+			continue
+		}
+		if msg.Error != nil {
+			c.t.Fatalf("%s returned error: %v", method, msg.Error)
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("invalid result of %s: %v", method, err)
+			}
+		}
+
+		return
+	}
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+
+	if err := c.conn.Write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params}); err != nil {
+		c.t.Fatalf("failed to send %s: %v", method, err)
+	}
+}
+
+// diagnostics waits for the next diagnostics of the document.
+func (c *client) diagnostics() lsp.PublishDiagnosticsParams {
+	c.t.Helper()
+
+	for {
+		msg := c.receive()
+		if msg.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params lsp.PublishDiagnosticsParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			c.t.Fatalf("invalid diagnostics: %v", err)
+		}
+
+		return params
+	}
+}
+
+func (c *client) receive() response {
+	c.t.Helper()
+
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("connection closed")
+		}
+
+		return msg
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("timed out")
+	}
+
+	panic("unreachable")
+}
+
+// wait waits for the server to exit.
+func (c *client) wait() error {
+	select {
+	case err := <-c.done:
+		return err
+	case <-time.After(10 * time.Second):
+		return errors.New("timed out")
+	}
+}
+
+func at(line, character int) lsp.TextDocumentPositionParams {
+	return lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+		Position:     lsp.Position{Line: line, Character: character},
+	}
+}
+
+func rng(startLine, startChar, endLine, endChar int) lsp.Range {
+	return lsp.Range{
+		Start: lsp.Position{Line: startLine, Character: startChar},
+		End:   lsp.Position{Line: endLine, Character: endChar},
+	}
+}
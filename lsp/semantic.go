@@ -0,0 +1,114 @@
+package lsp
+
+import (
+	"maps"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/takoeight0821/anma/token"
+)
+
+// tokenTypes is the legend of semantic tokens. A semantic token refers to its type by the index.
+var tokenTypes = []string{
+	"keyword",
+	"function",
+	"variable",
+	"type",
+	"enumMember",
+	"property",
+	"operator",
+	"number",
+	"string",
+	"comment",
+}
+
+const (
+	semKeyword = iota
+	semFunction
+	semVariable
+	semType
+	semEnumMember
+	semProperty
+	semOperator
+	semNumber
+	semString
+	semComment
+	semNone = -1
+)
+
+// semanticTokens encodes the tokens of the document as relative positions,
+// five integers per token: delta line, delta start character, length, token type and modifiers.
+// A multi-line token is split into one token per line.
+func (d *document) semanticTokens() []int {
+	// A reference has the kind of its declaration.
+	kinds := maps.Clone(d.decls)
+	for _, occ := range d.occurrences {
+		kinds[occ.name.Location] = d.decls[occ.def.Location]
+	}
+
+	data := []int{}
+	prev := Position{Line: 0, Character: 0}
+	var before token.Kind
+	for _, t := range d.tokens {
+		typ := classify(t, before, kinds)
+		if t.Kind != token.COMMENT {
+			before = t.Kind
+		}
+		if typ == semNone {
+			continue
+		}
+
+		start := d.position(t.Location)
+		for i, line := range strings.Split(t.Lexeme, "\n") {
+			if i > 0 {
+				start = Position{Line: start.Line + 1, Character: 0}
+			}
+			length := len(utf16.Encode([]rune(line)))
+			if length == 0 {
+				continue
+			}
+			deltaStart := start.Character
+			if start.Line == prev.Line {
+				deltaStart -= prev.Character
+			}
+			data = append(data, start.Line-prev.Line, deltaStart, length, typ, 0)
+			prev = start
+		}
+	}
+
+	return data
+}
+
+// classify returns the semantic token type of t, which follows a token of the kind before.
+func classify(t token.Token, before token.Kind, kinds map[token.Location]declKind) int {
+	//exhaustive:ignore
+	switch t.Kind {
+	case token.CASE, token.DEF, token.FN, token.IMPORT, token.INFIX, token.INFIXL, token.INFIXR,
+		token.LET, token.MODULE, token.PRIM, token.TYPE, token.WITH, token.SHARP:
+		return semKeyword
+	case token.OPERATOR, token.ARROW, token.BACKARROW, token.BAR, token.EQUAL:
+		return semOperator
+	case token.INTEGER, token.FLOAT:
+		return semNumber
+	case token.STRING:
+		return semString
+	case token.COMMENT:
+		return semComment
+	case token.IDENT:
+		if before == token.DOT {
+			return semProperty
+		}
+		switch kinds[t.Location] {
+		case declFunction:
+			return semFunction
+		case declType:
+			return semType
+		case declConstructor:
+			return semEnumMember
+		case declLocal:
+			return semVariable
+		}
+	}
+
+	return semNone
+}
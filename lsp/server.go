@@ -0,0 +1,282 @@
+// Package lsp implements a Language Server Protocol server for Anma over a stream such as stdio.
+//
+// The server analyzes each open document with the same passes as [driver.PassRunner] runs,
+// and offers diagnostics, go-to-definition, hover, document symbols and semantic tokens.
+// Definitions are found by the unique numbers that [nameresolve.Resolver] assigns to names.
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Server is a language server. It handles one message at a time.
+type Server struct {
+	conn        *Conn
+	docs        map[string]*document // URI -> open document.
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		conn:        NewConn(r, w),
+		docs:        make(map[string]*document),
+		initialized: false,
+		shutdown:    false,
+	}
+}
+
+// Serve handles messages until the client sends the exit notification.
+// It fails if the client exits without the shutdown request, or the connection is closed.
+func (s *Server) Serve() error {
+	for {
+		content, err := s.conn.Read()
+		if err != nil {
+			return fmt.Errorf("serve: %w", err)
+		}
+
+		var msg message
+		if err := json.Unmarshal(content, &msg); err != nil {
+			if err := s.reply(nil, nil, &ResponseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ExitWithoutShutdownError{}
+			}
+
+			return nil
+		}
+
+		if msg.ID == nil {
+			if err := s.notify(msg.Method, msg.Params); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		result, rerr := s.request(msg.Method, msg.Params)
+		if err := s.reply(msg.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+// request handles a request and returns its result.
+func (s *Server) request(method string, params json.RawMessage) (any, *ResponseError) {
+	if method == "initialize" {
+		s.initialized = true
+
+		return s.initialize(), nil
+	}
+	if !s.initialized {
+		return nil, &ResponseError{Code: codeServerNotInitialized, Message: "server is not initialized"}
+	}
+	if s.shutdown {
+		return nil, &ResponseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+
+	switch method {
+	case "shutdown":
+		s.shutdown = true
+
+		return nil, nil
+	case "textDocument/definition":
+		return handle(s, params, s.definition)
+	case "textDocument/hover":
+		return handle(s, params, s.hover)
+	case "textDocument/documentSymbol":
+		return handle(s, params, s.documentSymbol)
+	case "textDocument/semanticTokens/full":
+		return handle(s, params, s.semanticTokens)
+	default:
+		return nil, &ResponseError{Code: codeMethodNotFound, Message: "method not found: " + method}
+	}
+}
+
+// handle decodes the parameters and calls the handler.
+func handle[P, R any](s *Server, params json.RawMessage, handler func(P) (R, error)) (any, *ResponseError) {
+	var p P
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &ResponseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	result, err := handler(p)
+	if err != nil {
+		return nil, &ResponseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	return result, nil
+}
+
+// notify handles a notification. Unknown notifications are ignored.
+func (s *Server) notify(method string, params json.RawMessage) error {
+	if !s.initialized {
+		return nil
+	}
+
+	var uri string
+	switch method {
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil //nolint:nilerr // Invalid notifications are ignored.
+		}
+		uri = p.TextDocument.URI
+		s.docs[uri] = analyze(uri, p.TextDocument.Version, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil || len(p.ContentChanges) == 0 {
+			return nil //nolint:nilerr // Invalid notifications are ignored.
+		}
+		uri = p.TextDocument.URI
+		text := p.ContentChanges[len(p.ContentChanges)-1].Text
+		s.docs[uri] = analyze(uri, p.TextDocument.Version, text)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil //nolint:nilerr // Invalid notifications are ignored.
+		}
+		delete(s.docs, p.TextDocument.URI)
+
+		// Clear the diagnostics of the closed document.
+		return s.send("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI: p.TextDocument.URI, Version: nil, Diagnostics: []Diagnostic{},
+		})
+	default:
+		return nil
+	}
+
+	doc := s.docs[uri]
+
+	return s.send("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI: uri, Version: &doc.version, Diagnostics: doc.diagnostics,
+	})
+}
+
+func (s *Server) initialize() InitializeResult {
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			PositionEncoding:       "utf-16",
+			TextDocumentSync:       syncFull,
+			DefinitionProvider:     true,
+			HoverProvider:          true,
+			DocumentSymbolProvider: true,
+			SemanticTokensProvider: SemanticTokensOptions{
+				Legend: SemanticTokensLegend{TokenTypes: tokenTypes, TokenModifiers: []string{}},
+				Full:   true,
+			},
+		},
+		ServerInfo: ServerInfo{Name: "anma"},
+	}
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, NotOpenError{URI: uri}
+	}
+
+	return doc, nil
+}
+
+// definition returns the location that defines the name at the position, or nil if there is no name.
+func (s *Server) definition(params TextDocumentPositionParams) (*Location, error) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	occ, ok := doc.occurrenceAt(params.Position)
+	// Definitions in other files, such as the prelude, are not located.
+	if !ok || occ.def.Location.FilePath != doc.uri {
+		return nil, nil //nolint:nilnil // The result is null.
+	}
+
+	return &Location{URI: occ.def.Location.FilePath, Range: doc.tokenRange(occ.def)}, nil
+}
+
+// hover returns the declaration of the name at the position, or nil if there is no name.
+func (s *Server) hover(params TextDocumentPositionParams) (*Hover, error) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	occ, ok := doc.occurrenceAt(params.Position)
+	if !ok {
+		return nil, nil //nolint:nilnil // The result is null.
+	}
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: occ.hover},
+		Range:    doc.tokenRange(occ.name),
+	}, nil
+}
+
+func (s *Server) documentSymbol(params DocumentSymbolParams) ([]DocumentSymbol, error) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if doc.symbols == nil {
+		return []DocumentSymbol{}, nil
+	}
+
+	return doc.symbols, nil
+}
+
+func (s *Server) semanticTokens(params SemanticTokensParams) (SemanticTokens, error) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return SemanticTokens{Data: nil}, err
+	}
+
+	return SemanticTokens{Data: doc.semanticTokens()}, nil
+}
+
+func (s *Server) reply(id *json.RawMessage, result any, rerr *ResponseError) error {
+	msg := message{JSONRPC: "2.0", ID: id, Method: "", Params: nil, Result: nil, Error: rerr}
+	if id == nil {
+		null := json.RawMessage("null")
+		msg.ID = &null
+	}
+	if rerr == nil {
+		content, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("marshal result: %w", err)
+		}
+		msg.Result = content
+	}
+
+	return s.conn.Write(msg)
+}
+
+func (s *Server) send(method string, params any) error {
+	content, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("marshal params: %w", err)
+	}
+
+	return s.conn.Write(message{JSONRPC: "2.0", ID: nil, Method: method, Params: content, Result: nil, Error: nil})
+}
+
+// ExitWithoutShutdownError is an error that is returned when the client exits without the shutdown request.
+type ExitWithoutShutdownError struct{}
+
+func (ExitWithoutShutdownError) Error() string {
+	return "exit without shutdown"
+}
+
+// NotOpenError is an error that is returned when a request refers to a document that is not open.
+type NotOpenError struct {
+	URI string
+}
+
+func (e NotOpenError) Error() string {
+	return "document is not open: " + e.URI
+}
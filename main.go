@@ -4,165 +4,265 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"strings"
 
-	"github.com/adrg/xdg"
-	"github.com/peterh/liner"
 	"github.com/takoeight0821/anma/codata"
-	"github.com/takoeight0821/anma/desugarwith"
-	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/diag"
 	"github.com/takoeight0821/anma/eval"
-	"github.com/takoeight0821/anma/infix"
-	"github.com/takoeight0821/anma/nameresolve"
-	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/lsp"
+	"github.com/takoeight0821/anma/utils"
 )
 
-func main() {
-	const (
-		inputUsage = "input file path"
-	)
-	var inputPath string
-	flag.StringVar(&inputPath, "input", "", inputUsage)
-	flag.StringVar(&inputPath, "i", "", inputUsage+" (shorthand)")
-
-	flag.Parse()
-
-	if inputPath == "" {
-		// If no input file is specified, run the REPL.
-		err := RunPrompt()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
-		}
-	} else {
-		if err := RunFile(inputPath); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
-		}
+// Exit statuses of the anma command.
+// `anma run` exits with the status that the program passes to `prim(exit)`.
+const (
+	exitOK    = 0 // Success.
+	exitError = 1 // The program has errors, fails at run time, or the files cannot be read.
+	exitUsage = 2 // The command line is invalid.
+)
+
+// command is a subcommand such as `anma run`.
+type command struct {
+	name    string
+	args    string // The usage of arguments after the name.
+	summary string
+	run     func(args []string) int // It returns the exit status.
+}
+
+func commands() []command {
+	return []command{
+		{"run", "[flags] file", "run the main function of a file", runCommand},
+		{"check", "[flags] files...", "run all passes on files without running them", checkCommand},
+		{"dump", "-stage=stage [flags] file", "print the program after a stage: tokens, " + driverStages(), dumpCommand},
+		{"fmt", "[-w] [-check] [files...]", "format files, or the standard input", formatCommand},
+		{"repl", "[flags]", "start the interactive prompt (default without a command)", replCommand},
+		{"test", "[flags] [files or directories...]", "run files and compare their output with .out files", testCommand},
+		{"lsp", "", "run the language server over stdio", lspCommand},
 	}
 }
 
-func historyPath() string {
-	return filepath.Join(xdg.DataHome, "anma", ".anma_history")
+func main() {
+	os.Exit(dispatch(os.Args[1:]))
 }
 
-// writeHistory writes the history of the REPL to a file.
-func writeHistory(line *liner.State) {
-	// Create the directory for the history file if it does not exist.
-	if err := os.MkdirAll(filepath.Dir(historyPath()), os.ModePerm); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+// dispatch runs the subcommand named by the first argument.
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		return replCommand(nil)
 	}
-	// Write the history file.
-	// If the file does not exist, it will be created automatically.
-	// If the file exists, it will be overwritten.
-	if f, err := os.Create(historyPath()); err == nil {
-		defer f.Close()
-		if _, err := line.WriteHistory(f); err != nil {
-			fmt.Fprintln(os.Stderr, err)
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+
+		return exitOK
+	}
+	for _, cmd := range commands() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
 		}
 	}
-	line.Close()
+
+	fmt.Fprintf(os.Stderr, "anma: unknown command %q\n", args[0])
+	usage(os.Stderr)
+
+	return exitUsage
 }
 
-// readHistory reads the history of the REPL from a file.
-func readHistory(line *liner.State) {
-	if f, err := os.Open(historyPath()); err == nil {
-		defer f.Close()
-		if _, err := line.ReadHistory(f); err != nil {
-			fmt.Fprintln(os.Stderr, err)
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: anma <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-6s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `anma <command> -h` for the flags of a command.")
+}
+
+// newFlagSet returns flags of the command.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("anma "+name, flag.ContinueOnError)
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			flags.Usage = func() {
+				fmt.Fprintf(flags.Output(), "usage: anma %s %s\n", cmd.name, cmd.args)
+				flags.PrintDefaults()
+			}
 		}
 	}
+
+	return flags
 }
 
-// RunPrompt runs the REPL.
-func RunPrompt() error {
-	line := liner.NewLiner()
-	defer writeHistory(line)
-	readHistory(line)
+// parseFlags parses the arguments of a command.
+// If the command must exit, it returns false with the exit status.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
 
-	runner := driver.NewPassRunner()
-	runner.AddPass(&desugarwith.DesugarWith{})
-	runner.AddPass(&codata.Flat{})
-	runner.AddPass(infix.NewInfixResolver())
-	runner.AddPass(nameresolve.NewResolver())
+	return exitOK, true
+}
 
-	evaluator := eval.NewEvaluator()
+// usageError prints err with the usage of the command, and returns [exitUsage].
+func usageError(flags *flag.FlagSet, err error) int {
+	fmt.Fprintln(flags.Output(), err)
+	flags.Usage()
 
-	for {
-		input, err := line.Prompt("> ")
-		if err != nil {
-			return fmt.Errorf("prompt: %w", err)
-		}
-		line.AppendHistory(input)
+	return exitUsage
+}
 
-		nodes, err := runner.RunSource("repl", input)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+const (
+	exhaustiveUsage = "how to report non-exhaustive copatterns: warn, error, or ignore"
+	formatUsage     = "how to print errors and warnings: text or json (one object per line)"
+	noPreludeUsage  = "do not load the standard prelude"
+)
 
-			continue
-		}
+// passFlags are flags of commands that run the passes.
+type passFlags struct {
+	exhaustive string
+	format     string
+	noPrelude  bool
+}
 
-		// Evaluate all nodes.
-		for _, node := range nodes {
-			value, err := evaluator.Eval(node)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+func (f *passFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.exhaustive, "exhaustive", "warn", exhaustiveUsage)
+	flags.StringVar(&f.format, "diagnostics-format", "text", formatUsage)
+	flags.BoolVar(&f.noPrelude, "no-prelude", false, noPreludeUsage)
+}
 
-				continue
-			}
-			fmt.Println(value)
-		}
+func (f passFlags) parse() (codata.CheckMode, diagnosticsFormat, error) {
+	mode, err := parseCheckMode(f.exhaustive)
+	if err != nil {
+		return mode, formatText, err
 	}
+	format, err := parseDiagnosticsFormat(f.format)
+
+	return mode, format, err
 }
 
-// RunFile runs the specified file.
-func RunFile(path string) error {
-	runner := driver.NewPassRunner()
-	runner.AddPass(&desugarwith.DesugarWith{})
-	runner.AddPass(&codata.Flat{})
-	runner.AddPass(infix.NewInfixResolver())
-	runner.AddPass(nameresolve.NewResolver())
+func lspCommand(args []string) int {
+	flags := newFlagSet("lsp")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
 
-	// Read the source code from the file.
-	bytes, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("read file: %w", err)
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return exitError
 	}
 
-	nodes, err := runner.RunSource(path, string(bytes))
-	if err != nil {
-		return fmt.Errorf("run file: %w", err)
+	return exitOK
+}
+
+func parseCheckMode(mode string) (codata.CheckMode, error) {
+	switch mode {
+	case "warn":
+		return codata.CheckWarn, nil
+	case "error":
+		return codata.CheckError, nil
+	case "ignore":
+		return codata.CheckIgnore, nil
+	default:
+		return codata.CheckWarn, invalidFlagError{Name: "exhaustive", Value: mode}
+	}
+}
+
+// diagnosticsFormat is how to print errors and warnings.
+type diagnosticsFormat int
+
+const (
+	formatText diagnosticsFormat = iota
+	formatJSON
+)
+
+func parseDiagnosticsFormat(name string) (diagnosticsFormat, error) {
+	switch name {
+	case "text":
+		return formatText, nil
+	case "json":
+		return formatJSON, nil
+	default:
+		return formatText, invalidFlagError{Name: "diagnostics-format", Value: name}
+	}
+}
+
+// reporter prints errors and warnings in the format of -diagnostics-format.
+type reporter struct {
+	format  diagnosticsFormat
+	sources diag.Sources
+}
+
+// warnings prints the warnings to stderr.
+func (r reporter) warnings(warnings []utils.Warning) {
+	diagnostics := make([]diag.Diagnostic, len(warnings))
+	for i, warning := range warnings {
+		diagnostics[i] = diag.FromWarning(warning)
 	}
+	if r.format == formatJSON {
+		if err := diag.WriteJSON(os.Stderr, diagnostics); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		return
+	}
+	for _, d := range diagnostics {
+		fmt.Fprintln(os.Stderr, r.sources.Render(d))
+	}
+}
 
-	evaluator := eval.NewEvaluator()
-	// Evaluate all nodes for loading definitions.
-	for _, node := range nodes {
-		_, err := evaluator.Eval(node)
-		if err != nil {
-			return fmt.Errorf("run file: %w", err)
+// error renders the diagnostics of err.
+// In the text format, the stack trace of a runtime error is appended to them.
+func (r reporter) error(err error) error {
+	diagnostics := diag.FromError(err)
+	if r.format == formatJSON {
+		var builder strings.Builder
+		if jsonErr := diag.WriteJSON(&builder, diagnostics); jsonErr != nil {
+			return errors.Join(err, jsonErr)
 		}
+
+		return renderedError{err: err, rendered: strings.TrimSuffix(builder.String(), "\n")}
 	}
 
-	main, ok := evaluator.SearchMain()
-	if !ok {
-		return noMainError{}
+	rendered := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		rendered[i] = r.sources.Render(d)
 	}
-	// top is a dummy token.
-	top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
-	_, err = main.Apply(top)
-	var exitErr eval.ExitError
-	if errors.As(err, &exitErr) {
-		os.Exit(exitErr.Code)
-	} else if err != nil {
-		return fmt.Errorf("run file: %w", err)
+	var runtimeErr eval.RuntimeError
+	if errors.As(err, &runtimeErr) {
+		rendered = append(rendered, runtimeErr.Traceback())
 	}
 
-	return nil
+	return renderedError{err: err, rendered: strings.Join(rendered, "\n\n")}
+}
+
+// renderedError is an error rendered by [reporter.error].
+type renderedError struct {
+	err      error
+	rendered string
+}
+
+func (e renderedError) Error() string {
+	return e.rendered
+}
+
+func (e renderedError) Unwrap() error {
+	return e.err
 }
 
-type noMainError struct{}
+type invalidFlagError struct {
+	Name  string
+	Value string
+}
 
-func (noMainError) Error() string {
-	return "no main function"
+func (e invalidFlagError) Error() string {
+	return fmt.Sprintf("invalid value %q for flag -%s", e.Value, e.Name)
 }
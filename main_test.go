@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDispatch runs commands on files in a temporary directory, and checks the exit status and what they print.
+// It is not parallel because the commands print to os.Stdout and os.Stderr.
+//
+//nolint:paralleltest
+func TestDispatch(t *testing.T) {
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"hello.anma":       `def main = { #() -> prim(print, "hello") }`,
+		"exit.anma":        "def main = { #() -> prim(print_cps, \"bye\\n\", { prim(exit) }); prim(div, 1, 0) }",
+		"div.anma":         "def main = { #() -> prim(div, 1, 0) }",
+		"mismatch.anma":    `def main = { #() -> prim(add, 1, "a") }`,
+		"lib.anma":         `def answer = 42`,
+		"pass/ok.anma":     `def main = { #() -> prim(print, 1) }`,
+		"pass/ok.anma.out": "1\n",
+		"fail/ng.anma":     `def main = { #() -> prim(print, 2) }`,
+		"fail/ng.anma.out": "1\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	tests := []struct {
+		args   []string
+		status int
+		stdout string // A part of the standard output.
+		stderr string // A part of the standard error.
+	}{
+		{[]string{"help"}, exitOK, "usage: anma <command> [arguments]", ""},
+		{[]string{"frobnicate"}, exitUsage, "", `anma: unknown command "frobnicate"`},
+		{[]string{"run", "-h"}, exitOK, "", "usage: anma run [flags] file"},
+		{[]string{"run", "-no-such-flag", "hello.anma"}, exitUsage, "", "flag provided but not defined: -no-such-flag"},
+		{[]string{"run"}, exitUsage, "", "expected one file"},
+		{[]string{"run", "-backend=jit", "hello.anma"}, exitUsage, "", `invalid value "jit" for flag -backend`},
+		{[]string{"check", "-exhaustive=maybe", "lib.anma"}, exitUsage, "", `invalid value "maybe" for flag -exhaustive`},
+		{[]string{"run", "-diagnostics-format=xml", "hello.anma"}, exitUsage, "", `invalid value "xml" for flag -diagnostics-format`},
+		{[]string{"run", "hello.anma"}, exitOK, `"hello"`, ""},
+		{[]string{"run", "-backend=vm", "hello.anma"}, exitOK, `"hello"`, ""},
+		// `prim(exit)` ends the program with its status before the division.
+		{[]string{"run", "exit.anma"}, exitOK, "bye", ""},
+		{[]string{"run", "-backend=vm", "exit.anma"}, exitOK, "bye", ""},
+		{[]string{"run", "div.anma"}, exitError, "", "division by zero"},
+		{[]string{"run", "lib.anma"}, exitError, "", "no main function"},
+		{[]string{"run", "missing.anma"}, exitError, "", "missing.anma"},
+		{[]string{"run", "-diagnostics-format=json", "mismatch.anma"}, exitError, "", `"code":"E0601"`},
+		{[]string{"check", "lib.anma", "hello.anma"}, exitOK, "", ""},
+		{[]string{"check", "lib.anma", "mismatch.anma"}, exitError, "", "error[E0601]: type mismatch"},
+		{[]string{"test", "pass"}, exitOK, "1 passed, 0 failed", ""},
+		{[]string{"test", "pass", "fail"}, exitError, "FAIL fail/ng.anma\nunexpected output:", ""},
+	}
+	for _, test := range tests {
+		args := make([]string, len(test.args))
+		for i, arg := range test.args {
+			args[i] = arg
+			if strings.HasSuffix(arg, ".anma") || arg == "pass" || arg == "fail" {
+				args[i] = filepath.Join(dir, arg)
+			}
+		}
+		status, stdout, stderr := capture(t, args)
+		stdout = strings.ReplaceAll(stdout, dir+string(filepath.Separator), "")
+		if status != test.status || !strings.Contains(stdout, test.stdout) || !strings.Contains(stderr, test.stderr) {
+			t.Errorf("anma %s: expected status %d, stdout with %q and stderr with %q, got %d\nstdout:\n%s\nstderr:\n%s",
+				strings.Join(test.args, " "), test.status, test.stdout, test.stderr, status, stdout, stderr)
+		}
+	}
+}
+
+// capture calls dispatch with the arguments, and returns the exit status with the standard output and error.
+func capture(t *testing.T, args []string) (int, string, string) {
+	t.Helper()
+
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	files := make([]*os.File, 2)
+	for i := range files {
+		file, err := os.CreateTemp(t.TempDir(), "output")
+		if err != nil {
+			t.Fatalf("failed to create a file for output: %v", err)
+		}
+		defer file.Close()
+		files[i] = file
+	}
+	os.Stdout, os.Stderr = files[0], files[1]
+
+	status := dispatch(args)
+
+	outputs := make([]string, len(files))
+	for i, file := range files {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		output, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		outputs[i] = string(output)
+	}
+
+	return status, outputs[0], outputs[1]
+}
@@ -0,0 +1,243 @@
+// Package module loads a program that consists of modules.
+//
+// A module is a file. `import name` refers to the module in `name.anma` of the directory of the main file,
+// and the loader loads imported modules recursively.
+// The loaded program has the declarations of all modules, where a module comes after the modules it imports,
+// and each module begins with its [ast.ModuleDecl] so that passes can split the program by [ast.SplitModules].
+package module
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/parser"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// Extension is the extension of module files.
+const Extension = ".anma"
+
+// Program is the declarations of the modules in a program.
+type Program struct {
+	Nodes    []ast.Node        // Declarations of all modules. Imported modules come first.
+	Sources  map[string]string // File path -> source code of the loaded files.
+	Warnings []utils.Warning   // Warnings of the parser.
+}
+
+// Loader loads modules from files.
+type Loader struct {
+	// Dir is the directory of imported modules.
+	// If it is empty, Load uses the directory of the main file.
+	Dir string
+	// ReadFile reads a file. If it is nil, Load uses [os.ReadFile].
+	ReadFile func(path string) ([]byte, error)
+
+	program *Program
+	loaded  map[string]bool // Module name -> whether it is loaded. Modules being loaded are false.
+	stack   []string        // Names of modules being loaded, in order of imports.
+}
+
+// Load loads the module of the main file and the modules it imports.
+func Load(path string) (*Program, error) {
+	var loader Loader
+
+	return loader.Load(path)
+}
+
+// Load loads the module of the main file and the modules it imports.
+// The program has the sources of the files read so far even if it fails, so that errors can be rendered.
+func (l *Loader) Load(path string) (*Program, error) {
+	if l.Dir == "" {
+		l.Dir = filepath.Dir(path)
+	}
+	if l.ReadFile == nil {
+		l.ReadFile = os.ReadFile
+	}
+	l.program = &Program{Nodes: nil, Sources: make(map[string]string), Warnings: nil}
+	l.loaded = make(map[string]bool)
+	l.stack = nil
+
+	name := strings.TrimSuffix(filepath.Base(path), Extension)
+	source, err := l.ReadFile(path)
+	if err != nil {
+		return l.program, fmt.Errorf("read file: %w", err)
+	}
+
+	return l.program, l.load(name, path, string(source))
+}
+
+// load loads the module from the source and the modules it imports.
+func (l *Loader) load(name, path, source string) error {
+	l.loaded[name] = false
+	l.stack = append(l.stack, name)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+	l.program.Sources[path] = source
+
+	tokens, err := lexer.Lex(path, source)
+	if err != nil {
+		return fmt.Errorf("lex: %w", err)
+	}
+	p := parser.NewParser(tokens)
+	nodes, err := p.ParseDecl()
+	l.program.Warnings = append(l.program.Warnings, p.Warnings()...)
+	if err != nil {
+		return fmt.Errorf("parse: %w", err)
+	}
+
+	decl, nodes, err := moduleDecl(name, nodes, tokens[0])
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if imp, ok := node.(*ast.ImportDecl); ok {
+			if err := l.importModule(imp.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	l.loaded[name] = true
+	l.program.Nodes = append(l.program.Nodes, decl)
+	l.program.Nodes = append(l.program.Nodes, nodes...)
+
+	return nil
+}
+
+// importModule loads the module imported by `import name` unless it is loaded.
+func (l *Loader) importModule(name token.Token) error {
+	loaded, seen := l.loaded[name.Lexeme]
+	if loaded {
+		return nil
+	}
+	if seen {
+		cycle := append(slices.Clone(l.stack[slices.Index(l.stack, name.Lexeme):]), name.Lexeme)
+
+		return utils.PosError{Where: name, Err: CycleError{Cycle: cycle}}
+	}
+
+	path := filepath.Join(l.Dir, name.Lexeme+Extension)
+	source, err := l.ReadFile(path)
+	if err != nil {
+		return utils.PosError{Where: name, Err: NotFoundError{Name: name.Lexeme, Path: path, Err: err}}
+	}
+
+	return l.load(name.Lexeme, path, string(source))
+}
+
+// MainName returns the resolved name of `def main` in the entry module of the program, which is its last module.
+// If the program has no module declaration, it searches all top-level declarations.
+// Functions named main in imported modules are not the main.
+func MainName(program []ast.Node) (token.Token, bool) {
+	nodes, modules := ast.SplitModules(program)
+	if len(modules) > 0 {
+		nodes = modules[len(modules)-1].Nodes
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if decl, ok := nodes[i].(*ast.VarDecl); ok && decl.Name.Lexeme == "main" {
+			return decl.Name, true
+		}
+	}
+
+	return token.Token{}, false
+}
+
+// moduleDecl returns the module declaration of the module and the other declarations.
+// If the module has no declaration, it returns a declaration that exports all names.
+// where is the location of a declaration made up.
+func moduleDecl(name string, nodes []ast.Node, where token.Token) (*ast.ModuleDecl, []ast.Node, error) {
+	decl := &ast.ModuleDecl{
+		Name:    token.Token{Kind: token.IDENT, Lexeme: name, Location: where.Location, Literal: nil},
+		Exports: nil,
+	}
+	if len(nodes) > 0 {
+		if first, ok := nodes[0].(*ast.ModuleDecl); ok {
+			decl = first
+			nodes = nodes[1:]
+		}
+	}
+	if decl.Name.Lexeme != name {
+		return nil, nil, utils.PosError{Where: decl.Name, Err: NameMismatchError{Name: decl.Name.Lexeme, File: name}}
+	}
+
+	// Imports come before other declarations.
+	imports := true
+	for _, node := range nodes {
+		switch node.(type) {
+		case *ast.ModuleDecl:
+			return nil, nil, utils.PosError{Where: node.Base(), Err: MisplacedDeclError{Decl: node}}
+		case *ast.ImportDecl:
+			if !imports {
+				return nil, nil, utils.PosError{Where: node.Base(), Err: MisplacedDeclError{Decl: node}}
+			}
+		default:
+			imports = false
+		}
+	}
+
+	return decl, nodes, nil
+}
+
+type NotFoundError struct {
+	Name string
+	Path string
+	Err  error // The error of reading the file.
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("module %s is not found: %v", e.Name, e.Err)
+}
+
+func (e NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+func (NotFoundError) Code() string {
+	return "E0901"
+}
+
+type CycleError struct {
+	Cycle []string // Names of modules in the cycle. The first and the last are the same.
+}
+
+func (e CycleError) Error() string {
+	return "import cycle: " + strings.Join(e.Cycle, " -> ")
+}
+
+func (CycleError) Code() string {
+	return "E0902"
+}
+
+type MisplacedDeclError struct {
+	Decl ast.Node
+}
+
+func (e MisplacedDeclError) Error() string {
+	if _, ok := e.Decl.(*ast.ModuleDecl); ok {
+		return "module declaration must be the first declaration"
+	}
+
+	return "import declaration must come before other declarations"
+}
+
+func (MisplacedDeclError) Code() string {
+	return "E0903"
+}
+
+type NameMismatchError struct {
+	Name string // The name in the module declaration.
+	File string // The name of the file.
+}
+
+func (e NameMismatchError) Error() string {
+	return fmt.Sprintf("module %s must be in %s%s, not %s%s", e.Name, e.Name, Extension, e.File, Extension)
+}
+
+func (NameMismatchError) Code() string {
+	return "E0904"
+}
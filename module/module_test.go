@@ -0,0 +1,141 @@
+package module_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/module"
+	"github.com/takoeight0821/anma/token"
+)
+
+// TestGolden loads main.anma of each directory in testdata, and resolves names of the program.
+func TestGolden(t *testing.T) {
+	t.Parallel()
+
+	entries, err := os.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
+		nodes, _, err := runner.RunModule(filepath.Join("testdata", entry.Name(), "main.anma"))
+
+		var builder strings.Builder
+		for _, node := range nodes {
+			builder.WriteString(node.String())
+			builder.WriteString("\n")
+		}
+		if err != nil {
+			fmt.Fprintf(&builder, "error => %v\n", err)
+		}
+
+		g := goldie.New(t)
+		g.Assert(t, entry.Name(), []byte(builder.String()))
+	}
+}
+
+func TestLoadOrder(t *testing.T) {
+	t.Parallel()
+
+	program, err := module.Load(filepath.Join("testdata", "program", "main.anma"))
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	var order []string
+	for _, node := range program.Nodes {
+		if decl, ok := node.(*ast.ModuleDecl); ok {
+			order = append(order, decl.Name.Lexeme)
+		}
+	}
+	// Imported modules come first.
+	if strings.Join(order, " ") != "arith list main" {
+		t.Errorf("expected modules in order arith list main, got %v", order)
+	}
+	if len(program.Sources) != 3 {
+		t.Errorf("expected sources of 3 files, got %d", len(program.Sources))
+	}
+}
+
+func TestCycle(t *testing.T) {
+	t.Parallel()
+
+	_, err := module.Load(filepath.Join("testdata", "cycle", "main.anma"))
+	var cycle module.CycleError
+	if !errors.As(err, &cycle) || strings.Join(cycle.Cycle, " ") != "a b a" {
+		t.Errorf("expected a cycle a -> b -> a, got %v", err)
+	}
+}
+
+// TestConstructors checks that constructors of the same name in different modules are different constructors.
+func TestConstructors(t *testing.T) {
+	t.Parallel()
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckError, Until: driver.StageTypecheck, NoPrelude: true})
+	if _, _, err := runner.RunModule(filepath.Join("testdata", "constructors", "main.anma")); err != nil {
+		t.Errorf("expected copatterns of each Some to be exhaustive, got %v", err)
+	}
+	if warnings := runner.Warnings(); len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	t.Parallel()
+
+	_, err := module.Load(filepath.Join("testdata", "missing", "main.anma"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing module, got %v", err)
+	}
+}
+
+// TestMainName checks that the main of the entry module runs even if an imported module also defines main.
+func TestMainName(t *testing.T) {
+	t.Parallel()
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
+	nodes, _, err := runner.RunModule(filepath.Join("testdata", "mains", "main.anma"))
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	name, ok := module.MainName(nodes)
+	if !ok {
+		t.Fatalf("main not found")
+	}
+
+	// The values of the evaluator are in a map, so run it several times to catch a random choice.
+	for range 10 {
+		evaluator := eval.NewEvaluator()
+		var builder strings.Builder
+		evaluator.Stdout = &builder
+		for _, node := range nodes {
+			if _, err := evaluator.Eval(node); err != nil {
+				t.Fatalf("failed to evaluate: %v", err)
+			}
+		}
+		main, ok := evaluator.SearchMain(name)
+		if !ok {
+			t.Fatalf("main of %v not found", name)
+		}
+		top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
+		if _, err := main.Apply(top); err != nil {
+			t.Fatalf("main returned error: %v", err)
+		}
+		if got := builder.String(); got != "\"lib.greet\"\n\"main.main\"\n" {
+			t.Fatalf("expected the main of the entry module to run, got %q", got)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package nameresolve
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// module is the names exported by a module.
+type module struct {
+	names        map[string]int      // Exported name -> unique number.
+	constructors map[string][]string // Exported type name -> its constructors, which are imported with the type.
+}
+
+// initModule registers top-level declarations of the module in a new scope, and records its exports.
+// The scope is inside a scope of imported names, so that top-level declarations shadow imported names.
+// Imported names shadow names defined before the program, such as the prelude.
+func (r *Resolver) initModule(m ast.Module) error {
+	imports := newEnv(r.env)
+	scope := newEnv(imports)
+	saved := r.env
+	r.env = scope
+	defer func() { r.env = saved }()
+
+	if err := r.registerAll(imports, m.Nodes); err != nil {
+		return err
+	}
+	exports, err := r.exports(m)
+	if err != nil {
+		return err
+	}
+	r.modules[m.Decl.Name.Lexeme] = exports
+	r.scopes[m.Decl] = scope
+
+	return nil
+}
+
+// exports returns the names exported by the module, whose scope is the current scope.
+// If the module has no export list, it exports all top-level declarations.
+// An exported type exports its constructors.
+func (r *Resolver) exports(m ast.Module) (*module, error) {
+	constructors := constructorsOf(m.Nodes)
+	if m.Decl.Exports == nil {
+		return &module{names: maps.Clone(r.env.table), constructors: constructors}, nil
+	}
+
+	// Imported names can be exported again.
+	for _, imported := range r.env.parent.modules {
+		for typ, ctors := range imported.constructors {
+			if _, ok := constructors[typ]; !ok && r.env.parent.table[typ] == imported.names[typ] {
+				constructors[typ] = ctors
+			}
+		}
+	}
+
+	exports := &module{names: make(map[string]int), constructors: make(map[string][]string)}
+	for _, name := range m.Decl.Exports {
+		resolved, err := r.env.lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		exports.names[name.Lexeme] = uniqueOf(resolved)
+		if ctors, ok := constructors[name.Lexeme]; ok {
+			exports.constructors[name.Lexeme] = ctors
+			for _, ctor := range ctors {
+				if id, ok := r.env.find(ctor); ok {
+					exports.names[ctor] = id
+				}
+			}
+		}
+	}
+
+	return exports, nil
+}
+
+// uniqueOf returns the unique number of the resolved name.
+func uniqueOf(name token.Token) int {
+	id, ok := name.Literal.(int)
+	if !ok {
+		panic(fmt.Sprintf("unreachable: %v is not resolved", name))
+	}
+
+	return id
+}
+
+// constructorsOf returns constructors of each type declared in the declarations.
+func constructorsOf(nodes []ast.Node) map[string][]string {
+	constructors := make(map[string][]string)
+	for _, node := range nodes {
+		decl, ok := node.(*ast.TypeDecl)
+		if !ok {
+			continue
+		}
+		var ctors []string
+		for _, typ := range decl.Types {
+			if call, ok := typ.(*ast.Call); ok {
+				if ctor, ok := call.Func.(*ast.Var); ok {
+					ctors = append(ctors, ctor.Name.Lexeme)
+				}
+			}
+		}
+		if len(ctors) > 0 {
+			constructors[decl.Def.Base().Lexeme] = ctors
+		}
+	}
+
+	return constructors
+}
+
+// importModule defines names imported by the declaration in the scope.
+// All exported names can be accessed with qualification, like `list.map`.
+func (r *Resolver) importModule(scope *env, decl *ast.ImportDecl) error {
+	imported, ok := r.modules[decl.Name.Lexeme]
+	if !ok {
+		return utils.PosError{Where: decl.Name, Err: NotLoadedError{Name: decl.Name}}
+	}
+	scope.modules[decl.Name.Lexeme] = imported
+
+	var names []string
+	if decl.Names == nil {
+		for name := range imported.names {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+	for _, name := range decl.Names {
+		if _, ok := imported.names[name.Lexeme]; !ok {
+			return utils.PosError{Where: name, Err: NotExportedError{Module: decl.Name, Name: name}}
+		}
+		names = append(names, name.Lexeme)
+		names = append(names, imported.constructors[name.Lexeme]...)
+	}
+
+	for _, name := range names {
+		id := imported.names[name]
+		if previous, ok := scope.table[name]; ok && previous != id {
+			return utils.PosError{Where: decl.Name, Err: ConflictingImportError{Module: decl.Name, Name: name, Previous: r.defs[previous]}}
+		}
+		scope.table[name] = id
+	}
+
+	return nil
+}
+
+// qualified resolves `module.name` to the name exported by the imported module.
+// It reports false if the access is not qualified. A variable of the same name as the module hides the module.
+func (r *Resolver) qualified(access *ast.Access) (ast.Node, bool, error) {
+	receiver, ok := access.Receiver.(*ast.Var)
+	if !ok {
+		return access, false, nil
+	}
+	if _, ok := r.env.find(receiver.Name.Lexeme); ok {
+		return access, false, nil
+	}
+	imported, ok := r.env.module(receiver.Name.Lexeme)
+	if !ok {
+		return access, false, nil
+	}
+	id, ok := imported.names[access.Name.Lexeme]
+	if !ok {
+		return access, true, utils.PosError{Where: access.Name, Err: NotExportedError{Module: receiver.Name, Name: access.Name}}
+	}
+
+	name := access.Name
+	name.Literal = id
+
+	return &ast.Var{Name: name}, true, nil
+}
+
+// open returns a scope inside parent that has the top-level declarations of the module and the names it imports.
+func (r *Resolver) open(decl *ast.ModuleDecl, parent *env) *env {
+	scope := r.scopes[decl]
+	opened := newEnv(parent)
+	maps.Copy(opened.table, scope.parent.table)
+	maps.Copy(opened.modules, scope.parent.modules)
+	maps.Copy(opened.table, scope.table)
+	opened.modules[decl.Name.Lexeme] = r.modules[decl.Name.Lexeme]
+
+	return opened
+}
+
+// find returns the unique number of the name defined in the environment or its parents.
+func (e *env) find(name string) (int, bool) {
+	for ; e != nil; e = e.parent {
+		if id, ok := e.table[name]; ok {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+// module returns the names exported by the module imported in the environment or its parents.
+func (e *env) module(name string) (*module, bool) {
+	for ; e != nil; e = e.parent {
+		if m, ok := e.modules[name]; ok {
+			return m, true
+		}
+	}
+
+	return nil, false
+}
+
+type NotLoadedError struct {
+	Name token.Token
+}
+
+func (e NotLoadedError) Error() string {
+	return fmt.Sprintf("module %s is not loaded", e.Name.Lexeme)
+}
+
+func (NotLoadedError) Code() string {
+	return "E0507"
+}
+
+type NotExportedError struct {
+	Module token.Token
+	Name   token.Token
+}
+
+func (e NotExportedError) Error() string {
+	return fmt.Sprintf("module %s does not export %s", e.Module.Lexeme, e.Name.Lexeme)
+}
+
+func (NotExportedError) Code() string {
+	return "E0508"
+}
+
+type ConflictingImportError struct {
+	Module   token.Token
+	Name     string
+	Previous token.Token // The definition of the name imported before.
+}
+
+func (e ConflictingImportError) Error() string {
+	return fmt.Sprintf("%s imported from %s conflicts with another %s imported before", e.Name, e.Module.Lexeme, e.Name)
+}
+
+func (ConflictingImportError) Code() string {
+	return "E0509"
+}
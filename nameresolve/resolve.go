@@ -6,35 +6,51 @@ package nameresolve
 import (
 	"fmt"
 	"log"
+	"maps"
 
 	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/diag"
 	"github.com/takoeight0821/anma/token"
 	"github.com/takoeight0821/anma/utils"
 )
 
 // Resolver resolves variable names and allocates unique numbers to them.
 type Resolver struct {
-	supply int  // Supply of unique numbers.
-	env    *env // Current environment.
+	// If Shadow is true, each program given to Init has its own top-level scope inside the scope of earlier ones,
+	// so that its declarations shadow earlier declarations of the same names instead of conflicting with them.
+	// The REPL uses it to redefine names.
+	Shadow bool
+
+	supply  int                      // Supply of unique numbers.
+	env     *env                     // Current environment.
+	defs    map[int]token.Token      // Unique number -> defining occurrence.
+	modules map[string]*module       // Module name -> names exported by the module.
+	scopes  map[*ast.ModuleDecl]*env // Module -> its top-level scope.
 }
 
 func NewResolver() *Resolver {
 	return &Resolver{
-		supply: 0,
-		env:    newEnv(nil),
+		Shadow:  false,
+		supply:  0,
+		env:     newEnv(nil),
+		defs:    make(map[int]token.Token),
+		modules: make(map[string]*module),
+		scopes:  make(map[*ast.ModuleDecl]*env),
 	}
 }
 
 // env is an linked list of name->id mappings.
 type env struct {
-	parent *env           // Parent environment.
-	table  map[string]int // Variable name -> unique number.
+	parent  *env               // Parent environment.
+	table   map[string]int     // Variable name -> unique number.
+	modules map[string]*module // Imported module name -> names exported by the module.
 }
 
 func newEnv(parent *env) *env {
 	return &env{
-		parent: parent,
-		table:  make(map[string]int),
+		parent:  parent,
+		table:   make(map[string]int),
+		modules: make(map[string]*module),
 	}
 }
 
@@ -42,10 +58,37 @@ func (r *Resolver) Name() string {
 	return "nameresolve.Resolver"
 }
 
+// Init registers top-level declarations of the program.
+// Each module in the program has its own scope, which has names imported from modules before it.
 func (r *Resolver) Init(program []ast.Node) error {
-	// Register top-level declarations.
-	for _, node := range program {
-		err := r.registerTopLevel(node)
+	if r.Shadow {
+		r.env = newEnv(r.env)
+	}
+
+	nodes, modules := ast.SplitModules(program)
+	for _, m := range modules {
+		if err := r.initModule(m); err != nil {
+			return err
+		}
+	}
+	if r.Shadow && len(modules) > 0 {
+		// The REPL continues in the scope of the last module.
+		r.env = r.open(modules[len(modules)-1].Decl, r.env)
+	}
+
+	return r.registerAll(r.env, nodes)
+}
+
+// registerAll defines top-level declarations and imported names in the current scope.
+// Imported names are defined in imports, which is the current scope or its parent.
+func (r *Resolver) registerAll(imports *env, nodes []ast.Node) error {
+	for _, node := range nodes {
+		var err error
+		if decl, ok := node.(*ast.ImportDecl); ok {
+			err = r.importModule(imports, decl)
+		} else {
+			err = r.registerTopLevel(node)
+		}
 		if err != nil {
 			return err
 		}
@@ -54,8 +97,25 @@ func (r *Resolver) Init(program []ast.Node) error {
 	return nil
 }
 
+// Snapshot returns a function that forgets top-level declarations and modules of programs given to Init after it.
+// Top-level declarations are forgotten only in the Shadow mode, where each program has its own scope.
+// The REPL uses it to drop definitions of an input that fails.
+func (r *Resolver) Snapshot() (restore func()) {
+	env, modules, scopes := r.env, maps.Clone(r.modules), maps.Clone(r.scopes)
+
+	return func() {
+		r.env, r.modules, r.scopes = env, modules, scopes
+	}
+}
+
 func (r *Resolver) Run(program []ast.Node) ([]ast.Node, error) {
+	env := r.env
+	defer func() { r.env = env }()
+
 	for i, node := range program {
+		if decl, ok := node.(*ast.ModuleDecl); ok {
+			r.env = r.scopes[decl]
+		}
 		var err error
 		program[i], err = r.solve(node)
 		if err != nil {
@@ -69,9 +129,25 @@ func (r *Resolver) Run(program []ast.Node) ([]ast.Node, error) {
 // define a variable in the current scope.
 func (r *Resolver) define(name token.Token) {
 	r.env.table[name.Lexeme] = r.supply
+	r.defs[r.supply] = name
 	r.supply++
 }
 
+// Definition returns the token that defines the variable with the unique number.
+// The unique number is the Literal of a resolved token.
+func (r *Resolver) Definition(id int) (token.Token, bool) {
+	name, ok := r.defs[id]
+
+	return name, ok
+}
+
+// alreadyDefined returns an error that name is already defined in the current scope.
+func (r *Resolver) alreadyDefined(where, name token.Token) error {
+	previous := r.defs[r.env.table[name.Lexeme]]
+
+	return utils.PosError{Where: where, Err: AlreadyDefinedError{Name: name, Previous: previous}}
+}
+
 type NotDefinedError struct {
 	Name token.Token
 }
@@ -80,6 +156,10 @@ func (e NotDefinedError) Error() string {
 	return e.Name.String() + " is not defined"
 }
 
+func (NotDefinedError) Code() string {
+	return "E0501"
+}
+
 func (e *env) lookup(name token.Token) (token.Token, error) {
 	if uniq, ok := e.table[name.Lexeme]; ok {
 		return token.Token{Kind: name.Kind, Lexeme: name.Lexeme, Location: name.Location, Literal: uniq}, nil
@@ -108,7 +188,7 @@ func (r *Resolver) registerTopLevel(node ast.Node) error {
 		}
 	case *ast.VarDecl:
 		if _, ok := r.env.table[node.Name.Lexeme]; ok {
-			return utils.PosError{Where: node.Base(), Err: AlreadyDefinedError{Name: node.Name}}
+			return r.alreadyDefined(node.Base(), node.Name)
 		}
 		r.define(node.Name)
 	}
@@ -128,6 +208,10 @@ func (e NotClauseError) Error() string {
 	return fmt.Sprintf("%v is not a clause", e.Node)
 }
 
+func (NotClauseError) Code() string {
+	return "E0502"
+}
+
 type NotFieldError struct {
 	Node ast.Node
 }
@@ -136,6 +220,10 @@ func (e NotFieldError) Error() string {
 	return fmt.Sprintf("%v is not a field", e.Node)
 }
 
+func (NotFieldError) Code() string {
+	return "E0503"
+}
+
 // solve all variables in the node.
 func (r *Resolver) solve(node ast.Node) (ast.Node, error) {
 	switch node := node.(type) {
@@ -162,6 +250,9 @@ func (r *Resolver) solve(node ast.Node) (ast.Node, error) {
 
 		return node, nil
 	case *ast.Access:
+		if name, ok, err := r.qualified(node); ok {
+			return name, err
+		}
 		var err error
 		node.Receiver, err = r.solve(node.Receiver)
 
@@ -192,9 +283,12 @@ func (r *Resolver) solve(node ast.Node) (ast.Node, error) {
 		return node, nil
 	case *ast.Binary:
 		var err error
-		node.Op, err = r.env.lookup(node.Op)
-		if err != nil {
-			return node, err
+		// `->` in types is not a variable.
+		if node.Op.Kind != token.ARROW {
+			node.Op, err = r.env.lookup(node.Op)
+			if err != nil {
+				return node, err
+			}
 		}
 		node.Left, err = r.solve(node.Left)
 		if err != nil {
@@ -212,7 +306,7 @@ func (r *Resolver) solve(node ast.Node) (ast.Node, error) {
 		if err != nil {
 			return node, err
 		}
-		node.Type, err = r.solve(node.Type)
+		node.Type, err = r.solveType(node.Type)
 		if err != nil {
 			return node, err
 		}
@@ -378,7 +472,7 @@ func (r *Resolver) solve(node ast.Node) (ast.Node, error) {
 			return node, err
 		}
 		if node.Type != nil {
-			node.Type, err = r.solve(node.Type)
+			node.Type, err = r.solveType(node.Type)
 			if err != nil {
 				return node, err
 			}
@@ -398,6 +492,23 @@ func (r *Resolver) solve(node ast.Node) (ast.Node, error) {
 			return node, err
 		}
 
+		return node, nil
+	case *ast.ModuleDecl:
+		var err error
+		for i, name := range node.Exports {
+			node.Exports[i], err = r.env.lookup(name)
+			if err != nil {
+				return node, err
+			}
+		}
+
+		return node, nil
+	case *ast.ImportDecl:
+		exports := r.modules[node.Name.Lexeme]
+		for i, name := range node.Names {
+			node.Names[i].Literal = exports.names[name.Lexeme]
+		}
+
 		return node, nil
 	case *ast.This:
 		return node, nil
@@ -408,16 +519,42 @@ func (r *Resolver) solve(node ast.Node) (ast.Node, error) {
 	}
 }
 
+// solveType solves all variables in the type of a signature or an assertion.
+// Undefined names in the type are implicitly bound as type variables.
+func (r *Resolver) solveType(typ ast.Node) (ast.Node, error) {
+	r.env = newEnv(r.env)
+	defer func() { r.env = r.env.parent }()
+
+	_, err := r.assign(typ, ifNotDefined)
+	if err != nil {
+		return typ, err
+	}
+
+	return r.solve(typ)
+}
+
 type mode func(*Resolver, ast.Node) ([]string, error)
 
 type AlreadyDefinedError struct {
-	Name token.Token
+	Name     token.Token
+	Previous token.Token // The previous definition.
 }
 
 func (e AlreadyDefinedError) Error() string {
 	return e.Name.String() + " is already defined"
 }
 
+func (AlreadyDefinedError) Code() string {
+	return "E0504"
+}
+
+func (e AlreadyDefinedError) Spans() []diag.Span {
+	span := diag.SpanOf(e.Previous)
+	span.Label = "previously defined here"
+
+	return []diag.Span{span}
+}
+
 // allVariables define all variables in the node.
 // If a variable is already defined in current scope, it is an error.
 func allVariables(resolver *Resolver, node ast.Node) ([]string, error) {
@@ -429,7 +566,7 @@ func allVariables(resolver *Resolver, node ast.Node) ([]string, error) {
 		switch node := node.(type) {
 		case *ast.Var:
 			if _, ok := resolver.env.table[node.Name.Lexeme]; ok {
-				return node, utils.PosError{Where: node.Base(), Err: AlreadyDefinedError{Name: node.Name}}
+				return node, resolver.alreadyDefined(node.Base(), node.Name)
 			}
 			resolver.define(node.Name)
 			defined = append(defined, node.Name.Lexeme)
@@ -499,13 +636,17 @@ func (e InvalidPatternError) Error() string {
 	return fmt.Sprintf("invalid pattern %v", e.Pattern)
 }
 
+func (InvalidPatternError) Code() string {
+	return "E0505"
+}
+
 // Define variables in the node as pattern.
 // If a variable appears as a function, it is ignored.
 func asPattern(resolver *Resolver, pattern ast.Node) ([]string, error) {
 	switch pattern := pattern.(type) {
 	case *ast.Var:
 		if _, ok := resolver.env.table[pattern.Name.Lexeme]; ok {
-			return nil, utils.PosError{Where: pattern.Base(), Err: AlreadyDefinedError{Name: pattern.Name}}
+			return nil, resolver.alreadyDefined(pattern.Base(), pattern.Name)
 		}
 		resolver.define(pattern.Name)
 
@@ -583,6 +724,10 @@ func (e InvalidTypeError) Error() string {
 	return fmt.Sprintf("invalid type %v", e.Type)
 }
 
+func (InvalidTypeError) Code() string {
+	return "E0506"
+}
+
 // assign defines variables in the node.
 // The mode function determines which variables are defined.
 // Returns a list of defined variables.
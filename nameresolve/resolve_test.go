@@ -8,10 +8,7 @@ import (
 
 	"github.com/sebdah/goldie/v2"
 	"github.com/takoeight0821/anma/codata"
-	"github.com/takoeight0821/anma/desugarwith"
 	"github.com/takoeight0821/anma/driver"
-	"github.com/takoeight0821/anma/infix"
-	"github.com/takoeight0821/anma/nameresolve"
 	"github.com/takoeight0821/anma/utils"
 )
 
@@ -33,11 +30,7 @@ func TestGolden(t *testing.T) {
 			return
 		}
 
-		runner := driver.NewPassRunner()
-		runner.AddPass(&desugarwith.DesugarWith{})
-		runner.AddPass(&codata.Flat{})
-		runner.AddPass(infix.NewInfixResolver())
-		runner.AddPass(nameresolve.NewResolver())
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
 
 		nodes, err := runner.RunSource(testfile, string(source))
 		if err != nil {
@@ -56,3 +49,39 @@ func TestGolden(t *testing.T) {
 		g.Assert(t, filepath.Base(testfile), []byte(builder.String()))
 	}
 }
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	inputs := []string{
+		"def f = 1",
+		"def g = f",
+		"def f = { #(x) -> f }",
+		"type T = { A(), B() }",
+		"type T = { A(), C() } def h = { #(A()) -> g }",
+	}
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, Session: true, NoPrelude: true})
+
+	var builder strings.Builder
+	for _, input := range inputs {
+		nodes, err := runner.RunSource("session", input)
+		if err != nil {
+			t.Errorf("%s returned error: %v", input, err)
+
+			return
+		}
+		for _, node := range nodes {
+			builder.WriteString(node.String())
+			builder.WriteString("\n")
+		}
+	}
+
+	// Declarations in the same input still conflict.
+	if _, err := runner.RunSource("session", "def f = 1 def f = 2"); err == nil {
+		t.Errorf("redefinition in the same input is not an error")
+	}
+
+	g := goldie.New(t)
+	g.Assert(t, "session", []byte(builder.String()))
+}
@@ -3,9 +3,10 @@ package parser
 import (
 	"errors"
 	"fmt"
-	"os"
+	"math/big"
 
 	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/diag"
 	"github.com/takoeight0821/anma/token"
 	"github.com/takoeight0821/anma/utils"
 )
@@ -13,33 +14,67 @@ import (
 //go:generate go run ../tools/main.go -comment -in parser.go -out ../docs/syntax.ebnf
 
 type Parser struct {
-	tokens  []token.Token
-	current int
+	tokens   []token.Token
+	current  int
+	warnings []utils.Warning
+	errors   []error // Errors that the parser has recovered from.
 }
 
 func NewParser(tokens []token.Token) *Parser {
-	return &Parser{tokens, 0}
+	return &Parser{tokens, 0, nil, nil}
 }
 
+// Warnings returns warnings found while parsing.
+//
+//tool:ignore
+func (p *Parser) Warnings() []utils.Warning {
+	return p.warnings
+}
+
+// ParseExpr parses an expression that spans all tokens.
+//
+//tool:ignore
 func (p *Parser) ParseExpr() (ast.Node, error) {
-	return p.expr()
+	expr, err := p.expr()
+	if err == nil && !p.IsAtEnd() {
+		err = unexpectedToken(p.peek(), "end of input")
+	}
+	if err != nil {
+		return nil, errors.Join(append(p.errors, err)...)
+	}
+
+	return expr, errors.Join(p.errors...)
 }
 
+// ParseDecl parses all declarations.
+// On a syntax error, it skips to the next declaration or clause and continues parsing.
+// It returns the program with [ast.Bad] in place of skipped parts, and all errors joined.
+//
+//tool:ignore
 func (p *Parser) ParseDecl() ([]ast.Node, error) {
 	nodes := []ast.Node{}
 	for !p.IsAtEnd() {
+		start := p.current
 		node, err := p.decl()
 		if err != nil {
-			return nil, err
+			p.report(err)
+			node = &ast.Bad{Where: p.tokens[start]}
+			p.skipDecl(start, err)
 		}
 		nodes = append(nodes, node)
 	}
 
-	return nodes, nil
+	return nodes, errors.Join(p.errors...)
 }
 
-// decl = typeDecl | varDecl | infixDecl ;
+// decl = moduleDecl | importDecl | typeDecl | varDecl | infixDecl ;
 func (p *Parser) decl() (ast.Node, error) {
+	if p.match(token.MODULE) {
+		return p.moduleDecl()
+	}
+	if p.match(token.IMPORT) {
+		return p.importDecl()
+	}
 	if p.match(token.TYPE) {
 		return p.typeDecl()
 	}
@@ -50,6 +85,65 @@ func (p *Parser) decl() (ast.Node, error) {
 	return p.infixDecl()
 }
 
+// moduleDecl = "module" IDENT names? ;
+func (p *Parser) moduleDecl() (*ast.ModuleDecl, error) {
+	if _, err := p.consume(token.MODULE); err != nil {
+		return nil, err
+	}
+	name, err := p.consume(token.IDENT)
+	if err != nil {
+		return nil, err
+	}
+	exports, err := p.names()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.ModuleDecl{Name: name, Exports: exports}, nil
+}
+
+// importDecl = "import" IDENT names? ;
+func (p *Parser) importDecl() (*ast.ImportDecl, error) {
+	if _, err := p.consume(token.IMPORT); err != nil {
+		return nil, err
+	}
+	name, err := p.consume(token.IDENT)
+	if err != nil {
+		return nil, err
+	}
+	names, err := p.names()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.ImportDecl{Name: name, Names: names}, nil
+}
+
+// names = "(" ((IDENT | OPERATOR) ("," (IDENT | OPERATOR))* ","?)? ")" ;
+func (p *Parser) names() ([]token.Token, error) {
+	if !p.match(token.LEFTPAREN) {
+		// All names.
+		return nil, nil
+	}
+	p.advance()
+	names := []token.Token{}
+	for !p.match(token.RIGHTPAREN) {
+		if !p.match(token.IDENT) && !p.match(token.OPERATOR) {
+			return nil, unexpectedToken(p.peek(), "identifier", "operator")
+		}
+		names = append(names, p.advance())
+		if !p.match(token.COMMA) {
+			break
+		}
+		p.advance()
+	}
+	if _, err := p.consume(token.RIGHTPAREN); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
 // typeDecl = "type" IDENT (typeparams1)? "=" typebody ;
 // typeparams1 = "(" IDENT ("," IDENT)* ","? ")" ;
 // typebody = "{" constructor ("," constructor)* ","? "}" | type ;
@@ -215,6 +309,9 @@ func (p *Parser) infixDecl() (*ast.InfixDecl, error) {
 	if err != nil {
 		return nil, err
 	}
+	if literal, ok := precedence.Literal.(*big.Int); !ok || !literal.IsInt64() || int64(int(literal.Int64())) != literal.Int64() {
+		return nil, utils.PosError{Where: precedence, Err: PrecedenceRangeError{Prec: precedence}}
+	}
 	name, err := p.consume(token.OPERATOR)
 	if err != nil {
 		return nil, err
@@ -299,8 +396,7 @@ func (p *Parser) with() (*ast.With, error) {
 	}
 
 	if _, ok := expr.(*ast.Call); !ok {
-		fmt.Fprintf(os.Stderr, "at %v: `%s`, warning: `with` expression should be a function call\n",
-			expr.Base().Location, expr.Base().Lexeme)
+		p.warnings = append(p.warnings, utils.Warning{Where: expr.Base(), Err: WithNotCallError{With: expr}})
 	}
 
 	return &ast.With{Binds: patterns, Body: expr}, nil
@@ -308,7 +404,7 @@ func (p *Parser) with() (*ast.With, error) {
 
 // atom = var | literal | paren | tuple | codata | PRIM "(" IDENT ("," expr)* ","? ")" ;
 // var = IDENT ;
-// literal = INTEGER | STRING ;
+// literal = INTEGER | FLOAT | STRING ;
 // paren = "(" ")" | "(" expr ")" ;
 // tuple = "[" "]" | "[" expr ("," expr)* ","? "]" ;
 // codata = "{" clause ("," clause)* ","? "}" ;
@@ -317,7 +413,7 @@ func (p *Parser) atom() (ast.Node, error) {
 	switch tok := p.advance(); tok.Kind {
 	case token.IDENT:
 		return &ast.Var{Name: tok}, nil
-	case token.INTEGER, token.STRING:
+	case token.INTEGER, token.FLOAT, token.STRING:
 		return &ast.Literal{Token: tok}, nil
 	case token.LEFTPAREN:
 		expr, err := p.expr()
@@ -384,7 +480,7 @@ func (p *Parser) atom() (ast.Node, error) {
 
 		return &ast.Prim{Name: name, Args: args}, nil
 	default:
-		return nil, unexpectedToken(tok, "identifier", "integer", "string", "`(`", "`{`")
+		return nil, unexpectedToken(tok, "identifier", "integer", "float", "string", "`(`", "`{`")
 	}
 }
 
@@ -501,21 +597,32 @@ func (p *Parser) callTail(fun ast.Node) (ast.Node, error) {
 
 // codata = "{" clause ("," clause)* ","? "}" ;
 func (p *Parser) codata() (*ast.Codata, error) {
-	clause, err := p.clause()
-	if err != nil {
-		return nil, err
-	}
-	clauses := []*ast.CodataClause{clause}
-	for p.match(token.COMMA) {
-		p.advance()
-		if p.match(token.RIGHTBRACE) {
-			break
-		}
+	open := p.previous()
+	clauses := []*ast.CodataClause{}
+	for !p.IsAtEnd() && !p.atDecl() {
+		start := p.current
 		clause, err := p.clause()
 		if err != nil {
-			return nil, err
+			p.skipClause(start, err)
+			bad := &ast.Bad{Where: p.tokens[start]}
+			clause = &ast.CodataClause{Pattern: bad, Expr: bad}
+		} else if !p.match(token.COMMA) && !p.match(token.RIGHTBRACE) && !p.IsAtEnd() && !p.atDecl() {
+			p.skipClause(p.current, unexpectedToken(p.peek(), "`,`", "`}`"))
 		}
 		clauses = append(clauses, clause)
+		if !p.match(token.COMMA) {
+			break
+		}
+		p.advance()
+		if p.match(token.RIGHTBRACE) {
+			break
+		}
+	}
+	if p.IsAtEnd() || p.atDecl() {
+		// Close the codata here, and continue with the next declaration.
+		p.report(utils.PosError{Where: p.peek(), Err: UnclosedError{Open: open, Close: "}"}})
+
+		return &ast.Codata{Clauses: clauses}, nil
 	}
 	if _, err := p.consume(token.RIGHTBRACE); err != nil {
 		return nil, err
@@ -698,7 +805,7 @@ func (p *Parser) callPatTail(fun ast.Node) (ast.Node, error) {
 	return &ast.Call{Func: fun, Args: args}, nil
 }
 
-// atomPat = IDENT | INTEGER | STRING | "(" pattern ")" | tuplePat ;
+// atomPat = IDENT | INTEGER | FLOAT | STRING | "(" pattern ")" | tuplePat ;
 // tuplePat = "[" "]" | "[" pattern ("," pattern)* ","? "]" ;
 func (p *Parser) atomPat() (ast.Node, error) {
 	//exhaustive:ignore
@@ -707,7 +814,7 @@ func (p *Parser) atomPat() (ast.Node, error) {
 		return &ast.This{Token: tok}, nil
 	case token.IDENT:
 		return &ast.Var{Name: tok}, nil
-	case token.INTEGER, token.STRING:
+	case token.INTEGER, token.FLOAT, token.STRING:
 		return &ast.Literal{Token: tok}, nil
 	case token.LEFTPAREN:
 		pat, err := p.pattern()
@@ -745,7 +852,7 @@ func (p *Parser) atomPat() (ast.Node, error) {
 
 		return &ast.Tuple{Exprs: pats}, nil
 	default:
-		return nil, unexpectedToken(tok, "identifier", "integer", "string", "`(`")
+		return nil, unexpectedToken(tok, "identifier", "integer", "float", "string", "`(`")
 	}
 }
 
@@ -854,7 +961,7 @@ func (p *Parser) callTypeTail(fun ast.Node) (*ast.Call, error) {
 	return &ast.Call{Func: fun, Args: args}, nil
 }
 
-// atomType = IDENT | "{" fieldType ("," fieldType)* ","? "}" | "(" type  ")" | tupleType ;
+// atomType = IDENT | "{" fieldType ("," fieldType)* ","? "}" | parenType | tupleType ;
 // tupleType = "[" "]" | "[" type ("," type)* ","? "]";
 func (p *Parser) atomType() (ast.Node, error) {
 	//exhaustive:ignore
@@ -909,18 +1016,47 @@ func (p *Parser) atomType() (ast.Node, error) {
 
 		return &ast.Tuple{Exprs: types}, nil
 	case token.LEFTPAREN:
+		return p.parenType(tok)
+	default:
+		return nil, unexpectedToken(tok, "identifier", "`{`", "`(`")
+	}
+}
+
+// parenType = "(" ")" | "(" type ("," type)* ","? ")" ;
+func (p *Parser) parenType(open token.Token) (ast.Node, error) {
+	// `(type)` is a parenthesized type.
+	// Otherwise, it is a parameter list of a function type such as `(Int, Int) -> Int`.
+	types := []ast.Node{}
+	trailingComma := false
+	if !p.match(token.RIGHTPAREN) {
 		typ, err := p.typ()
 		if err != nil {
 			return nil, err
 		}
-		if _, err := p.consume(token.RIGHTPAREN); err != nil {
-			return nil, err
+		types = append(types, typ)
+		for p.match(token.COMMA) {
+			p.advance()
+			trailingComma = true
+			if p.match(token.RIGHTPAREN) {
+				break
+			}
+			trailingComma = false
+			typ, err := p.typ()
+			if err != nil {
+				return nil, err
+			}
+			types = append(types, typ)
 		}
+	}
+	if _, err := p.consume(token.RIGHTPAREN); err != nil {
+		return nil, err
+	}
 
-		return &ast.Paren{Expr: typ}, nil
-	default:
-		return nil, unexpectedToken(tok, "identifier", "`{`", "`(`")
+	if len(types) == 1 && !trailingComma {
+		return &ast.Paren{Expr: types[0]}, nil
 	}
+
+	return &ast.Call{Func: &ast.This{Token: open}, Args: types}, nil
 }
 
 // fieldType = IDENT ":" type ;
@@ -993,6 +1129,101 @@ func (p *Parser) consume(kind token.Kind) (token.Token, error) {
 	return p.peek(), unexpectedToken(p.peek(), kind.String())
 }
 
+// report records an error that the parser recovers from.
+//
+//tool:ignore
+func (p *Parser) report(err error) {
+	p.errors = append(p.errors, err)
+}
+
+// atDecl reports whether the next token begins a declaration.
+//
+//tool:ignore
+func (p Parser) atDecl() bool {
+	//exhaustive:ignore
+	switch p.peek().Kind {
+	case token.DEF, token.TYPE, token.INFIX, token.INFIXL, token.INFIXR, token.MODULE, token.IMPORT:
+		return true
+	default:
+		return false
+	}
+}
+
+// rewind goes back to the token where err occurred if it is consumed after start.
+// The token is the first one to be skipped.
+//
+//tool:ignore
+func (p *Parser) rewind(start int, err error) {
+	var posErr utils.PosError
+	if !errors.As(err, &posErr) {
+		return
+	}
+	for i := p.current; i >= start; i-- {
+		if p.tokens[i].Location == posErr.Where.Location {
+			p.current = i
+
+			return
+		}
+	}
+}
+
+// skipDecl skips the rest of the broken declaration that starts at start.
+//
+//tool:ignore
+func (p *Parser) skipDecl(start int, err error) {
+	p.rewind(start, err)
+	if p.current == start {
+		p.advance()
+	}
+	for !p.IsAtEnd() && !p.atDecl() {
+		p.advance()
+	}
+}
+
+// skipClause skips the rest of the broken clause that starts at start,
+// to the next `,` or `}` at the same depth, or to the next declaration, and reports err.
+//
+//tool:ignore
+func (p *Parser) skipClause(start int, err error) {
+	p.rewind(start, err)
+
+	depth := 0
+	for _, t := range p.tokens[start:p.current] {
+		depth += nesting(t.Kind)
+	}
+
+	errAt := p.current
+	for !p.IsAtEnd() && !p.atDecl() {
+		kind := p.peek().Kind
+		if depth <= 0 && (kind == token.COMMA || kind == token.RIGHTBRACE) {
+			break
+		}
+		// A closing bracket without the opening one is skipped.
+		depth = max(depth+nesting(kind), 0)
+		p.advance()
+	}
+
+	// If the clause is broken just because the codata is not closed, [UnclosedError] is reported instead.
+	if p.current != errAt || !(p.IsAtEnd() || p.atDecl()) {
+		p.report(err)
+	}
+}
+
+// nesting returns 1 for an opening bracket, -1 for a closing bracket, and 0 for others.
+//
+//tool:ignore
+func nesting(kind token.Kind) int {
+	//exhaustive:ignore
+	switch kind {
+	case token.LEFTPAREN, token.LEFTBRACE, token.LEFTBRACKET:
+		return 1
+	case token.RIGHTPAREN, token.RIGHTBRACE, token.RIGHTBRACKET:
+		return -1
+	default:
+		return 0
+	}
+}
+
 type UnexpectedTokenError struct {
 	Expected []string
 }
@@ -1010,16 +1241,77 @@ func (e UnexpectedTokenError) Error() string {
 	return "unexpected token: expected " + msg
 }
 
+func (UnexpectedTokenError) Code() string {
+	return "E0201"
+}
+
+// WithNotCallError is a warning that is reported when the body of `with` is not a function call.
+//
+//tool:ignore
+type WithNotCallError struct {
+	With ast.Node
+}
+
+func (e WithNotCallError) Error() string {
+	return "`with` expression should be a function call"
+}
+
+func (WithNotCallError) Code() string {
+	return "W0201"
+}
+
+// UnclosedError is an error that is reported when a bracket is not closed before the next declaration or the end of file.
+//
+//tool:ignore
+type UnclosedError struct {
+	Open  token.Token
+	Close string
+}
+
+func (e UnclosedError) Error() string {
+	return fmt.Sprintf("unclosed `%s`: expected `%s`", e.Open.Lexeme, e.Close)
+}
+
+func (UnclosedError) Code() string {
+	return "E0202"
+}
+
+func (e UnclosedError) Spans() []diag.Span {
+	span := diag.SpanOf(e.Open)
+	span.Label = "unclosed delimiter"
+
+	return []diag.Span{span}
+}
+
+// PrecedenceRangeError is an error that is reported when the precedence of an infix declaration does not fit in an int.
+//
+//tool:ignore
+type PrecedenceRangeError struct {
+	Prec token.Token
+}
+
+func (e PrecedenceRangeError) Error() string {
+	return fmt.Sprintf("precedence %s is out of range", e.Prec.Lexeme)
+}
+
+func (PrecedenceRangeError) Code() string {
+	return "E0203"
+}
+
 func unexpectedToken(t token.Token, expected ...string) error {
 	return utils.PosError{Where: t, Err: UnexpectedTokenError{Expected: expected}}
 }
 
 func try[T any](p *Parser, action func() (T, error), handler func() (T, error)) (T, error) {
 	savedCurrent := p.current
+	savedWarnings := len(p.warnings)
+	savedErrors := len(p.errors)
 
 	node, err := action()
 	if err != nil {
 		p.current = savedCurrent
+		p.warnings = p.warnings[:savedWarnings]
+		p.errors = p.errors[:savedErrors]
 
 		node, rerr := handler()
 		if rerr != nil {
@@ -8,6 +8,8 @@ import (
 
 	"github.com/sebdah/goldie/v2"
 	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/parser"
 	"github.com/takoeight0821/anma/utils"
 )
 
@@ -48,3 +50,49 @@ func TestGolden(t *testing.T) {
 		g.Assert(t, filepath.Base(testfile), []byte(builder.String()))
 	}
 }
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := filepath.Glob("../testdata/errors/*.error")
+	if err != nil {
+		t.Errorf("failed to find test files: %v", err)
+
+		return
+	}
+
+	for _, testfile := range testfiles {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", testfile, err)
+
+			return
+		}
+
+		tokens, err := lexer.Lex(testfile, string(source))
+		if err != nil {
+			t.Errorf("%s returned error: %v", testfile, err)
+
+			return
+		}
+
+		// The parser recovers from errors and returns the partial program.
+		nodes, err := parser.NewParser(tokens).ParseDecl()
+		if err == nil {
+			t.Errorf("%s must return error", testfile)
+
+			return
+		}
+
+		var builder strings.Builder
+		for _, node := range nodes {
+			builder.WriteString(node.String())
+			builder.WriteString("\n")
+		}
+		builder.WriteString(err.Error())
+		builder.WriteString("\n")
+
+		g := goldie.New(t)
+		g.Assert(t, filepath.Base(testfile), []byte(builder.String()))
+	}
+}
@@ -0,0 +1,37 @@
+// Package prelude provides the standard prelude, which is written in Anma and embedded in the binary.
+//
+// [driver.NewStandardRunner] loads it before the first program unless [driver.Options] opts out.
+// The prelude is the module `prelude`, so that programs can refer to shadowed names like `prelude.map`.
+package prelude
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/parser"
+)
+
+// Path is the file path of the prelude in locations of its tokens.
+const Path = "prelude.anma"
+
+// Source is the source code of the prelude.
+//
+//go:embed prelude.anma
+var Source string
+
+// Parse parses the prelude.
+func Parse() ([]ast.Node, error) {
+	tokens, err := lexer.Lex(Path, Source)
+	if err != nil {
+		return nil, fmt.Errorf("lex: %w", err)
+	}
+
+	nodes, err := parser.NewParser(tokens).ParseDecl()
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	return nodes, nil
+}
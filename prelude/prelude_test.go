@@ -0,0 +1,80 @@
+package prelude_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/module"
+	"github.com/takoeight0821/anma/prelude"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// TestPrelude checks that the prelude passes all passes without warnings.
+func TestPrelude(t *testing.T) {
+	t.Parallel()
+
+	nodes, err := prelude.Parse()
+	if err != nil {
+		t.Fatalf("failed to parse the prelude: %v", err)
+	}
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckError, Until: driver.StageTypecheck, NoPrelude: true})
+	if _, err := runner.Run(nodes); err != nil {
+		t.Errorf("the prelude returned error: %v", err)
+	}
+	if warnings := runner.Warnings(); len(warnings) != 0 {
+		t.Errorf("the prelude has warnings: %v", warnings)
+	}
+}
+
+// TestGolden runs programs that use the prelude.
+func TestGolden(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := utils.FindSourceFiles("testdata")
+	if err != nil {
+		t.Fatalf("failed to find test files: %v", err)
+	}
+
+	for _, testfile := range testfiles {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", testfile, err)
+		}
+
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageTypecheck})
+		nodes, err := runner.RunSource(testfile, string(source))
+		if err != nil {
+			t.Errorf("%s returned error: %v", testfile, err)
+
+			continue
+		}
+
+		evaluator := eval.NewEvaluator()
+		var builder strings.Builder
+		evaluator.Stdout = &builder
+		for _, node := range nodes {
+			if _, err := evaluator.Eval(node); err != nil {
+				t.Fatalf("%s returned error: %v", testfile, err)
+			}
+		}
+		name, _ := module.MainName(nodes)
+		main, ok := evaluator.SearchMain(name)
+		if !ok {
+			t.Fatalf("%s does not have a main function", testfile)
+		}
+		top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
+		if _, err := main.Apply(top); err != nil {
+			t.Errorf("%s returned error: %v", testfile, err)
+		}
+
+		g := goldie.New(t)
+		g.Assert(t, filepath.Base(testfile), []byte(builder.String()))
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrg/xdg"
+	"github.com/peterh/liner"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/token"
+)
+
+func replCommand(args []string) int {
+	flags := newFlagSet("repl")
+	var exhaustive string
+	var noPrelude bool
+	flags.StringVar(&exhaustive, "exhaustive", "warn", exhaustiveUsage)
+	flags.BoolVar(&noPrelude, "no-prelude", false, noPreludeUsage)
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	mode, err := parseCheckMode(exhaustive)
+	if err != nil {
+		return usageError(flags, err)
+	}
+
+	if err := RunPrompt(mode, noPrelude); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return exitError
+	}
+
+	return exitOK
+}
+
+func historyPath() string {
+	return filepath.Join(xdg.DataHome, "anma", ".anma_history")
+}
+
+// writeHistory writes the history of the REPL to a file.
+func writeHistory(line *liner.State) {
+	// Create the directory for the history file if it does not exist.
+	if err := os.MkdirAll(filepath.Dir(historyPath()), os.ModePerm); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	// Write the history file.
+	// If the file does not exist, it will be created automatically.
+	// If the file exists, it will be overwritten.
+	if f, err := os.Create(historyPath()); err == nil {
+		defer f.Close()
+		if _, err := line.WriteHistory(f); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	line.Close()
+}
+
+// readHistory reads the history of the REPL from a file.
+func readHistory(line *liner.State) {
+	if f, err := os.Open(historyPath()); err == nil {
+		defer f.Close()
+		if _, err := line.ReadHistory(f); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// RunPrompt runs the REPL until the end of input.
+// Unless noPrelude, the prelude is loaded before the first input.
+func RunPrompt(exhaustive codata.CheckMode, noPrelude bool) error {
+	line := liner.NewLiner()
+	defer writeHistory(line)
+	readHistory(line)
+
+	session := newSession(os.Stdout, exhaustive, noPrelude)
+	line.SetCtrlCAborts(true)
+	line.SetCompleter(session.complete)
+
+	for {
+		input, err := readInput(line)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, liner.ErrPromptAborted) {
+			// Ctrl-C discards the input.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("prompt: %w", err)
+		}
+
+		err = session.input(input)
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// readInput reads lines until all brackets in them are closed.
+// Each line is added to the history.
+func readInput(line *liner.State) (string, error) {
+	var lines []string
+	prompt := "> "
+	for {
+		text, err := line.Prompt(prompt)
+		if err != nil {
+			return "", err
+		}
+		line.AppendHistory(text)
+		lines = append(lines, text)
+
+		input := strings.Join(lines, "\n")
+		if !unclosed(input) {
+			return input, nil
+		}
+		prompt = "| "
+	}
+}
+
+// unclosed reports whether the input has brackets, a string or a block comment that are not closed yet.
+// If the input has another lexical error, it is considered closed, so that the error is reported.
+func unclosed(input string) bool {
+	tokens, err := lexer.Lex(replPath, input)
+	if err != nil {
+		var str lexer.UnterminatedStringError
+		var comment lexer.UnterminatedCommentError
+
+		return errors.As(err, &str) || errors.As(err, &comment)
+	}
+
+	depth := 0
+	for _, t := range tokens {
+		//exhaustive:ignore
+		switch t.Kind {
+		case token.LEFTPAREN, token.LEFTBRACE, token.LEFTBRACKET:
+			depth++
+		case token.RIGHTPAREN, token.RIGHTBRACE, token.RIGHTBRACKET:
+			depth--
+		}
+	}
+
+	return depth > 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/takoeight0821/anma/codata"
+)
+
+func TestUnclosed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"def f = { #(x) -> x }", false},
+		{"def f = {", true},
+		{"def f = {\n  #(x) -> (x", true},
+		{"def f = {\n  #(x) -> (x)\n}", false},
+		{"def f = { #(x) -> x }}", false},
+		{`def s = "{"`, false},
+		{`def s = "abc`, true},
+		{"def s = `raw", true},
+		{"def f = { // }", true},
+		{"def f = /* { */ 1", false},
+		{"def f = /* outer /* inner */", true},
+		{"def f = /* outer /* inner */ */ 1", false},
+		// Other lexical errors are reported instead of waiting for more lines.
+		{`def s = "\q" + {`, false},
+	}
+	for _, test := range tests {
+		if actual := unclosed(test.input); actual != test.expected {
+			t.Errorf("unclosed(%q): expected %v, got %v", test.input, test.expected, actual)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	t.Parallel()
+
+	s := newSession(io.Discard, codata.CheckWarn, true)
+	if err := s.input("def fibonacci = 1"); err != nil {
+		t.Fatalf("failed to define fibonacci: %v", err)
+	}
+
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"fib", []string{"fibonacci"}},
+		{"1 + fib", []string{"1 + fibonacci"}},
+		{"ty", []string{"type"}},
+		{"x1", nil},
+		{"prim(pri", []string{"prim(print", "prim(print_cps"}},
+		{"prim( su", []string{"prim( sub", "prim( substring"}},
+		{"f(pri", []string{"f(prim"}},
+		{":re", []string{":reload"}},
+		{":", []string{":load", ":reload", ":ast", ":env", ":type", ":time", ":help", ":quit"}},
+		{":type fib", []string{":type fibonacci"}},
+	}
+	for _, test := range tests {
+		if actual := s.complete(test.line); !slices.Equal(actual, test.expected) {
+			t.Errorf("complete(%q): expected %q, got %q", test.line, test.expected, actual)
+		}
+	}
+}
+
+func TestSplitWord(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		line, head, word string
+	}{
+		{"", "", ""},
+		{"abc", "", "abc"},
+		{"f(x_1", "f(", "x_1"},
+		{"1 + 23", "1 + 23", ""},
+		{"x.äb", "x.", "äb"},
+		{"1abc", "1", "abc"},
+	}
+	for _, test := range tests {
+		if head, word := splitWord(test.line); head != test.head || word != test.word {
+			t.Errorf("splitWord(%q): expected (%q, %q), got (%q, %q)", test.line, test.head, test.word, head, word)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/module"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/vm"
+)
+
+// backend is a way to run programs.
+type backend int
+
+const (
+	backendEval backend = iota
+	backendVM
+)
+
+func parseBackend(name string) (backend, error) {
+	switch name {
+	case "eval":
+		return backendEval, nil
+	case "vm":
+		return backendVM, nil
+	default:
+		return backendEval, invalidFlagError{Name: "backend", Value: name}
+	}
+}
+
+// runFlags are flags of commands that run programs.
+type runFlags struct {
+	passFlags
+	backend string
+	options runOptions
+}
+
+func (f *runFlags) register(flags *flag.FlagSet) {
+	const (
+		backendUsage = "how to run the input file: eval (tree-walking interpreter) or vm (bytecode)"
+		fuelUsage    = "maximum number of function calls and field evaluations (0 for no limit)"
+		depthUsage   = "maximum depth of non-tail calls (0 for no limit)"
+		timeoutUsage = "maximum running time, such as 10s (0 for no limit)"
+	)
+	f.passFlags.register(flags)
+	flags.StringVar(&f.backend, "backend", "eval", backendUsage)
+	flags.IntVar(&f.options.limits.Fuel, "fuel", 0, fuelUsage)
+	flags.IntVar(&f.options.limits.Depth, "max-depth", 0, depthUsage)
+	flags.DurationVar(&f.options.timeout, "timeout", 0, timeoutUsage)
+}
+
+func (f runFlags) parse() (runOptions, error) {
+	options := f.options
+	options.noPrelude = f.noPrelude
+	var err error
+	options.exhaustive, options.format, err = f.passFlags.parse()
+	if err != nil {
+		return options, err
+	}
+	options.backend, err = parseBackend(f.backend)
+
+	return options, err
+}
+
+func runCommand(args []string) int {
+	flags := newFlagSet("run")
+	var runFlags runFlags
+	runFlags.register(flags)
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	options, err := runFlags.parse()
+	if err != nil {
+		return usageError(flags, err)
+	}
+	if flags.NArg() != 1 {
+		return usageError(flags, errors.New("expected one file"))
+	}
+	options.stdout = os.Stdout
+	options.stdin = os.Stdin
+
+	err = RunFile(flags.Arg(0), options)
+	var exitErr eval.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return exitError
+	}
+
+	return exitOK
+}
+
+func checkCommand(args []string) int {
+	flags := newFlagSet("check")
+	var passFlags passFlags
+	passFlags.register(flags)
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	exhaustive, format, err := passFlags.parse()
+	if err != nil {
+		return usageError(flags, err)
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags, errors.New("expected files"))
+	}
+
+	status := exitOK
+	for _, path := range flags.Args() {
+		runner := driver.NewStandardRunner(driver.Options{
+			Exhaustive: exhaustive, Until: driver.StageTypecheck, NoPrelude: passFlags.noPrelude,
+		})
+		_, sources, err := runner.RunModule(path)
+		report := reporter{format: format, sources: sources}
+		report.warnings(runner.Warnings())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, report.error(err))
+			status = exitError
+		}
+	}
+
+	return status
+}
+
+// runOptions configures [RunFile].
+type runOptions struct {
+	exhaustive codata.CheckMode
+	backend    backend
+	limits     eval.Limits
+	timeout    time.Duration // Zero means no timeout.
+	format     diagnosticsFormat
+	noPrelude  bool
+	stdout     io.Writer
+	stdin      io.Reader
+}
+
+// RunFile runs the main function of the specified file with the modules it imports.
+// If the program exits by `prim(exit)`, it returns [eval.ExitError].
+func RunFile(path string, options runOptions) error {
+	runner := driver.NewStandardRunner(driver.Options{
+		Exhaustive: options.exhaustive, Until: driver.StageTypecheck, NoPrelude: options.noPrelude,
+	})
+	nodes, sources, err := runner.RunModule(path)
+	report := reporter{format: options.format, sources: sources}
+	// Warnings do not abort the execution.
+	report.warnings(runner.Warnings())
+	if err != nil {
+		return report.error(fmt.Errorf("run file: %w", err))
+	}
+
+	ctx := context.Background()
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
+	}
+
+	evaluator, main, err := loadMain(ctx, nodes, options)
+	if err != nil {
+		return report.error(err)
+	}
+	// top is a dummy token.
+	top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
+	_, err = evaluator.ApplyContext(ctx, main, top)
+	var exitErr eval.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr
+	} else if err != nil {
+		return report.error(fmt.Errorf("run file: %w", err))
+	}
+
+	return nil
+}
+
+// loadMain loads definitions and returns the main function with the evaluator that runs it.
+func loadMain(ctx context.Context, nodes []ast.Node, options runOptions) (*eval.Evaluator, eval.Callable, error) {
+	name, ok := module.MainName(nodes)
+	if !ok {
+		return nil, nil, noMainError{}
+	}
+	switch options.backend {
+	case backendVM:
+		program, err := vm.Compile(nodes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("run file: %w", err)
+		}
+		machine := vm.NewVM(program)
+		machine.Stdout = options.stdout
+		machine.Stdin = options.stdin
+		machine.SetLimits(options.limits)
+		if err := machine.RunContext(ctx); err != nil {
+			return nil, nil, fmt.Errorf("run file: %w", err)
+		}
+		main, ok := machine.SearchMain(name)
+		if !ok {
+			return nil, nil, noMainError{}
+		}
+
+		return machine.Evaluator, main, nil
+	case backendEval:
+		evaluator := eval.NewEvaluator()
+		evaluator.Stdout = options.stdout
+		evaluator.Stdin = options.stdin
+		evaluator.SetLimits(options.limits)
+		// Evaluate all nodes for loading definitions.
+		for _, node := range nodes {
+			_, err := evaluator.EvalContext(ctx, node)
+			if err != nil {
+				return nil, nil, fmt.Errorf("run file: %w", err)
+			}
+		}
+
+		main, ok := evaluator.SearchMain(name)
+		if !ok {
+			return nil, nil, noMainError{}
+		}
+
+		return evaluator, main, nil
+	}
+
+	panic(fmt.Sprintf("unreachable: backend %d", options.backend))
+}
+
+type noMainError struct{}
+
+func (noMainError) Error() string {
+	return "no main function"
+}
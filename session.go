@@ -0,0 +1,499 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/diag"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/lexer"
+	"github.com/takoeight0821/anma/module"
+	"github.com/takoeight0821/anma/nameresolve"
+	"github.com/takoeight0821/anma/parser"
+	"github.com/takoeight0821/anma/prelude"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/typecheck"
+)
+
+// replPath is the file path of inputs in the REPL.
+const replPath = "repl"
+
+// session is the state of the REPL.
+// Definitions of earlier inputs are visible in later inputs, and later definitions shadow earlier ones.
+type session struct {
+	stdout     io.Writer // Values, outputs of programs and meta-commands are printed to it.
+	exhaustive codata.CheckMode
+	noPrelude  bool
+	files      []string // Files loaded by `:load`, in order.
+	runner     *driver.PassRunner
+	resolver   *nameresolve.Resolver // The resolver in runner.
+	checker    *typecheck.Checker    // The checker in runner.
+	evaluator  *eval.Evaluator
+}
+
+func newSession(stdout io.Writer, exhaustive codata.CheckMode, noPrelude bool) *session {
+	runner := driver.NewStandardRunner(driver.Options{
+		Exhaustive: exhaustive, Until: driver.StageTypecheck, Session: true, NoPrelude: noPrelude,
+	})
+	var resolver *nameresolve.Resolver
+	var checker *typecheck.Checker
+	for _, pass := range runner.Passes() {
+		switch pass := pass.(type) {
+		case *nameresolve.Resolver:
+			resolver = pass
+		case *typecheck.Checker:
+			checker = pass
+		}
+	}
+
+	evaluator := eval.NewEvaluator()
+	evaluator.Stdout = stdout
+	s := &session{
+		stdout:     stdout,
+		exhaustive: exhaustive,
+		noPrelude:  noPrelude,
+		files:      nil,
+		runner:     runner,
+		resolver:   resolver,
+		checker:    checker,
+		evaluator:  evaluator,
+	}
+	// The prelude is loaded before the first input, so that a failed input does not drop it.
+	report := reporter{format: formatText, sources: diag.Sources{prelude.Path: prelude.Source}}
+	if _, err := s.define(report, nil); err != nil {
+		panic(fmt.Sprintf("unreachable: prelude: %v", err))
+	}
+
+	return s
+}
+
+// input runs a line of the REPL: a meta-command such as `:load file.anma`, declarations or an expression.
+// It returns [errQuit] on `:quit`.
+func (s *session) input(input string) error {
+	input = strings.TrimSpace(input)
+	if rest, ok := strings.CutPrefix(input, ":"); ok {
+		name, arg, _ := strings.Cut(rest, " ")
+
+		return s.command(name, strings.TrimSpace(arg))
+	}
+
+	return s.run(input)
+}
+
+// run runs declarations or an expression.
+// The value of an expression is printed and bound to `it`.
+func (s *session) run(input string) error {
+	report := reporter{format: formatText, sources: diag.Sources{replPath: input}}
+
+	tokens, err := lexer.Lex(replPath, input)
+	if err != nil {
+		return report.error(fmt.Errorf("lex: %w", err))
+	}
+	if tokens[0].Kind == token.EOF {
+		return nil
+	}
+	if !isDeclKeyword(tokens[0].Kind) {
+		expr, err := parseExpr(report, tokens)
+		if err != nil {
+			return err
+		}
+
+		return s.runExpr(report, expr)
+	}
+
+	p := parser.NewParser(tokens)
+	program, err := p.ParseDecl()
+	report.warnings(p.Warnings())
+	if err != nil {
+		return report.error(fmt.Errorf("parse: %w", err))
+	}
+
+	_, err = s.define(report, program)
+
+	return err
+}
+
+// runExpr evaluates an expression, prints the value and binds it to `it`.
+// The expression is defined under a hidden name before `it`, because `it` in the expression refers to the previous value.
+func (s *session) runExpr(report reporter, expr ast.Node) error {
+	hidden := hiddenDecl(expr)
+	it := token.Token{Kind: token.IDENT, Lexeme: "it", Location: hidden.Name.Location, Literal: nil}
+	var resolved token.Token
+	for _, decl := range []*ast.VarDecl{hidden, {Name: it, Type: nil, Expr: &ast.Var{Name: hidden.Name}}} {
+		var err error
+		if resolved, err = s.define(report, []ast.Node{decl}); err != nil {
+			return err
+		}
+	}
+
+	value, err := s.evaluator.Eval(&ast.Var{Name: resolved})
+	if err != nil {
+		return report.error(err)
+	}
+	fmt.Fprintln(s.stdout, value)
+
+	return nil
+}
+
+// define runs the passes on declarations and evaluates them.
+// It returns the resolved name of the last variable declaration.
+// If it fails, the declarations are not visible in later inputs.
+func (s *session) define(report reporter, program []ast.Node) (token.Token, error) {
+	restore := s.snapshot()
+	nodes, err := s.runner.Run(program)
+	report.warnings(s.runner.Warnings())
+	if err != nil {
+		restore()
+
+		return token.Token{}, report.error(err)
+	}
+
+	var name token.Token
+	for _, node := range nodes {
+		if _, err := s.evaluator.Eval(node); err != nil {
+			restore()
+
+			return token.Token{}, report.error(err)
+		}
+		if decl, ok := node.(*ast.VarDecl); ok {
+			name = decl.Name
+		}
+	}
+
+	return name, nil
+}
+
+// snapshot returns a function that restores the state of the passes and the evaluator.
+func (s *session) snapshot() (restore func()) {
+	passes, evaluator := s.runner.Snapshot(), s.evaluator.Snapshot()
+
+	return func() {
+		passes()
+		evaluator()
+	}
+}
+
+// hiddenDecl returns a definition of the expression, whose name cannot be written in the source.
+func hiddenDecl(expr ast.Node) *ast.VarDecl {
+	name := token.Token{Kind: token.IDENT, Lexeme: ":it", Location: expr.Base().Location, Literal: nil}
+
+	return &ast.VarDecl{Name: name, Type: nil, Expr: expr}
+}
+
+// parseExpr parses the tokens as an expression.
+func parseExpr(report reporter, tokens []token.Token) (ast.Node, error) {
+	p := parser.NewParser(tokens)
+	expr, err := p.ParseExpr()
+	report.warnings(p.Warnings())
+	if err != nil {
+		return nil, report.error(fmt.Errorf("parse: %w", err))
+	}
+
+	return expr, nil
+}
+
+// isDeclKeyword reports whether a declaration starts with the keyword.
+func isDeclKeyword(kind token.Kind) bool {
+	//exhaustive:ignore
+	switch kind {
+	case token.TYPE, token.DEF, token.INFIX, token.INFIXL, token.INFIXR, token.MODULE, token.IMPORT:
+		return true
+	default:
+		return false
+	}
+}
+
+// errQuit is returned by `:quit`.
+var errQuit = errors.New("quit")
+
+// metaCommand is a command of the REPL that starts with a colon, such as `:load file.anma`.
+type metaCommand struct {
+	name    string
+	arg     string // The usage of the argument.
+	summary string
+	run     func(s *session, arg string) error
+}
+
+func metaCommands() []metaCommand {
+	return []metaCommand{
+		{"load", "file", "load definitions of a file", (*session).load},
+		{"reload", "", "clear the session and load the files again", (*session).reload},
+		{"ast", "expr", "print the expression after each pass", (*session).printAST},
+		{"env", "", "list variables defined in the session", (*session).printEnv},
+		{"type", "expr", "print the type of the expression", (*session).printType},
+		{"time", "input", "run the input and print the time it takes", (*session).timeInput},
+		{"help", "", "list commands", (*session).help},
+		{"quit", "", "exit the REPL", (*session).quit},
+	}
+}
+
+// command runs the meta-command whose name starts with name, such as `:t` for `:type`.
+func (s *session) command(name, arg string) error {
+	if name != "" {
+		for _, cmd := range metaCommands() {
+			if strings.HasPrefix(cmd.name, name) {
+				return cmd.run(s, arg)
+			}
+		}
+	}
+
+	return unknownCommandError{Name: name}
+}
+
+type unknownCommandError struct {
+	Name string
+}
+
+func (e unknownCommandError) Error() string {
+	return fmt.Sprintf("unknown command :%s (:help for commands)", e.Name)
+}
+
+// load loads definitions of the file and the modules it imports.
+// Later inputs are in the scope of the file.
+func (s *session) load(path string) error {
+	if path == "" {
+		return errors.New(":load expects a file")
+	}
+	program, err := module.Load(path)
+	report := reporter{format: formatText, sources: program.Sources}
+	report.warnings(program.Warnings)
+	if err != nil {
+		return report.error(err)
+	}
+	if _, err := s.define(report, program.Nodes); err != nil {
+		return err
+	}
+
+	if !slices.Contains(s.files, path) {
+		s.files = append(s.files, path)
+	}
+	fmt.Fprintf(s.stdout, "loaded %s\n", path)
+
+	return nil
+}
+
+func (s *session) reload(string) error {
+	files := s.files
+	*s = *newSession(s.stdout, s.exhaustive, s.noPrelude)
+	var errs []error
+	for _, path := range files {
+		errs = append(errs, s.load(path))
+	}
+
+	return errors.Join(errs...)
+}
+
+// printAST prints the expression after parsing and after each pass.
+// The expression is not defined in the session.
+func (s *session) printAST(input string) error {
+	report := reporter{format: formatText, sources: diag.Sources{replPath: input}}
+	expr, err := s.expr(report, input)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.stdout, "%-12s %v\n", driver.StageParse, expr)
+	restore := s.runner.Snapshot()
+	defer restore()
+	stage := driver.StageParse
+	_, err = s.runner.RunTrace([]ast.Node{hiddenDecl(expr)}, func(pass driver.Pass, program []ast.Node) {
+		if _, ok := pass.(*codata.Coverage); ok {
+			// Coverage checks the program of the resolve stage without changing it.
+			return
+		}
+		stage++
+		if decl, ok := program[0].(*ast.VarDecl); ok {
+			fmt.Fprintf(s.stdout, "%-12s %v\n", stage, decl.Expr)
+		}
+	})
+	report.warnings(s.runner.Warnings())
+	if err != nil {
+		return report.error(err)
+	}
+
+	return nil
+}
+
+// printEnv prints variables defined in the session that are not shadowed, with their types and values.
+// Definitions of the prelude are omitted.
+func (s *session) printEnv(string) error {
+	for _, binding := range s.bindings() {
+		if def, ok := s.resolver.Definition(binding.id); ok && def.Location.FilePath == prelude.Path {
+			continue
+		}
+		var typ string
+		name := token.Token{Kind: token.IDENT, Lexeme: binding.name, Location: token.Location{}, Literal: binding.id}
+		if scheme, ok := s.checker.TypeOf(name); ok {
+			typ = " : " + scheme.String()
+		}
+		fmt.Fprintf(s.stdout, "%s%s = %v\n", binding.name, typ, binding.value)
+	}
+
+	return nil
+}
+
+// binding is a variable defined in the session.
+type binding struct {
+	name  string
+	id    int // The unique number given by [nameresolve.Resolver].
+	value eval.Value
+}
+
+// bindings returns variables defined in the session that are not shadowed, sorted by name.
+func (s *session) bindings() []binding {
+	// Later definitions have larger unique numbers.
+	latest := make(map[string]binding)
+	for _, b := range s.evaluator.Bindings() {
+		dot := strings.LastIndex(string(b.Name), ".")
+		name := string(b.Name[:dot])
+		id, err := strconv.Atoi(string(b.Name[dot+1:]))
+		if err != nil || strings.HasPrefix(name, ":") {
+			continue
+		}
+		if previous, ok := latest[name]; !ok || id > previous.id {
+			latest[name] = binding{name: name, id: id, value: b.Value}
+		}
+	}
+
+	bindings := make([]binding, 0, len(latest))
+	for _, b := range latest {
+		bindings = append(bindings, b)
+	}
+	slices.SortFunc(bindings, func(x, y binding) int { return strings.Compare(x.name, y.name) })
+
+	return bindings
+}
+
+// printType prints the type of the expression without evaluating it.
+func (s *session) printType(input string) error {
+	report := reporter{format: formatText, sources: diag.Sources{replPath: input}}
+	expr, err := s.expr(report, input)
+	if err != nil {
+		return err
+	}
+
+	restore := s.runner.Snapshot()
+	defer restore()
+	nodes, err := s.runner.Run([]ast.Node{hiddenDecl(expr)})
+	report.warnings(s.runner.Warnings())
+	if err != nil {
+		return report.error(err)
+	}
+	decl, ok := nodes[0].(*ast.VarDecl)
+	if !ok {
+		panic(fmt.Sprintf("unreachable: %v", nodes[0]))
+	}
+	if scheme, ok := s.checker.TypeOf(decl.Name); ok {
+		fmt.Fprintf(s.stdout, "%s : %v\n", input, scheme)
+	}
+
+	return nil
+}
+
+func (s *session) timeInput(input string) error {
+	start := time.Now()
+	err := s.run(input)
+	fmt.Fprintf(s.stdout, "(%v)\n", time.Since(start).Round(time.Microsecond))
+
+	return err
+}
+
+func (s *session) help(string) error {
+	for _, cmd := range metaCommands() {
+		fmt.Fprintf(s.stdout, "  %-14s %s\n", strings.TrimSpace(":"+cmd.name+" "+cmd.arg), cmd.summary)
+	}
+
+	return nil
+}
+
+func (s *session) quit(string) error {
+	return errQuit
+}
+
+// expr parses the input of a meta-command as an expression.
+func (s *session) expr(report reporter, input string) (ast.Node, error) {
+	tokens, err := lexer.Lex(replPath, input)
+	if err != nil {
+		return nil, report.error(fmt.Errorf("lex: %w", err))
+	}
+
+	return parseExpr(report, tokens)
+}
+
+// complete returns the line with completions of the word at the end for tab completion.
+// Words are meta-commands at the start of the line, primitives in `prim(...)`, keywords and variables in the session.
+func (s *session) complete(line string) []string {
+	head, word := splitWord(line)
+	var candidates []string
+	switch {
+	case strings.HasPrefix(line, ":") && !strings.Contains(line, " "):
+		head, word = "", line
+		for _, cmd := range metaCommands() {
+			candidates = append(candidates, ":"+cmd.name)
+		}
+	case isPrimArg(head):
+		candidates = s.evaluator.PrimNames()
+	default:
+		for _, keyword := range lexer.Keywords() {
+			if _, w := splitWord(keyword); w == keyword {
+				candidates = append(candidates, keyword)
+			}
+		}
+		for _, binding := range s.bindings() {
+			candidates = append(candidates, binding.name)
+		}
+		slices.Sort(candidates)
+	}
+
+	var completions []string
+	for _, candidate := range slices.Compact(candidates) {
+		if strings.HasPrefix(candidate, word) {
+			completions = append(completions, head+candidate)
+		}
+	}
+
+	return completions
+}
+
+// splitWord splits the line into the identifier at the end and the text before it.
+func splitWord(line string) (string, string) {
+	start := len(line)
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(line[:start])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			break
+		}
+		start -= size
+	}
+	// An identifier does not start with a digit.
+	for start < len(line) {
+		r, size := utf8.DecodeRuneInString(line[start:])
+		if !unicode.IsDigit(r) {
+			break
+		}
+		start += size
+	}
+
+	return line[:start], line[start:]
+}
+
+// isPrimArg reports whether the text is followed by the name of a primitive, like `prim(`.
+func isPrimArg(text string) bool {
+	text, ok := strings.CutSuffix(strings.TrimRight(text, " "), "(")
+	if !ok {
+		return false
+	}
+	_, word := splitWord(strings.TrimRight(text, " "))
+
+	return word == "prim"
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/eval"
+)
+
+// TestSessionRedefine checks that an input with a type error leaves nothing in the session,
+// so that a redefinition in the next input sees only earlier inputs.
+func TestSessionRedefine(t *testing.T) {
+	t.Parallel()
+
+	s := newSession(io.Discard, codata.CheckWarn, false)
+	// The fixity and the type would change how later inputs are read and checked.
+	failed := "infixr 6 -\ntype Bool = { False(), True() }\ndef sub = 1 - \"a\""
+	if err := s.input(failed); err == nil {
+		t.Fatalf("expected a type error in %q", failed)
+	}
+	for _, input := range []string{
+		"def sub = 10 - 2 - 3",
+		"def yes = { #(True()) -> 1, #(False()) -> 0 }(sub == 5)",
+	} {
+		if err := s.input(input); err != nil {
+			t.Fatalf("%q returned error: %v", input, err)
+		}
+	}
+
+	for name, expected := range map[string]string{"sub": "5", "yes": "1"} {
+		if value, ok := lookup(s, name); !ok || value.String() != expected {
+			t.Errorf("expected %s = %s, got %v", name, expected, value)
+		}
+	}
+}
+
+// lookup returns the value of the variable in the session.
+func lookup(s *session, name string) (eval.Value, bool) {
+	for _, binding := range s.bindings() {
+		if binding.name == name {
+			return binding.value, true
+		}
+	}
+
+	return nil, false
+}
+
+// TestSessionCommands runs inputs in a new session, and checks what they print.
+// A line starting with "!" is the first line of an error returned by an input.
+func TestSessionCommands(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		inputs   []string
+		expected string
+	}{
+		{
+			name:     "expression",
+			inputs:   []string{"1 + 2", "it * 2"},
+			expected: "3\n6\n",
+		},
+		{
+			name:     "type",
+			inputs:   []string{"def id = { #(x) -> x }", ":type id", ":t id(1) == 1"},
+			expected: "id : a -> a\nid(1) == 1 : Bool\n",
+		},
+		{
+			name:     "type error",
+			inputs:   []string{`:type 1 + "a"`, ":env"},
+			expected: "! error[E0601]: type mismatch: expected `(Int, Int) -> Int`, actual `(Int, String) -> a`\n",
+		},
+		{
+			name:   "ast",
+			inputs: []string{":ast 1 + 2 * 3"},
+			expected: "parse        (binary (binary (literal 1) + (literal 2)) * (literal 3))\n" +
+				"desugarwith  (binary (binary (literal 1) + (literal 2)) * (literal 3))\n" +
+				"flat         (binary (binary (literal 1) + (literal 2)) * (literal 3))\n" +
+				"infix        (binary (literal 1) + (binary (literal 2) * (literal 3)))\n" +
+				"resolve      (binary (literal 1) +.13 (binary (literal 2) *.15 (literal 3)))\n" +
+				"typecheck    (binary (literal 1) +.13 (binary (literal 2) *.15 (literal 3)))\n",
+		},
+		{
+			name:     "env",
+			inputs:   []string{"def x = 1", "def x = 2", "def y = x + 1", ":env"},
+			expected: "x : Int = 2\ny : Int = 3\n",
+		},
+		{
+			name:   "failed definition",
+			inputs: []string{"def x = 1", `def x = "a" + 1`, ":env", "x"},
+			expected: "! error[E0601]: type mismatch: expected `(String, String) -> String`, actual `(String, Int) -> a`\n" +
+				"x : Int = 1\n1\n",
+		},
+		{
+			name:     "runtime error",
+			inputs:   []string{"def x = 1", "def x = 1 / 0", "x"},
+			expected: "! error[E0711]: division by zero\n1\n",
+		},
+		{
+			name:     "time",
+			inputs:   []string{":time 1 + 1", ":time 1 +"},
+			expected: "2\n(time)\n(time)\n! error[E0201]: unexpected token: expected identifier, integer, float, string, `(`, `{`\n",
+		},
+		{
+			name:   "help",
+			inputs: []string{":help"},
+			expected: "  :load file     load definitions of a file\n" +
+				"  :reload        clear the session and load the files again\n" +
+				"  :ast expr      print the expression after each pass\n" +
+				"  :env           list variables defined in the session\n" +
+				"  :type expr     print the type of the expression\n" +
+				"  :time input    run the input and print the time it takes\n" +
+				"  :help          list commands\n" +
+				"  :quit          exit the REPL\n",
+		},
+		{
+			name:   "unknown command",
+			inputs: []string{":frobnicate", ":", ":load"},
+			expected: "! unknown command :frobnicate (:help for commands)\n" +
+				"! unknown command : (:help for commands)\n" +
+				"! :load expects a file\n",
+		},
+		{
+			name:     "quit",
+			inputs:   []string{":quit", ":q"},
+			expected: "quit\nquit\n",
+		},
+	}
+	for _, test := range tests {
+		var stdout bytes.Buffer
+		s := newSession(&stdout, codata.CheckWarn, false)
+		for _, input := range test.inputs {
+			record(&stdout, s.input(input))
+		}
+		if actual := durations.ReplaceAllString(stdout.String(), "(time)"); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+// durations matches the times printed by `:time`.
+var durations = regexp.MustCompile(`\([0-9.]+[nµm]?s\)`)
+
+// record writes the first line of err after "!", or "quit" if err is [errQuit].
+func record(w io.Writer, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, errQuit):
+		fmt.Fprintln(w, "quit")
+	default:
+		line, _, _ := strings.Cut(err.Error(), "\n")
+		fmt.Fprintln(w, "!", line)
+	}
+}
+
+// TestSessionReload checks that `:reload` drops definitions of inputs and loads the changed files again.
+func TestSessionReload(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "lib.anma")
+	write := func(source string) {
+		if err := os.WriteFile(path, []byte(source), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	s := newSession(&stdout, codata.CheckWarn, false)
+	write("def x = 1")
+	for _, input := range []string{":load " + path, "def y = x + 1", "y"} {
+		record(&stdout, s.input(input))
+	}
+	write("def x = 10\ndef z = x * 2")
+	for _, input := range []string{"x", ":reload", "x", "z", "y"} {
+		record(&stdout, s.input(input))
+	}
+	// The session is cleared even if the file fails to load.
+	write(`def x = "a" + 1`)
+	for _, input := range []string{":reload", "x"} {
+		record(&stdout, s.input(input))
+	}
+
+	expected := fmt.Sprintf("loaded %[1]s\n2\n1\nloaded %[1]s\n10\n20\n! error[E0501]: y is not defined\n", path)
+	expected += "! error[E0601]: type mismatch: expected `(String, String) -> String`, actual `(String, Int) -> a`\n"
+	expected += "! error[E0501]: x is not defined\n"
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// testCommand runs the main function of each file.
+// A file passes if it runs without errors, and prints the content of its .out file if any.
+// Files without main are skipped.
+func testCommand(args []string) int {
+	flags := newFlagSet("test")
+	var runFlags runFlags
+	runFlags.register(flags)
+	update := flags.Bool("update", false, "write the output of each file to its .out file")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	options, err := runFlags.parse()
+	if err != nil {
+		return usageError(flags, err)
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+			return exitError
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+
+			continue
+		}
+		found, err := utils.FindSourceFiles(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+			return exitError
+		}
+		files = append(files, found...)
+	}
+
+	passed, failed := 0, 0
+	for _, file := range files {
+		var stdout bytes.Buffer
+		options.stdout = &stdout
+		options.stdin = strings.NewReader("")
+		err := RunFile(file, options)
+		var exitErr eval.ExitError
+		if errors.As(err, &exitErr) && exitErr.Code == 0 {
+			err = nil
+		}
+		if errors.As(err, &noMainError{}) {
+			fmt.Printf("skip %s (no main function)\n", file)
+
+			continue
+		}
+		if err == nil {
+			err = compareOutput(file+".out", stdout.String(), *update)
+		}
+		if err != nil {
+			fmt.Printf("FAIL %s\n%v\n", file, err)
+			failed++
+
+			continue
+		}
+		fmt.Printf("ok   %s\n", file)
+		passed++
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return exitError
+	}
+
+	return exitOK
+}
+
+// compareOutput compares the output with the expected output in the file, if it exists.
+// If update is true, it writes the output to the file instead.
+func compareOutput(path, output string, update bool) error {
+	if update {
+		if err := os.WriteFile(path, []byte(output), 0o644); err != nil { //nolint:gosec // Same as a new source file.
+			return fmt.Errorf("update output: %w", err)
+		}
+
+		return nil
+	}
+
+	expected, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read output: %w", err)
+	}
+	if string(expected) != output {
+		return unexpectedOutputError{Diff: utils.Diff(string(expected), output)}
+	}
+
+	return nil
+}
+
+type unexpectedOutputError struct {
+	Diff string
+}
+
+func (e unexpectedOutputError) Error() string {
+	return "unexpected output:\n" + e.Diff
+}
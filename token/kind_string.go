@@ -23,26 +23,30 @@ func _() {
 	_ = x[IDENT-12]
 	_ = x[OPERATOR-13]
 	_ = x[INTEGER-14]
-	_ = x[STRING-15]
-	_ = x[ARROW-16]
-	_ = x[BACKARROW-17]
-	_ = x[BAR-18]
-	_ = x[CASE-19]
-	_ = x[DEF-20]
-	_ = x[EQUAL-21]
-	_ = x[FN-22]
-	_ = x[INFIX-23]
-	_ = x[INFIXL-24]
-	_ = x[INFIXR-25]
-	_ = x[LET-26]
-	_ = x[PRIM-27]
-	_ = x[TYPE-28]
-	_ = x[WITH-29]
+	_ = x[FLOAT-15]
+	_ = x[STRING-16]
+	_ = x[ARROW-17]
+	_ = x[BACKARROW-18]
+	_ = x[BAR-19]
+	_ = x[CASE-20]
+	_ = x[DEF-21]
+	_ = x[EQUAL-22]
+	_ = x[FN-23]
+	_ = x[IMPORT-24]
+	_ = x[INFIX-25]
+	_ = x[INFIXL-26]
+	_ = x[INFIXR-27]
+	_ = x[LET-28]
+	_ = x[MODULE-29]
+	_ = x[PRIM-30]
+	_ = x[TYPE-31]
+	_ = x[WITH-32]
+	_ = x[COMMENT-33]
 }
 
-const _Kind_name = "EOFLEFTPARENRIGHTPARENLEFTBRACERIGHTBRACELEFTBRACKETRIGHTBRACKETCOLONCOMMADOTSEMICOLONSHARPIDENTOPERATORINTEGERSTRINGARROWBACKARROWBARCASEDEFEQUALFNINFIXINFIXLINFIXRLETPRIMTYPEWITH"
+const _Kind_name = "EOFLEFTPARENRIGHTPARENLEFTBRACERIGHTBRACELEFTBRACKETRIGHTBRACKETCOLONCOMMADOTSEMICOLONSHARPIDENTOPERATORINTEGERFLOATSTRINGARROWBACKARROWBARCASEDEFEQUALFNIMPORTINFIXINFIXLINFIXRLETMODULEPRIMTYPEWITHCOMMENT"
 
-var _Kind_index = [...]uint8{0, 3, 12, 22, 31, 41, 52, 64, 69, 74, 77, 86, 91, 96, 104, 111, 117, 122, 131, 134, 138, 141, 146, 148, 153, 159, 165, 168, 172, 176, 180}
+var _Kind_index = [...]uint8{0, 3, 12, 22, 31, 41, 52, 64, 69, 74, 77, 86, 91, 96, 104, 111, 116, 122, 127, 136, 139, 143, 146, 151, 153, 159, 164, 170, 176, 179, 185, 189, 193, 197, 204}
 
 func (i Kind) String() string {
 	if i < 0 || i >= Kind(len(_Kind_index)-1) {
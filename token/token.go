@@ -25,6 +25,7 @@ const (
 	IDENT
 	OPERATOR
 	INTEGER
+	FLOAT
 	STRING
 
 	// Keywords.
@@ -35,13 +36,18 @@ const (
 	DEF
 	EQUAL
 	FN
+	IMPORT
 	INFIX
 	INFIXL
 	INFIXR
 	LET
+	MODULE
 	PRIM
 	TYPE
 	WITH
+
+	// Trivia. Only produced when comments are kept.
+	COMMENT
 )
 
 type Token struct {
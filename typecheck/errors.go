@@ -0,0 +1,157 @@
+package typecheck
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/takoeight0821/anma/ast"
+)
+
+// TypeMismatchError is an error that is returned when two types cannot be unified.
+// Types are rendered when the error occurs, because unification mutates them afterwards.
+type TypeMismatchError struct {
+	Expected string
+	Actual   string
+	Detail   string
+}
+
+func (e TypeMismatchError) Error() string {
+	msg := fmt.Sprintf("type mismatch: expected `%s`, actual `%s`", e.Expected, e.Actual)
+	if e.Detail != "" {
+		msg += "\n\t" + e.Detail
+	}
+
+	return msg
+}
+
+func (TypeMismatchError) Code() string {
+	return "E0601"
+}
+
+// NotInScopeError is an error that is returned when a variable has no type.
+type NotInScopeError struct {
+	Name string
+}
+
+func (e NotInScopeError) Error() string {
+	return fmt.Sprintf("`%s` is not in scope", e.Name)
+}
+
+func (NotInScopeError) Code() string {
+	return "E0602"
+}
+
+// TypeArityError is an error that is returned when a type constructor is applied to a wrong number of arguments.
+type TypeArityError struct {
+	Name     string
+	Expected int
+	Actual   int
+}
+
+func (e TypeArityError) Error() string {
+	return fmt.Sprintf("type `%s` expects %s, actual %s",
+		e.Name, plural(e.Expected, "argument"), plural(e.Actual, "argument"))
+}
+
+func (TypeArityError) Code() string {
+	return "E0603"
+}
+
+// InvalidTypeError is an error that is returned when a node cannot be interpreted as a type.
+type InvalidTypeError struct {
+	Type ast.Node
+}
+
+func (e InvalidTypeError) Error() string {
+	return fmt.Sprintf("invalid type %v", e.Type)
+}
+
+func (InvalidTypeError) Code() string {
+	return "E0604"
+}
+
+// NotConstructorError is an error that is returned when a pattern calls a non-constructor.
+type NotConstructorError struct {
+	Node ast.Node
+}
+
+func (e NotConstructorError) Error() string {
+	return fmt.Sprintf("not a constructor: %v", e.Node)
+}
+
+func (NotConstructorError) Code() string {
+	return "E0605"
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+
+	return strconv.Itoa(n) + " " + word + "s"
+}
+
+// InvalidExpressionError is an error that is returned when a node cannot be typed as an expression.
+type InvalidExpressionError struct {
+	Node ast.Node
+}
+
+func (e InvalidExpressionError) Error() string {
+	return fmt.Sprintf("invalid expression %v", e.Node)
+}
+
+func (InvalidExpressionError) Code() string {
+	return "E0606"
+}
+
+// InvalidPatternError is an error that is returned when a node cannot be typed as a pattern.
+type InvalidPatternError struct {
+	Pattern ast.Node
+}
+
+func (e InvalidPatternError) Error() string {
+	return fmt.Sprintf("invalid pattern %v", e.Pattern)
+}
+
+func (InvalidPatternError) Code() string {
+	return "E0607"
+}
+
+// NotNumberError is an error that is returned when an arithmetic primitive is applied to a non-number.
+type NotNumberError struct {
+	Type string
+}
+
+func (e NotNumberError) Error() string {
+	return fmt.Sprintf("`%s` is not a number", e.Type)
+}
+
+func (NotNumberError) Code() string {
+	return "E0608"
+}
+
+// NotOrderedError is an error that is returned when a comparison primitive is applied to values that cannot be ordered.
+type NotOrderedError struct {
+	Type string
+}
+
+func (e NotOrderedError) Error() string {
+	return fmt.Sprintf("`%s` cannot be ordered", e.Type)
+}
+
+func (NotOrderedError) Code() string {
+	return "E0609"
+}
+
+// NotComparableError is an error that is returned when an equality primitive is applied to functions or objects.
+type NotComparableError struct {
+	Type string
+}
+
+func (e NotComparableError) Error() string {
+	return fmt.Sprintf("`%s` cannot be compared for equality", e.Type)
+}
+
+func (NotComparableError) Code() string {
+	return "E0610"
+}
@@ -0,0 +1,296 @@
+package typecheck
+
+import (
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// infer infers the type of the expression.
+func (c *Checker) infer(node ast.Node) (Type, error) {
+	switch node := node.(type) {
+	case *ast.Var:
+		return c.inferVar(node.Name)
+	case *ast.Literal:
+		return literalType(node)
+	case *ast.Paren:
+		return c.infer(node.Expr)
+	case *ast.Tuple:
+		elems, err := c.inferList(node.Exprs)
+		if err != nil {
+			return nil, err
+		}
+
+		return &TTuple{Elems: elems}, nil
+	case *ast.Access:
+		return c.inferAccess(node)
+	case *ast.Call:
+		fn, err := c.infer(node.Func)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.inferApply(node.Base(), fn, node.Args)
+	case *ast.Prim:
+		fn, ok := c.primSignature(node.Name)
+		if !ok {
+			// Unknown primitives are checked at runtime.
+			if _, err := c.inferList(node.Args); err != nil {
+				return nil, err
+			}
+
+			return c.fresh(), nil
+		}
+
+		return c.inferApply(node.Base(), fn, node.Args)
+	case *ast.Binary:
+		op, err := c.inferVar(node.Op)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.inferApply(node.Base(), op, []ast.Node{node.Left, node.Right})
+	case *ast.Assert:
+		return c.inferAssert(node)
+	case *ast.Let:
+		body, err := c.infer(node.Body)
+		if err != nil {
+			return nil, err
+		}
+		bind, err := c.inferPattern(node.Bind)
+		if err != nil {
+			return nil, err
+		}
+
+		return unitType(), c.unifyAt(node.Base(), bind, body)
+	case *ast.Seq:
+		var result Type = unitType()
+		for _, expr := range node.Exprs {
+			var err error
+			result, err = c.infer(expr)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return result, nil
+	case *ast.Lambda:
+		params := make([]Type, len(node.Params))
+		for i, param := range node.Params {
+			v := c.fresh()
+			c.env[nameOf(param)] = mono(v)
+			params[i] = v
+		}
+		ret, err := c.infer(node.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &TFun{Params: params, Ret: ret}, nil
+	case *ast.Case:
+		return c.inferCase(node)
+	case *ast.Object:
+		return c.inferObject(node)
+	}
+
+	return nil, utils.PosError{Where: node.Base(), Err: InvalidExpressionError{Node: node}}
+}
+
+func (c *Checker) inferList(nodes []ast.Node) ([]Type, error) {
+	types := make([]Type, len(nodes))
+	for i, node := range nodes {
+		var err error
+		types[i], err = c.infer(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return types, nil
+}
+
+func (c *Checker) inferVar(name token.Token) (Type, error) {
+	scheme, ok := c.env[nameOf(name)]
+	if !ok {
+		return nil, utils.PosError{Where: name, Err: NotInScopeError{Name: name.String()}}
+	}
+
+	return c.instantiate(name, scheme), nil
+}
+
+func literalType(node *ast.Literal) (Type, error) {
+	//exhaustive:ignore
+	switch node.Kind {
+	case token.INTEGER:
+		return intType(), nil
+	case token.FLOAT:
+		return floatType(), nil
+	case token.STRING:
+		return stringType(), nil
+	default:
+		return nil, utils.PosError{Where: node.Base(), Err: InvalidExpressionError{Node: node}}
+	}
+}
+
+// inferAccess infers `receiver.name`.
+// The receiver must be a record that has the field.
+func (c *Checker) inferAccess(node *ast.Access) (Type, error) {
+	receiver, err := c.infer(node.Receiver)
+	if err != nil {
+		return nil, err
+	}
+	field := c.fresh()
+	record := &TRecord{Fields: map[string]Type{node.Name.Lexeme: field}, Rest: c.fresh()}
+	if err := c.unifyAt(node.Base(), record, receiver); err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
+
+// inferObject infers the type of the object.
+// If a codata type declares exactly the same fields, the object has the codata type.
+// Otherwise, it has a closed record type.
+func (c *Checker) inferObject(node *ast.Object) (Type, error) {
+	fields := make(map[string]Type, len(node.Fields))
+	for _, field := range node.Fields {
+		var err error
+		fields[field.Name], err = c.infer(field.Expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	record := &TRecord{Fields: fields, Rest: nil}
+
+	info, ok := c.codataOf(fields)
+	if !ok {
+		return record, nil
+	}
+	args := make([]Type, len(info.params))
+	for i := range args {
+		args[i] = c.fresh()
+	}
+	codata := &TCon{Name: info.name, Display: info.display, Args: args}
+	if err := c.unifyAt(node.Base(), codata, record); err != nil {
+		return nil, err
+	}
+
+	return codata, nil
+}
+
+// inferApply infers a call of fn with args.
+func (c *Checker) inferApply(where token.Token, fn Type, args []ast.Node) (Type, error) {
+	argTypes, err := c.inferList(args)
+	if err != nil {
+		return nil, err
+	}
+	ret := c.fresh()
+	if err := c.unifyAt(where, fn, &TFun{Params: argTypes, Ret: ret}); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// inferAssert checks `expr : type`.
+// Type variables in the type are unification variables.
+func (c *Checker) inferAssert(node *ast.Assert) (Type, error) {
+	expr, err := c.infer(node.Expr)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := c.convert(node.Type, make(map[string]Type), func(token.Token) Type { return c.fresh() })
+	if err != nil {
+		return nil, err
+	}
+	if err := c.unifyAt(node.Base(), typ, expr); err != nil {
+		return nil, err
+	}
+
+	return typ, nil
+}
+
+func (c *Checker) inferCase(node *ast.Case) (Type, error) {
+	scrutinees, err := c.inferList(node.Scrutinees)
+	if err != nil {
+		return nil, err
+	}
+	result := c.fresh()
+	for _, clause := range node.Clauses {
+		if len(clause.Patterns) != len(scrutinees) {
+			return nil, utils.PosError{Where: clause.Base(), Err: TypeMismatchError{
+				Expected: plural(len(scrutinees), "pattern"),
+				Actual:   plural(len(clause.Patterns), "pattern"),
+				Detail:   "",
+			}}
+		}
+		for i, pattern := range clause.Patterns {
+			typ, err := c.inferPattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.unifyAt(pattern.Base(), scrutinees[i], typ); err != nil {
+				return nil, err
+			}
+		}
+		body, err := c.infer(clause.Expr)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.unifyAt(clause.Expr.Base(), result, body); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// inferPattern infers the type of the pattern and binds variables in it.
+func (c *Checker) inferPattern(node ast.Node) (Type, error) {
+	switch node := node.(type) {
+	case *ast.Var:
+		v := c.fresh()
+		c.env[nameOf(node.Name)] = mono(v)
+
+		return v, nil
+	case *ast.Literal:
+		return literalType(node)
+	case *ast.Paren:
+		return c.inferPattern(node.Expr)
+	case *ast.Tuple:
+		elems := make([]Type, len(node.Exprs))
+		for i, pattern := range node.Exprs {
+			var err error
+			elems[i], err = c.inferPattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &TTuple{Elems: elems}, nil
+	case *ast.Call:
+		ctor, ok := node.Func.(*ast.Var)
+		if !ok {
+			return nil, utils.PosError{Where: node.Base(), Err: NotConstructorError{Node: node}}
+		}
+		fn, err := c.inferVar(ctor.Name)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]Type, len(node.Args))
+		for i, pattern := range node.Args {
+			args[i], err = c.inferPattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ret := c.fresh()
+		if err := c.unifyAt(node.Base(), fn, &TFun{Params: args, Ret: ret}); err != nil {
+			return nil, err
+		}
+
+		return ret, nil
+	}
+
+	return nil, utils.PosError{Where: node.Base(), Err: InvalidPatternError{Pattern: node}}
+}
@@ -0,0 +1,831 @@
+// Package typecheck infers and checks types of the program in Hindley-Milner style.
+// It runs after name resolution, so every variable has a unique name.
+//
+// Top-level definitions are grouped into strongly connected components and generalized group by group.
+// Definitions with a type signature (`def f : T = ...`) are checked against the signature,
+// whose type variables are rigid. Type variables in assertions (`expr : T`) are flexible.
+// Objects have structural record types with row polymorphism, and they may be recursive.
+// Arithmetic primitives accept both Int and Float. A top-level definition whose operands are undecided
+// is generalized with the class of the primitive, such as `Num a => (a, a) -> a`, and the class is checked at each use.
+// Other undecided operands default to Int.
+// Equality primitives reject functions and objects, which cannot be compared at run time.
+// Some primitives return `Bool` and `List(a)` if the program declares them as in [builtinTypes].
+// Codata types such as `type Stream(a) = { head : a, tail : Stream(a) }` name record types.
+// They are unfolded when they meet a record, and objects that have exactly their fields are given them.
+// All errors are accumulated and returned at the end of the process.
+package typecheck
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// Checker infers types of top-level definitions.
+type Checker struct {
+	supply  int
+	types   map[string]*typeInfo // Type constructor name -> definition.
+	env     map[string]*Scheme   // Variable name -> type.
+	rigid   map[*TVar]string     // Type variable of a signature -> its source name.
+	builtin map[string]*typeInfo // Name in [builtinTypes] -> its declaration. Primitives return these types.
+	pending []constraint         // Constraints on operands of primitives. They are solved at generalization.
+}
+
+// constraint requires typ to be one of primitive types in the class.
+type constraint struct {
+	where token.Token
+	typ   Type
+	class class
+}
+
+type class int
+
+const (
+	numClass class = iota // Int or Float.
+	ordClass              // Int, Float or String.
+	eqClass               // Types whose values are compared by their structure: no functions or objects.
+)
+
+func (k class) String() string {
+	switch k {
+	case numClass:
+		return "Num"
+	case ordClass:
+		return "Ord"
+	case eqClass:
+		return "Eq"
+	}
+
+	panic(fmt.Sprintf("unreachable: class %d", k))
+}
+
+// typeInfo is a definition of a type constructor.
+type typeInfo struct {
+	name    string
+	display string
+	params  []*TVar
+	alias   Type     // Non-nil if the type is a synonym such as `type Int = prim(int)`.
+	record  *TRecord // Non-nil if the type is a codata type such as `type Stream(a) = { head : a, tail : Stream(a) }`.
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		supply:  0,
+		types:   make(map[string]*typeInfo),
+		env:     make(map[string]*Scheme),
+		rigid:   make(map[*TVar]string),
+		builtin: make(map[string]*typeInfo),
+		pending: nil,
+	}
+}
+
+func (c *Checker) Name() string {
+	return "typecheck.Checker"
+}
+
+// Snapshot returns a function that forgets types and variables of programs checked after it.
+func (c *Checker) Snapshot() (restore func()) {
+	types, env, rigid, builtin := maps.Clone(c.types), maps.Clone(c.env), maps.Clone(c.rigid), maps.Clone(c.builtin)
+
+	return func() {
+		c.types, c.env, c.rigid, c.builtin = types, env, rigid, builtin
+		c.pending = nil
+	}
+}
+
+// Init registers type declarations and types of their constructors.
+func (c *Checker) Init(program []ast.Node) error {
+	var decls []*ast.TypeDecl
+	for _, node := range program {
+		if decl, ok := node.(*ast.TypeDecl); ok {
+			decls = append(decls, decl)
+		}
+	}
+
+	// Register all type constructors first, because type declarations may refer to each other.
+	var errs error
+	infos := make([]*typeInfo, len(decls))
+	for i, decl := range decls {
+		var err error
+		infos[i], err = c.registerType(decl)
+		errs = errors.Join(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+
+	for i, decl := range decls {
+		errs = errors.Join(errs, c.defineType(infos[i], decl))
+	}
+
+	return errs
+}
+
+// Run infers types of all top-level definitions.
+func (c *Checker) Run(program []ast.Node) ([]ast.Node, error) {
+	var decls []*ast.VarDecl
+	for _, node := range program {
+		if decl, ok := node.(*ast.VarDecl); ok {
+			decls = append(decls, decl)
+		}
+	}
+
+	var errs error
+	signatures := make(map[*ast.VarDecl]*Scheme)
+	for _, decl := range decls {
+		if decl.Type == nil {
+			continue
+		}
+		scheme, err := c.signature(decl.Type)
+		if err != nil {
+			errs = errors.Join(errs, err)
+
+			continue
+		}
+		signatures[decl] = scheme
+		c.env[nameOf(decl.Name)] = scheme
+	}
+
+	for _, group := range dependencyGroups(decls) {
+		errs = errors.Join(errs, c.inferGroup(group, signatures))
+	}
+
+	return program, errs
+}
+
+// TypeOf returns the type of the top-level variable.
+func (c *Checker) TypeOf(name token.Token) (*Scheme, bool) {
+	scheme, ok := c.env[nameOf(name)]
+
+	return scheme, ok
+}
+
+func (c *Checker) fresh() *TVar {
+	c.supply++
+
+	return &TVar{ID: c.supply, Ref: nil}
+}
+
+// nameOf returns the unique name of the resolved token.
+func nameOf(t token.Token) string {
+	return fmt.Sprintf("%s.%#v", t.Lexeme, t.Literal)
+}
+
+func (c *Checker) registerType(decl *ast.TypeDecl) (*typeInfo, error) {
+	var head token.Token
+	var params []ast.Node
+	switch def := decl.Def.(type) {
+	case *ast.Var:
+		head = def.Name
+	case *ast.Call:
+		name, ok := def.Func.(*ast.Var)
+		if !ok {
+			return nil, utils.PosError{Where: def.Base(), Err: InvalidTypeError{Type: def}}
+		}
+		head = name.Name
+		params = def.Args
+	default:
+		return nil, utils.PosError{Where: def.Base(), Err: InvalidTypeError{Type: def}}
+	}
+
+	info := &typeInfo{name: nameOf(head), display: head.Lexeme, params: make([]*TVar, len(params)), alias: nil, record: nil}
+	for i := range params {
+		info.params[i] = c.fresh()
+	}
+	c.types[info.name] = info
+
+	return info, nil
+}
+
+// defineType defines a type synonym or constructors of the type.
+func (c *Checker) defineType(info *typeInfo, decl *ast.TypeDecl) error {
+	vars := make(map[string]Type)
+	if def, ok := decl.Def.(*ast.Call); ok {
+		for i, param := range def.Args {
+			param, ok := param.(*ast.Var)
+			if !ok {
+				return utils.PosError{Where: param.Base(), Err: InvalidTypeError{Type: param}}
+			}
+			vars[nameOf(param.Name)] = info.params[i]
+		}
+	}
+
+	if len(decl.Types) == 1 {
+		switch body := decl.Types[0].(type) {
+		case *ast.Prim, *ast.Var:
+			alias, err := c.convert(body, vars, nil)
+			if err != nil {
+				return err
+			}
+			info.alias = alias
+
+			return nil
+		case *ast.Object:
+			record, err := c.convert(body, vars, nil)
+			if err != nil {
+				return err
+			}
+			//nolint:forcetypeassert
+			info.record = record.(*TRecord)
+
+			return nil
+		}
+	}
+
+	args := make([]Type, len(info.params))
+	for i, param := range info.params {
+		args[i] = param
+	}
+	result := &TCon{Name: info.name, Display: info.display, Args: args}
+
+	var errs error
+	for _, ctor := range decl.Types {
+		call, ok := ctor.(*ast.Call)
+		if !ok {
+			errs = errors.Join(errs, utils.PosError{Where: ctor.Base(), Err: InvalidTypeError{Type: ctor}})
+
+			continue
+		}
+		name, ok := call.Func.(*ast.Var)
+		if !ok {
+			errs = errors.Join(errs, utils.PosError{Where: ctor.Base(), Err: InvalidTypeError{Type: ctor}})
+
+			continue
+		}
+		fields := make([]Type, len(call.Args))
+		for i, arg := range call.Args {
+			var err error
+			fields[i], err = c.convert(arg, vars, nil)
+			if err != nil {
+				errs = errors.Join(errs, err)
+				fields[i] = c.fresh()
+			}
+		}
+		c.env[nameOf(name.Name)] = &Scheme{Vars: info.params, Type: &TFun{Params: fields, Ret: result}, constraints: nil}
+	}
+	if isBuiltin(info, decl) {
+		c.builtin[info.display] = info
+	}
+
+	return errs
+}
+
+// builtinTypes are data types that primitives return.
+// Type name -> constructor name -> arity.
+// For example, comparisons return `type Bool = { False(), True() }`.
+var builtinTypes = map[string]map[string]int{
+	"Bool": {"False": 0, "True": 0},
+	"List": {"Nil": 0, "Cons": 2},
+}
+
+// isBuiltin reports whether the declaration has the same constructors as [builtinTypes], in any order.
+func isBuiltin(info *typeInfo, decl *ast.TypeDecl) bool {
+	ctors, ok := builtinTypes[info.display]
+	if !ok || len(decl.Types) != len(ctors) {
+		return false
+	}
+	for _, ctor := range decl.Types {
+		call, ok := ctor.(*ast.Call)
+		if !ok {
+			return false
+		}
+		name, ok := call.Func.(*ast.Var)
+		if !ok {
+			return false
+		}
+		if arity, ok := ctors[name.Name.Lexeme]; !ok || arity != len(call.Args) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// signature converts a type signature to a type scheme.
+// Names that are not types are implicitly quantified type variables.
+func (c *Checker) signature(node ast.Node) (*Scheme, error) {
+	vars := make(map[string]Type)
+	var quantified []*TVar
+	typ, err := c.convert(node, vars, func(name token.Token) Type {
+		v := c.fresh()
+		c.rigid[v] = name.Lexeme
+		quantified = append(quantified, v)
+
+		return v
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scheme{Vars: quantified, Type: typ, constraints: nil}, nil
+}
+
+// convert converts a type expression to a [Type].
+// vars maps type variable names to types.
+// If a name is neither a type nor a known type variable, newVar is called to make a type variable.
+// If newVar is nil, such a name is an error.
+func (c *Checker) convert(node ast.Node, vars map[string]Type, newVar func(token.Token) Type) (Type, error) {
+	switch node := node.(type) {
+	case *ast.Var:
+		name := nameOf(node.Name)
+		if t, ok := vars[name]; ok {
+			return t, nil
+		}
+		if info, ok := c.types[name]; ok {
+			return c.apply(node.Name, info, nil)
+		}
+		if newVar != nil {
+			vars[name] = newVar(node.Name)
+
+			return vars[name], nil
+		}
+	case *ast.Paren:
+		return c.convert(node.Expr, vars, newVar)
+	case *ast.Call:
+		fn, ok := node.Func.(*ast.Var)
+		if !ok {
+			break
+		}
+		info, ok := c.types[nameOf(fn.Name)]
+		if !ok {
+			break
+		}
+		args, err := c.convertList(node.Args, vars, newVar)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.apply(fn.Name, info, args)
+	case *ast.Binary:
+		if node.Op.Kind != token.ARROW {
+			break
+		}
+		var params []Type
+		var err error
+		if call, ok := node.Left.(*ast.Call); ok && isThis(call.Func) {
+			params, err = c.convertList(call.Args, vars, newVar)
+		} else {
+			var param Type
+			param, err = c.convert(node.Left, vars, newVar)
+			params = []Type{param}
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret, err := c.convert(node.Right, vars, newVar)
+		if err != nil {
+			return nil, err
+		}
+
+		return &TFun{Params: params, Ret: ret}, nil
+	case *ast.Tuple:
+		elems, err := c.convertList(node.Exprs, vars, newVar)
+		if err != nil {
+			return nil, err
+		}
+
+		return &TTuple{Elems: elems}, nil
+	case *ast.Object:
+		fields := make(map[string]Type, len(node.Fields))
+		for _, field := range node.Fields {
+			var err error
+			fields[field.Name], err = c.convert(field.Expr, vars, newVar)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &TRecord{Fields: fields, Rest: nil}, nil
+	case *ast.Prim:
+		args, err := c.convertList(node.Args, vars, newVar)
+		if err != nil {
+			return nil, err
+		}
+
+		return primType(node.Name.Lexeme, args), nil
+	}
+
+	return nil, utils.PosError{Where: node.Base(), Err: InvalidTypeError{Type: node}}
+}
+
+func (c *Checker) convertList(nodes []ast.Node, vars map[string]Type, newVar func(token.Token) Type) ([]Type, error) {
+	types := make([]Type, len(nodes))
+	for i, node := range nodes {
+		var err error
+		types[i], err = c.convert(node, vars, newVar)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return types, nil
+}
+
+func isThis(node ast.Node) bool {
+	_, ok := node.(*ast.This)
+
+	return ok
+}
+
+// apply applies the type constructor to the arguments.
+func (c *Checker) apply(where token.Token, info *typeInfo, args []Type) (Type, error) {
+	if len(args) != len(info.params) {
+		return nil, utils.PosError{Where: where, Err: TypeArityError{
+			Name:     info.display,
+			Expected: len(info.params),
+			Actual:   len(args),
+		}}
+	}
+	if info.alias != nil {
+		subst := make(map[*TVar]Type, len(args))
+		for i, param := range info.params {
+			subst[param] = args[i]
+		}
+
+		return substitute(info.alias, subst), nil
+	}
+
+	return &TCon{Name: info.name, Display: info.display, Args: args}, nil
+}
+
+// unfold expands the codata type to its record type.
+func (c *Checker) unfold(con *TCon) (*TRecord, bool) {
+	info, ok := c.types[con.Name]
+	if !ok || info.record == nil || len(info.params) != len(con.Args) {
+		return nil, false
+	}
+	subst := make(map[*TVar]Type, len(con.Args))
+	for i, param := range info.params {
+		subst[param] = con.Args[i]
+	}
+	//nolint:forcetypeassert
+	return substitute(info.record, subst).(*TRecord), true
+}
+
+// codataOf searches the codata type that has exactly the given fields.
+// If there is no such type or there are more than one, it returns false.
+func (c *Checker) codataOf(fields map[string]Type) (*typeInfo, bool) {
+	var found *typeInfo
+	for _, info := range c.types {
+		if info.record == nil || len(info.record.Fields) != len(fields) {
+			continue
+		}
+		matched := true
+		for name := range fields {
+			if _, ok := info.record.Fields[name]; !ok {
+				matched = false
+
+				break
+			}
+		}
+		if matched {
+			if found != nil {
+				return nil, false
+			}
+			found = info
+		}
+	}
+
+	return found, found != nil
+}
+
+// primType returns a type introduced by `prim(name, args...)`.
+func primType(name string, args []Type) Type {
+	switch {
+	case name == "int" && len(args) == 0:
+		return intType()
+	case name == "float" && len(args) == 0:
+		return floatType()
+	case name == "string" && len(args) == 0:
+		return stringType()
+	default:
+		return &TCon{Name: "prim(" + name + ")", Display: "prim(" + name + ")", Args: args}
+	}
+}
+
+// primSignature returns the type of the primitive operator.
+// Unknown primitives are not checked.
+func (c *Checker) primSignature(name token.Token) (Type, bool) {
+	result := c.fresh()
+	switch name.Lexeme {
+	case "exit":
+		return &TFun{Params: nil, Ret: result}, true
+	case "print":
+		return &TFun{Params: []Type{c.fresh()}, Ret: unitType()}, true
+	case "print_cps":
+		return &TFun{Params: []Type{stringType(), &TFun{Params: nil, Ret: result}}, Ret: result}, true
+	case "read_all_cps":
+		return &TFun{Params: []Type{&TFun{Params: []Type{stringType()}, Ret: result}}, Ret: result}, true
+	case "add", "sub", "mul", "div", "mod":
+		c.pending = append(c.pending, constraint{where: name, typ: result, class: numClass})
+
+		return &TFun{Params: []Type{result, result}, Ret: result}, true
+	case "neg":
+		c.pending = append(c.pending, constraint{where: name, typ: result, class: numClass})
+
+		return &TFun{Params: []Type{result}, Ret: result}, true
+	case "eq", "ne":
+		c.pending = append(c.pending, constraint{where: name, typ: result, class: eqClass})
+
+		return &TFun{Params: []Type{result, result}, Ret: c.builtinType("Bool")}, true
+	case "lt", "le", "gt", "ge":
+		c.pending = append(c.pending, constraint{where: name, typ: result, class: ordClass})
+
+		return &TFun{Params: []Type{result, result}, Ret: c.builtinType("Bool")}, true
+	case "concat":
+		return &TFun{Params: []Type{stringType(), stringType()}, Ret: stringType()}, true
+	case "length", "parse_int":
+		return &TFun{Params: []Type{stringType()}, Ret: intType()}, true
+	case "substring":
+		return &TFun{Params: []Type{stringType(), intType(), intType()}, Ret: stringType()}, true
+	case "char_at":
+		return &TFun{Params: []Type{stringType(), intType()}, Ret: stringType()}, true
+	case "index_of":
+		return &TFun{Params: []Type{stringType(), stringType()}, Ret: intType()}, true
+	case "to_string":
+		return &TFun{Params: []Type{result}, Ret: stringType()}, true
+	case "split":
+		return &TFun{Params: []Type{stringType(), stringType()}, Ret: c.builtinType("List", stringType())}, true
+	case "chars":
+		return &TFun{Params: []Type{stringType()}, Ret: c.builtinType("List", stringType())}, true
+	case "join":
+		return &TFun{Params: []Type{c.builtinType("List", stringType()), stringType()}, Ret: stringType()}, true
+	default:
+		return nil, false
+	}
+}
+
+// instantiate instantiates the scheme with fresh type variables.
+// Classes of quantified variables are required again at where.
+func (c *Checker) instantiate(where token.Token, scheme *Scheme) Type {
+	if len(scheme.Vars) == 0 {
+		return scheme.Type
+	}
+	subst := make(map[*TVar]Type, len(scheme.Vars))
+	for _, v := range scheme.Vars {
+		subst[v] = c.fresh()
+	}
+	for _, k := range scheme.constraints {
+		c.pending = append(c.pending, constraint{where: where, typ: substitute(k.typ, subst), class: k.class})
+	}
+
+	return substitute(scheme.Type, subst)
+}
+
+// skolemize instantiates the scheme with rigid type variables.
+func (c *Checker) skolemize(scheme *Scheme) Type {
+	subst := make(map[*TVar]Type, len(scheme.Vars))
+	for _, v := range scheme.Vars {
+		c.supply++
+		display := c.rigid[v]
+		subst[v] = &TCon{Name: fmt.Sprintf("%s.rigid%d", display, c.supply), Display: display, Args: nil}
+	}
+
+	return substitute(scheme.Type, subst)
+}
+
+// generalize quantifies all free type variables with the constraints on them.
+// It is only used for top-level definitions, whose environment has no free type variables.
+func generalize(t Type, constraints []constraint) *Scheme {
+	vars := freeVars(t)
+	var kept []constraint
+	for _, k := range constraints {
+		if v, ok := resolve(k.typ).(*TVar); ok && slices.Contains(vars, v) {
+			kept = append(kept, k)
+		}
+	}
+
+	return &Scheme{Vars: vars, Type: t, constraints: kept}
+}
+
+// unifyAt unifies expected and actual, and reports the error at where.
+func (c *Checker) unifyAt(where token.Token, expected, actual Type) error {
+	m := c.unify(expected, actual)
+	if m == nil {
+		return nil
+	}
+	p := newPrinter()
+	err := TypeMismatchError{Expected: p.print(expected), Actual: p.print(actual), Detail: ""}
+	switch {
+	case m.detail != nil:
+		err.Detail = m.detail(p)
+	case resolve(m.left) != resolve(expected) || resolve(m.right) != resolve(actual):
+		err.Detail = fmt.Sprintf("`%s` is not `%s`", p.print(m.left), p.print(m.right))
+	}
+
+	return utils.PosError{Where: where, Err: err}
+}
+
+// inferGroup infers types of mutually recursive definitions.
+func (c *Checker) inferGroup(group []*ast.VarDecl, signatures map[*ast.VarDecl]*Scheme) error {
+	monos := make(map[*ast.VarDecl]*TVar)
+	for _, decl := range group {
+		if _, ok := signatures[decl]; !ok {
+			monos[decl] = c.fresh()
+			c.env[nameOf(decl.Name)] = mono(monos[decl])
+		}
+	}
+
+	var errs error
+	for _, decl := range group {
+		if decl.Expr == nil {
+			continue
+		}
+		typ, err := c.infer(decl.Expr)
+		if err != nil {
+			errs = errors.Join(errs, err)
+
+			continue
+		}
+		if scheme, ok := signatures[decl]; ok {
+			errs = errors.Join(errs, c.unifyAt(decl.Name, c.skolemize(scheme), typ))
+		} else if v, ok := monos[decl]; ok {
+			errs = errors.Join(errs, c.unifyAt(decl.Name, v, typ))
+		}
+	}
+
+	var generalized []*TVar
+	for _, v := range monos {
+		generalized = append(generalized, freeVars(v)...)
+	}
+	constraints, err := c.solvePending(generalized)
+	errs = errors.Join(errs, err)
+
+	for decl, v := range monos {
+		c.env[nameOf(decl.Name)] = generalize(v, constraints)
+	}
+
+	return errs
+}
+
+// solvePending checks that operands of primitives are instances of their classes.
+// It returns the constraints on the generalized variables, which are quantified with them.
+// Other undecided types default to Int, except that any type can be compared for equality.
+func (c *Checker) solvePending(generalized []*TVar) ([]constraint, error) {
+	var errs error
+	var constraints []constraint
+	for len(c.pending) > 0 {
+		pending := c.pending[0]
+		c.pending = c.pending[1:]
+		switch t := resolve(pending.typ).(type) {
+		case *TVar:
+			switch {
+			case slices.Contains(generalized, t):
+				if !slices.ContainsFunc(constraints, func(k constraint) bool {
+					return resolve(k.typ) == t && k.class == pending.class
+				}) {
+					constraints = append(constraints, constraint{where: pending.where, typ: t, class: pending.class})
+				}
+			case pending.class != eqClass:
+				t.Ref = intType()
+			}
+
+			continue
+		case *TCon:
+			if pending.class == eqClass && c.comparable(t) {
+				// Data types are equal if their arguments are.
+				for _, arg := range t.Args {
+					c.pending = append(c.pending, constraint{where: pending.where, typ: arg, class: eqClass})
+				}
+
+				continue
+			}
+			if pending.class.has(t) {
+				continue
+			}
+		case *TTuple:
+			if pending.class == eqClass {
+				for _, elem := range t.Elems {
+					c.pending = append(c.pending, constraint{where: pending.where, typ: elem, class: eqClass})
+				}
+
+				continue
+			}
+		}
+		display := newPrinter().print(pending.typ)
+		switch pending.class {
+		case numClass:
+			errs = errors.Join(errs, utils.PosError{Where: pending.where, Err: NotNumberError{Type: display}})
+		case ordClass:
+			errs = errors.Join(errs, utils.PosError{Where: pending.where, Err: NotOrderedError{Type: display}})
+		case eqClass:
+			errs = errors.Join(errs, utils.PosError{Where: pending.where, Err: NotComparableError{Type: display}})
+		}
+	}
+
+	return constraints, errs
+}
+
+func (k class) has(con *TCon) bool {
+	switch con.Name {
+	case intType().Name, floatType().Name:
+		return k != eqClass
+	case stringType().Name:
+		return k == ordClass
+	default:
+		return false
+	}
+}
+
+// comparable reports whether values of the type constructor can be compared for equality by their structure.
+// Primitive types and data types can. Codata types and rigid type variables of signatures cannot.
+func (c *Checker) comparable(con *TCon) bool {
+	if info, ok := c.types[con.Name]; ok {
+		return info.record == nil
+	}
+
+	return strings.HasPrefix(con.Name, "prim(")
+}
+
+// builtinType returns the declared builtin type applied to args.
+// If it is not declared, the result of primitives is not checked.
+func (c *Checker) builtinType(name string, args ...Type) Type {
+	info, ok := c.builtin[name]
+	if !ok || len(info.params) != len(args) {
+		return c.fresh()
+	}
+
+	return &TCon{Name: info.name, Display: info.display, Args: args}
+}
+
+// dependencyGroups splits definitions into strongly connected components by Tarjan's algorithm.
+// Each component comes after all components it depends on.
+func dependencyGroups(decls []*ast.VarDecl) [][]*ast.VarDecl {
+	byName := make(map[string]*ast.VarDecl, len(decls))
+	for _, decl := range decls {
+		byName[nameOf(decl.Name)] = decl
+	}
+
+	index := make(map[*ast.VarDecl]int)
+	lowlink := make(map[*ast.VarDecl]int)
+	onStack := make(map[*ast.VarDecl]bool)
+	var stack []*ast.VarDecl
+	var groups [][]*ast.VarDecl
+
+	var visit func(decl *ast.VarDecl)
+	visit = func(decl *ast.VarDecl) {
+		index[decl] = len(index)
+		lowlink[decl] = index[decl]
+		stack = append(stack, decl)
+		onStack[decl] = true
+
+		for _, name := range references(decl) {
+			dep, ok := byName[name]
+			if !ok {
+				continue
+			}
+			if _, visited := index[dep]; !visited {
+				visit(dep)
+				lowlink[decl] = min(lowlink[decl], lowlink[dep])
+			} else if onStack[dep] {
+				lowlink[decl] = min(lowlink[decl], index[dep])
+			}
+		}
+
+		if lowlink[decl] == index[decl] {
+			var group []*ast.VarDecl
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				group = append([]*ast.VarDecl{top}, group...)
+				if top == decl {
+					break
+				}
+			}
+			groups = append(groups, group)
+		}
+	}
+
+	for _, decl := range decls {
+		if _, visited := index[decl]; !visited {
+			visit(decl)
+		}
+	}
+
+	return groups
+}
+
+// references returns names of variables referred in the definition.
+func references(decl *ast.VarDecl) []string {
+	if decl.Expr == nil {
+		return nil
+	}
+	var names []string
+	for _, node := range ast.Universe(decl.Expr) {
+		switch node := node.(type) {
+		case *ast.Var:
+			names = append(names, nameOf(node.Name))
+		case *ast.Binary:
+			names = append(names, nameOf(node.Op))
+		}
+	}
+
+	return names
+}
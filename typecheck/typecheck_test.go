@@ -0,0 +1,60 @@
+package typecheck_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/typecheck"
+	"github.com/takoeight0821/anma/utils"
+)
+
+func TestGolden(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := utils.FindSourceFiles("../testdata")
+	if err != nil {
+		t.Errorf("failed to find test files: %v", err)
+
+		return
+	}
+
+	for _, testfile := range testfiles {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", testfile, err)
+
+			return
+		}
+
+		checker := typecheck.NewChecker()
+		runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
+		runner.AddPass(checker)
+
+		// Type errors are also recorded in golden files. missing_field.anma is ill-typed to test the error,
+		// redundant*.anma check copattern coverage of `f`, whose `f(0).h` is both Int and an object,
+		// and tree.anma predates the type checker and uses `List` without its argument.
+		nodes, err := runner.RunSource(testfile, string(source))
+
+		var builder strings.Builder
+		for _, node := range nodes {
+			if decl, ok := node.(*ast.VarDecl); ok {
+				if scheme, ok := checker.TypeOf(decl.Name); ok {
+					fmt.Fprintf(&builder, "%s : %v\n", decl.Name.Lexeme, scheme)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(&builder, "error => %v\n", err)
+		}
+
+		g := goldie.New(t)
+		g.Assert(t, filepath.Base(testfile), []byte(builder.String()))
+	}
+}
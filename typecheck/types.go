@@ -0,0 +1,443 @@
+package typecheck
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Type is a type of Anma expressions.
+// Types are mutable graphs: unification binds [TVar]s in place.
+// Cycles are allowed only through [TRecord] (equi-recursive object types).
+type Type interface {
+	String() string
+	isType()
+}
+
+// TVar is a unification variable.
+// If Ref is not nil, the variable is bound to Ref.
+type TVar struct {
+	ID  int
+	Ref Type
+}
+
+func (v *TVar) String() string {
+	return newPrinter().print(v)
+}
+
+func (*TVar) isType() {}
+
+// TCon is a named type constructor applied to arguments.
+// Name is a unique name (e.g. `List.3`) and Display is its source name (e.g. `List`).
+// Rigid type variables of type signatures are also represented as TCon without arguments.
+type TCon struct {
+	Name    string
+	Display string
+	Args    []Type
+}
+
+func (t *TCon) String() string {
+	return newPrinter().print(t)
+}
+
+func (*TCon) isType() {}
+
+// TFun is a type of functions.
+type TFun struct {
+	Params []Type
+	Ret    Type
+}
+
+func (t *TFun) String() string {
+	return newPrinter().print(t)
+}
+
+func (*TFun) isType() {}
+
+// TTuple is a type of tuples. The empty tuple is the unit type.
+type TTuple struct {
+	Elems []Type
+}
+
+func (t *TTuple) String() string {
+	return newPrinter().print(t)
+}
+
+func (*TTuple) isType() {}
+
+// TRecord is a type of objects.
+// If Rest is nil, the record is closed. Otherwise, Rest is a row variable that stands for the other fields.
+type TRecord struct {
+	Fields map[string]Type
+	Rest   Type
+}
+
+func (t *TRecord) String() string {
+	return newPrinter().print(t)
+}
+
+func (*TRecord) isType() {}
+
+var (
+	_ Type = &TVar{}
+	_ Type = &TCon{}
+	_ Type = &TFun{}
+	_ Type = &TTuple{}
+	_ Type = &TRecord{}
+)
+
+// Builtin types.
+// They are introduced by `type Int = prim(int)`, `type Float = prim(float)` and `type String = prim(string)`.
+func intType() *TCon {
+	return &TCon{Name: "prim(int)", Display: "Int", Args: nil}
+}
+
+func floatType() *TCon {
+	return &TCon{Name: "prim(float)", Display: "Float", Args: nil}
+}
+
+func stringType() *TCon {
+	return &TCon{Name: "prim(string)", Display: "String", Args: nil}
+}
+
+func unitType() *TTuple {
+	return &TTuple{Elems: nil}
+}
+
+// resolve follows bound type variables.
+func resolve(t Type) Type {
+	for {
+		v, ok := t.(*TVar)
+		if !ok || v.Ref == nil {
+			return t
+		}
+		t = v.Ref
+	}
+}
+
+// flatten collects all fields of the record including fields of bound row variables.
+// It returns the fields and the unbound row variable (or nil if the record is closed).
+func flatten(r *TRecord) (map[string]Type, *TVar) {
+	fields := make(map[string]Type)
+	for {
+		for name, t := range r.Fields {
+			fields[name] = t
+		}
+		if r.Rest == nil {
+			return fields, nil
+		}
+		switch rest := resolve(r.Rest).(type) {
+		case *TVar:
+			return fields, rest
+		case *TRecord:
+			r = rest
+		default:
+			panic("unreachable: row variable must be a record")
+		}
+	}
+}
+
+// Scheme is a polymorphic type.
+type Scheme struct {
+	Vars        []*TVar
+	Type        Type
+	constraints []constraint // Classes of quantified variables, such as `Num a` of `(a, a) -> a`.
+}
+
+// String prints the scheme with the classes of its variables, such as `Num a => (a, a) -> a`.
+func (s *Scheme) String() string {
+	p := newPrinter()
+	typ := p.print(s.Type)
+	if len(s.constraints) == 0 {
+		return typ
+	}
+	classes := make([]string, len(s.constraints))
+	for i, k := range s.constraints {
+		classes[i] = k.class.String() + " " + p.print(k.typ)
+	}
+	if len(classes) == 1 {
+		return classes[0] + " => " + typ
+	}
+
+	return "(" + strings.Join(classes, ", ") + ") => " + typ
+}
+
+// mono makes a monomorphic scheme.
+func mono(t Type) *Scheme {
+	return &Scheme{Vars: nil, Type: t, constraints: nil}
+}
+
+// freeVars returns all unbound type variables in t in order of appearance.
+func freeVars(t Type) []*TVar {
+	var vars []*TVar
+	visited := make(map[*TRecord]bool)
+	var walk func(Type)
+	walk = func(t Type) {
+		switch t := resolve(t).(type) {
+		case *TVar:
+			if !slices.Contains(vars, t) {
+				vars = append(vars, t)
+			}
+		case *TCon:
+			for _, arg := range t.Args {
+				walk(arg)
+			}
+		case *TFun:
+			for _, param := range t.Params {
+				walk(param)
+			}
+			walk(t.Ret)
+		case *TTuple:
+			for _, elem := range t.Elems {
+				walk(elem)
+			}
+		case *TRecord:
+			if visited[t] {
+				return
+			}
+			visited[t] = true
+			for _, name := range sortedKeys(t.Fields) {
+				walk(t.Fields[name])
+			}
+			if t.Rest != nil {
+				walk(t.Rest)
+			}
+		}
+	}
+	walk(t)
+
+	return vars
+}
+
+// substitute replaces type variables in t according to subst.
+// Records are copied with their sharing, so recursive records stay recursive.
+func substitute(t Type, subst map[*TVar]Type) Type {
+	copied := make(map[*TRecord]*TRecord)
+	var walk func(Type) Type
+	walk = func(t Type) Type {
+		switch t := resolve(t).(type) {
+		case *TVar:
+			if s, ok := subst[t]; ok {
+				return s
+			}
+
+			return t
+		case *TCon:
+			args := make([]Type, len(t.Args))
+			for i, arg := range t.Args {
+				args[i] = walk(arg)
+			}
+
+			return &TCon{Name: t.Name, Display: t.Display, Args: args}
+		case *TFun:
+			params := make([]Type, len(t.Params))
+			for i, param := range t.Params {
+				params[i] = walk(param)
+			}
+
+			return &TFun{Params: params, Ret: walk(t.Ret)}
+		case *TTuple:
+			elems := make([]Type, len(t.Elems))
+			for i, elem := range t.Elems {
+				elems[i] = walk(elem)
+			}
+
+			return &TTuple{Elems: elems}
+		case *TRecord:
+			if r, ok := copied[t]; ok {
+				return r
+			}
+			r := &TRecord{Fields: make(map[string]Type), Rest: nil}
+			copied[t] = r
+			for name, field := range t.Fields {
+				r.Fields[name] = walk(field)
+			}
+			if t.Rest != nil {
+				r.Rest = walk(t.Rest)
+			}
+
+			return r
+		default:
+			panic("unreachable: unknown type")
+		}
+	}
+
+	return walk(t)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+// printer prints types with human-readable type variable names.
+// Recursive records are printed as `{...} as r`.
+type printer struct {
+	names     map[*TVar]string
+	recNames  map[*TRecord]string
+	recursive map[*TRecord]bool
+	onStack   map[*TRecord]bool
+	next      int
+}
+
+func newPrinter() *printer {
+	return &printer{
+		names:     make(map[*TVar]string),
+		recNames:  make(map[*TRecord]string),
+		recursive: make(map[*TRecord]bool),
+		onStack:   make(map[*TRecord]bool),
+		next:      0,
+	}
+}
+
+func (p *printer) freshName() string {
+	n := p.next
+	p.next++
+	name := string(rune('a' + n%26))
+	if n >= 26 {
+		name += strconv.Itoa(n / 26)
+	}
+
+	return name
+}
+
+// findRecursive marks records that are reachable from themselves.
+func (p *printer) findRecursive(t Type) {
+	switch t := resolve(t).(type) {
+	case *TCon:
+		for _, arg := range t.Args {
+			p.findRecursive(arg)
+		}
+	case *TFun:
+		for _, param := range t.Params {
+			p.findRecursive(param)
+		}
+		p.findRecursive(t.Ret)
+	case *TTuple:
+		for _, elem := range t.Elems {
+			p.findRecursive(elem)
+		}
+	case *TRecord:
+		if p.onStack[t] {
+			p.recursive[t] = true
+
+			return
+		}
+		if _, ok := p.recursive[t]; ok {
+			return
+		}
+		p.onStack[t] = true
+		p.recursive[t] = false
+		for _, field := range t.Fields {
+			p.findRecursive(field)
+		}
+		if t.Rest != nil {
+			p.findRecursive(t.Rest)
+		}
+		delete(p.onStack, t)
+	}
+}
+
+func (p *printer) print(t Type) string {
+	p.findRecursive(t)
+	var builder strings.Builder
+	p.write(&builder, t, false)
+
+	return builder.String()
+}
+
+// write writes t to the builder.
+// If nested is true, function types and recursive records are parenthesized.
+func (p *printer) write(builder *strings.Builder, t Type, nested bool) {
+	switch t := resolve(t).(type) {
+	case *TVar:
+		if _, ok := p.names[t]; !ok {
+			p.names[t] = p.freshName()
+		}
+		builder.WriteString(p.names[t])
+	case *TCon:
+		builder.WriteString(t.Display)
+		if len(t.Args) > 0 {
+			builder.WriteString("(")
+			p.writeList(builder, t.Args)
+			builder.WriteString(")")
+		}
+	case *TFun:
+		if nested {
+			builder.WriteString("(")
+		}
+		if len(t.Params) == 1 {
+			p.write(builder, t.Params[0], true)
+		} else {
+			builder.WriteString("(")
+			p.writeList(builder, t.Params)
+			builder.WriteString(")")
+		}
+		builder.WriteString(" -> ")
+		p.write(builder, t.Ret, false)
+		if nested {
+			builder.WriteString(")")
+		}
+	case *TTuple:
+		builder.WriteString("[")
+		p.writeList(builder, t.Elems)
+		builder.WriteString("]")
+	case *TRecord:
+		p.writeRecord(builder, t, nested)
+	}
+}
+
+func (p *printer) writeList(builder *strings.Builder, ts []Type) {
+	for i, t := range ts {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		p.write(builder, t, false)
+	}
+}
+
+func (p *printer) writeRecord(builder *strings.Builder, record *TRecord, nested bool) {
+	if p.onStack[record] {
+		builder.WriteString(p.recNames[record])
+
+		return
+	}
+	recursive := p.recursive[record]
+	if recursive {
+		p.recNames[record] = p.freshName()
+		if nested {
+			builder.WriteString("(")
+		}
+	}
+	p.onStack[record] = true
+
+	fields, rest := flatten(record)
+	builder.WriteString("{")
+	for i, name := range sortedKeys(fields) {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(name)
+		builder.WriteString(" : ")
+		p.write(builder, fields[name], false)
+	}
+	if rest != nil {
+		builder.WriteString(" | ")
+		p.write(builder, rest, false)
+	}
+	builder.WriteString("}")
+
+	delete(p.onStack, record)
+	if recursive {
+		builder.WriteString(" as ")
+		builder.WriteString(p.recNames[record])
+		if nested {
+			builder.WriteString(")")
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package typecheck
+
+import (
+	"slices"
+)
+
+// unifier unifies types.
+// seen records pairs of records that are being unified, so that recursive records unify coinductively.
+// unfolded records codata types that are unfolded against a record, for the same reason.
+type unifier struct {
+	checker  *Checker
+	seen     map[[2]*TRecord]bool
+	unfolded map[unfoldKey]*TCon
+}
+
+type unfoldKey struct {
+	name   string
+	record *TRecord
+}
+
+// mismatch describes why two types cannot be unified.
+// It is converted to [TypeMismatchError] by the caller.
+type mismatch struct {
+	left, right Type
+	detail      func(p *printer) string
+}
+
+func (c *Checker) unify(expected, actual Type) *mismatch {
+	u := unifier{checker: c, seen: make(map[[2]*TRecord]bool), unfolded: make(map[unfoldKey]*TCon)}
+
+	return u.unify(expected, actual)
+}
+
+func (u *unifier) unify(left, right Type) *mismatch {
+	left = resolve(left)
+	right = resolve(right)
+	if left == right {
+		return nil
+	}
+
+	if v, ok := left.(*TVar); ok {
+		return u.bind(v, right)
+	}
+	if v, ok := right.(*TVar); ok {
+		return u.bind(v, left)
+	}
+
+	switch left := left.(type) {
+	case *TCon:
+		if right, ok := right.(*TCon); ok && left.Name == right.Name && len(left.Args) == len(right.Args) {
+			return u.unifyList(left.Args, right.Args)
+		}
+		if right, ok := right.(*TRecord); ok {
+			return u.unifyCodata(left, right, false)
+		}
+	case *TFun:
+		if right, ok := right.(*TFun); ok {
+			if len(left.Params) != len(right.Params) {
+				return &mismatch{left: left, right: right, detail: func(*printer) string {
+					return arityDetail(len(left.Params), len(right.Params))
+				}}
+			}
+			if m := u.unifyList(left.Params, right.Params); m != nil {
+				return m
+			}
+
+			return u.unify(left.Ret, right.Ret)
+		}
+	case *TTuple:
+		if right, ok := right.(*TTuple); ok && len(left.Elems) == len(right.Elems) {
+			return u.unifyList(left.Elems, right.Elems)
+		}
+	case *TRecord:
+		switch right := right.(type) {
+		case *TRecord:
+			return u.unifyRecord(left, right)
+		case *TCon:
+			return u.unifyCodata(right, left, true)
+		}
+	}
+
+	return &mismatch{left: left, right: right, detail: nil}
+}
+
+func (u *unifier) unifyList(lefts, rights []Type) *mismatch {
+	for i := range lefts {
+		if m := u.unify(lefts[i], rights[i]); m != nil {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func (u *unifier) bind(v *TVar, t Type) *mismatch {
+	if occurs(v, t) {
+		return &mismatch{left: v, right: t, detail: func(p *printer) string {
+			return "infinite type: " + p.print(v) + " occurs in " + p.print(t)
+		}}
+	}
+	v.Ref = t
+
+	return nil
+}
+
+// occurs reports whether v occurs in t.
+// Occurrences inside records are allowed because records may be recursive.
+func occurs(v *TVar, t Type) bool {
+	switch t := resolve(t).(type) {
+	case *TVar:
+		return t == v
+	case *TCon:
+		return slices.ContainsFunc(t.Args, func(arg Type) bool { return occurs(v, arg) })
+	case *TFun:
+		return occurs(v, t.Ret) || slices.ContainsFunc(t.Params, func(param Type) bool { return occurs(v, param) })
+	case *TTuple:
+		return slices.ContainsFunc(t.Elems, func(elem Type) bool { return occurs(v, elem) })
+	default:
+		return false
+	}
+}
+
+// unifyRecord unifies two records with row polymorphism.
+// Fields missing from one side are pushed into its row variable.
+// If the side is closed, the missing field is reported.
+func (u *unifier) unifyRecord(left, right *TRecord) *mismatch {
+	key := [2]*TRecord{left, right}
+	if u.seen[key] {
+		return nil
+	}
+	u.seen[key] = true
+
+	leftFields, leftRest := flatten(left)
+	rightFields, rightRest := flatten(right)
+
+	var onlyLeft, onlyRight []string
+	for _, name := range sortedKeys(leftFields) {
+		if _, ok := rightFields[name]; !ok {
+			onlyLeft = append(onlyLeft, name)
+		}
+	}
+	for _, name := range sortedKeys(rightFields) {
+		if _, ok := leftFields[name]; !ok {
+			onlyRight = append(onlyRight, name)
+		}
+	}
+
+	if len(onlyRight) > 0 && leftRest == nil {
+		return missingField(left, right, onlyRight[0], left)
+	}
+	if len(onlyLeft) > 0 && rightRest == nil {
+		return missingField(left, right, onlyLeft[0], right)
+	}
+
+	for _, name := range sortedKeys(leftFields) {
+		if rightField, ok := rightFields[name]; ok {
+			if m := u.unify(leftFields[name], rightField); m != nil {
+				return m
+			}
+		}
+	}
+
+	switch {
+	case leftRest == nil && rightRest == nil:
+		return nil
+	case leftRest == nil:
+		rightRest.Ref = &TRecord{Fields: pick(leftFields, onlyLeft), Rest: nil}
+	case rightRest == nil:
+		leftRest.Ref = &TRecord{Fields: pick(rightFields, onlyRight), Rest: nil}
+	case leftRest == rightRest:
+		if len(onlyLeft) > 0 || len(onlyRight) > 0 {
+			return &mismatch{left: left, right: right, detail: nil}
+		}
+	default:
+		rest := u.checker.fresh()
+		leftRest.Ref = &TRecord{Fields: pick(rightFields, onlyRight), Rest: rest}
+		rightRest.Ref = &TRecord{Fields: pick(leftFields, onlyLeft), Rest: rest}
+	}
+
+	return nil
+}
+
+// unifyCodata unifies a codata type with a record by unfolding the codata type.
+// If flipped is true, the record is the expected side.
+func (u *unifier) unifyCodata(codata *TCon, record *TRecord, flipped bool) *mismatch {
+	key := unfoldKey{name: codata.Name, record: record}
+	if prev, ok := u.unfolded[key]; ok {
+		// The record is recursive. Unfolding again does not terminate.
+		return u.unifyList(prev.Args, codata.Args)
+	}
+
+	unfolded, ok := u.checker.unfold(codata)
+	if !ok {
+		if flipped {
+			return &mismatch{left: record, right: codata, detail: nil}
+		}
+
+		return &mismatch{left: codata, right: record, detail: nil}
+	}
+	u.unfolded[key] = codata
+
+	if flipped {
+		return u.unify(record, unfolded)
+	}
+
+	return u.unify(unfolded, record)
+}
+
+func missingField(left, right Type, field string, record *TRecord) *mismatch {
+	return &mismatch{left: left, right: right, detail: func(p *printer) string {
+		return "field `" + field + "` is not defined in " + p.print(record)
+	}}
+}
+
+func pick(fields map[string]Type, names []string) map[string]Type {
+	picked := make(map[string]Type, len(names))
+	for _, name := range names {
+		picked[name] = fields[name]
+	}
+
+	return picked
+}
+
+func arityDetail(expected, actual int) string {
+	return "expected " + plural(expected, "argument") + ", actual " + plural(actual, "argument")
+}
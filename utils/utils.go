@@ -27,6 +27,16 @@ func (e PosError) Unwrap() error {
 	return e.Err
 }
 
+// Warning represents a non-fatal problem at a specific position in the code.
+type Warning struct {
+	Where token.Token // The token indicating the position of the problem.
+	Err   error       // The description of the problem.
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("at %v: `%s`, warning: %s", w.Where.Location, w.Where.Lexeme, w.Err.Error())
+}
+
 func FindSourceFiles(path string) ([]string, error) {
 	var files []string
 	err := filepath.WalkDir(path, func(path string, _ fs.DirEntry, err error) error {
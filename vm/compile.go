@@ -0,0 +1,556 @@
+package vm
+
+import (
+	"fmt"
+	"maps"
+	"math/big"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// Program is a compiled program.
+type Program struct {
+	Main    *Proto   // The top-level code. It defines global variables in order.
+	Globals []string // Unique names of global variables by their index.
+	// Tags are the resolved names of constructors that primitives use, given by [eval.BuiltinTags].
+	Tags map[eval.Name]eval.Name
+}
+
+func (p *Program) String() string {
+	return fmt.Sprintf("globals %v\n%v", p.Globals, p.Main)
+}
+
+// Compile compiles the program after [nameresolve.Resolver].
+// Variables are resolved to local slots of enclosing functions or global indices.
+func Compile(program []ast.Node) (*Program, error) {
+	c := &compiler{globals: make(map[string]int), names: nil, decl: toplevel}
+	tags := make(map[eval.Name]eval.Name)
+	for _, node := range program {
+		c.registerTopLevel(node)
+		if decl, ok := node.(*ast.TypeDecl); ok {
+			maps.Copy(tags, eval.BuiltinTags(decl))
+		}
+	}
+
+	main := &scope{proto: &Proto{Name: toplevel}, slots: make(map[string]int), parent: nil}
+	for _, node := range program {
+		if err := c.compileTopLevel(main, node); err != nil {
+			return nil, err
+		}
+	}
+	main.emit(OpUnit, 0, token.Token{})
+	main.emit(OpReturn, 0, token.Token{})
+
+	return &Program{Main: main.proto, Globals: c.names, Tags: tags}, nil
+}
+
+type compiler struct {
+	globals map[string]int // Unique name -> index.
+	names   []string       // Index -> unique name.
+	decl    string         // The name of the definition being compiled.
+}
+
+const toplevel = "toplevel"
+
+// funcName returns the name of functions created in s, which is the same as [eval.Frame].
+// Functions in the top-level code are named after the definition,
+// and functions in a function f are named `f.func`.
+func (c *compiler) funcName(s *scope) string {
+	if s.parent == nil {
+		return c.decl
+	}
+
+	return s.proto.Name + ".func"
+}
+
+// scope is a function being compiled.
+type scope struct {
+	proto  *Proto
+	slots  map[string]int // Unique name -> local slot.
+	parent *scope
+}
+
+// nameOf returns the unique name of the resolved token.
+// It is the same as the name of the variable in [eval.Evaluator].
+func nameOf(t token.Token) string {
+	return fmt.Sprintf("%s.%#v", t.Lexeme, t.Literal)
+}
+
+func (c *compiler) define(name token.Token) {
+	if _, ok := c.globals[nameOf(name)]; ok {
+		return
+	}
+	c.globals[nameOf(name)] = len(c.names)
+	c.names = append(c.names, nameOf(name))
+}
+
+func (c *compiler) registerTopLevel(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.TypeDecl:
+		for _, ctor := range node.Types {
+			if name, _, ok := constructorOf(ctor); ok {
+				c.define(name)
+			}
+		}
+	case *ast.VarDecl:
+		c.define(node.Name)
+	}
+}
+
+// constructorOf returns the name and the arity of the constructor declared by node.
+func constructorOf(node ast.Node) (token.Token, int, bool) {
+	switch node := node.(type) {
+	case *ast.Var:
+		return node.Name, -1, true
+	case *ast.Call:
+		if fn, ok := node.Func.(*ast.Var); ok {
+			return fn.Name, len(node.Args), true
+		}
+	}
+
+	// Other nodes are for type checking.
+	return token.Token{}, 0, false
+}
+
+func (c *compiler) compileTopLevel(s *scope, node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.TypeDecl:
+		for _, ctor := range node.Types {
+			name, arity, ok := constructorOf(ctor)
+			if !ok {
+				continue
+			}
+			tag := eval.Name(nameOf(name))
+			if arity < 0 {
+				s.emit(OpConst, s.constant(eval.Data{Tag: tag, Elems: nil}), name)
+			} else {
+				s.emit(OpConst, s.constant(eval.Constructor{Evaluator: eval.Evaluator{}, Tag: tag, Params: arity}), name)
+			}
+			s.emit(OpSetGlobal, c.globals[nameOf(name)], name)
+		}
+
+		return nil
+	case *ast.VarDecl:
+		if node.Expr == nil {
+			return nil
+		}
+		c.decl = node.Name.Lexeme
+		defer func() { c.decl = toplevel }()
+		if err := c.compile(s, node.Expr, false); err != nil {
+			return err
+		}
+		s.emit(OpSetGlobal, c.globals[nameOf(node.Name)], node.Name)
+
+		return nil
+	case *ast.InfixDecl, *ast.ModuleDecl, *ast.ImportDecl:
+		return nil
+	default:
+		if err := c.compile(s, node, false); err != nil {
+			return err
+		}
+		s.emit(OpPop, 0, node.Base())
+
+		return nil
+	}
+}
+
+// compile emits code that pushes the value of node.
+// If tail is true, calls are emitted as tail calls, which do not return to this function.
+func (c *compiler) compile(s *scope, node ast.Node, tail bool) error {
+	switch node := node.(type) {
+	case *ast.Var:
+		return c.compileVar(s, node.Name)
+	case *ast.Literal:
+		value, err := literalValue(node)
+		if err != nil {
+			return err
+		}
+		s.emit(OpConst, s.constant(value), node.Base())
+
+		return nil
+	case *ast.Paren:
+		return c.compile(s, node.Expr, tail)
+	case *ast.Assert:
+		return c.compile(s, node.Expr, tail)
+	case *ast.Tuple:
+		if err := c.compileAll(s, node.Exprs); err != nil {
+			return err
+		}
+		s.emit(OpTuple, len(node.Exprs), node.Base())
+
+		return nil
+	case *ast.Access:
+		if err := c.compile(s, node.Receiver, false); err != nil {
+			return err
+		}
+		s.emit(OpAccess, s.name(node.Name.Lexeme), node.Base())
+
+		return nil
+	case *ast.Call:
+		if err := c.compile(s, node.Func, false); err != nil {
+			return err
+		}
+		if err := c.compileAll(s, node.Args); err != nil {
+			return err
+		}
+		s.emitCall(len(node.Args), node.Base(), tail)
+
+		return nil
+	case *ast.Binary:
+		if err := c.compileVar(s, node.Op); err != nil {
+			return err
+		}
+		if err := c.compileAll(s, []ast.Node{node.Left, node.Right}); err != nil {
+			return err
+		}
+		s.emitCall(2, node.Base(), tail)
+
+		return nil
+	case *ast.Prim:
+		if err := c.compileAll(s, node.Args); err != nil {
+			return err
+		}
+		s.proto.Prims = append(s.proto.Prims, Prim{Name: node.Name, Args: len(node.Args)})
+		if tail {
+			s.emit(OpTailPrim, len(s.proto.Prims)-1, node.Name)
+		} else {
+			s.emit(OpPrim, len(s.proto.Prims)-1, node.Name)
+		}
+
+		return nil
+	case *ast.Let:
+		return c.compileLet(s, node)
+	case *ast.Seq:
+		return c.compileSeq(s, node, tail)
+	case *ast.Lambda:
+		return c.compileLambda(s, node)
+	case *ast.Case:
+		return c.compileCase(s, node, tail)
+	case *ast.Object:
+		return c.compileObject(s, node)
+	}
+
+	panic(fmt.Sprintf("unreachable: %v", node))
+}
+
+func (c *compiler) compileAll(s *scope, nodes []ast.Node) error {
+	for _, node := range nodes {
+		if err := c.compile(s, node, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileVar emits a load of a local variable of s or enclosing scopes, or a global variable.
+// Unknown variables are reported at runtime as [eval.Evaluator] does.
+func (c *compiler) compileVar(s *scope, name token.Token) error {
+	depth := 0
+	for scope := s; scope != nil; scope = scope.parent {
+		if slot, ok := scope.slots[nameOf(name)]; ok {
+			if depth > 0xff || slot > 0xffff {
+				return utils.PosError{Where: name, Err: TooManyVariablesError{}}
+			}
+			s.emit(OpLoad, depth<<16|slot, name)
+
+			return nil
+		}
+		depth++
+	}
+	index, ok := c.globals[nameOf(name)]
+	if !ok {
+		c.define(name)
+		index = c.globals[nameOf(name)]
+	}
+	s.emit(OpGlobal, index, name)
+
+	return nil
+}
+
+// compileLet matches the body with the pattern and binds variables in the current scope.
+// A let expression is evaluated to [].
+func (c *compiler) compileLet(s *scope, node *ast.Let) error {
+	// Define variables first, so that the body can refer to them recursively.
+	pattern, err := s.pattern(node.Bind)
+	if err != nil {
+		return err
+	}
+	if err := c.compile(s, node.Body, false); err != nil {
+		return err
+	}
+	body := s.temp()
+	s.emit(OpStore, body, node.Base())
+
+	s.proto.Matches = append(s.proto.Matches, Match{Scrutinees: []int{body}, Patterns: []Pattern{pattern}, Fail: 0})
+	match := len(s.proto.Matches) - 1
+	s.emit(OpMatch, match, node.Base())
+	s.emit(OpUnit, 0, node.Base())
+	end := s.emit(OpJump, 0, node.Base())
+	s.proto.Matches[match].Fail = len(s.proto.Code)
+	s.proto.Fails = append(s.proto.Fails, Fail{Scrutinees: []int{body}, Clauses: [][]ast.Node{{node.Bind}}})
+	s.emit(OpFail, len(s.proto.Fails)-1, node.Base())
+	s.patch(end)
+
+	return nil
+}
+
+func (c *compiler) compileSeq(s *scope, node *ast.Seq, tail bool) error {
+	if len(node.Exprs) == 0 {
+		s.emit(OpUnit, 0, token.Token{})
+
+		return nil
+	}
+	for i, expr := range node.Exprs {
+		last := i == len(node.Exprs)-1
+		if err := c.compile(s, expr, tail && last); err != nil {
+			return err
+		}
+		if !last {
+			s.emit(OpPop, 0, expr.Base())
+		}
+	}
+
+	return nil
+}
+
+func (c *compiler) compileLambda(s *scope, node *ast.Lambda) error {
+	params := make([]eval.Name, len(node.Params))
+	for i, param := range node.Params {
+		params[i] = eval.Name(nameOf(param))
+	}
+	inner := s.child(c.funcName(s), params)
+	for _, param := range node.Params {
+		inner.slot(param)
+	}
+	if err := c.compileBody(inner, node.Expr); err != nil {
+		return err
+	}
+	s.emit(OpClosure, s.nested(inner.proto), node.Base())
+
+	return nil
+}
+
+// compileBody compiles the body of a function, whose result is returned.
+func (c *compiler) compileBody(s *scope, body ast.Node) error {
+	if err := c.compile(s, body, true); err != nil {
+		return err
+	}
+	s.emit(OpReturn, 0, body.Base())
+
+	return nil
+}
+
+// compileCase stores scrutinees in temporary slots and tries clauses in order.
+// If no clause matches, it fails with all clauses as [eval.Evaluator] does.
+func (c *compiler) compileCase(s *scope, node *ast.Case, tail bool) error {
+	where := token.Token{}
+	if len(node.Scrutinees) > 0 {
+		where = node.Base()
+	}
+
+	scrutinees := make([]int, len(node.Scrutinees))
+	for i, scr := range node.Scrutinees {
+		if err := c.compile(s, scr, false); err != nil {
+			return err
+		}
+		scrutinees[i] = s.temp()
+		s.emit(OpStore, scrutinees[i], scr.Base())
+	}
+
+	var (
+		ends    []int
+		clauses [][]ast.Node
+	)
+	for _, clause := range node.Clauses {
+		clauses = append(clauses, clause.Patterns)
+		if len(clause.Patterns) != len(scrutinees) {
+			// The clause never matches.
+			continue
+		}
+		patterns := make([]Pattern, len(clause.Patterns))
+		for i, pattern := range clause.Patterns {
+			var err error
+			patterns[i], err = s.pattern(pattern)
+			if err != nil {
+				return err
+			}
+		}
+		s.proto.Matches = append(s.proto.Matches, Match{Scrutinees: scrutinees, Patterns: patterns, Fail: 0})
+		match := len(s.proto.Matches) - 1
+		s.emit(OpMatch, match, clause.Base())
+		if err := c.compile(s, clause.Expr, tail); err != nil {
+			return err
+		}
+		ends = append(ends, s.emit(OpJump, 0, clause.Base()))
+		s.proto.Matches[match].Fail = len(s.proto.Code)
+	}
+	s.proto.Fails = append(s.proto.Fails, Fail{Scrutinees: scrutinees, Clauses: clauses})
+	s.emit(OpFail, len(s.proto.Fails)-1, where)
+	for _, end := range ends {
+		s.patch(end)
+	}
+
+	return nil
+}
+
+// compileObject compiles each field as a function without parameters.
+func (c *compiler) compileObject(s *scope, node *ast.Object) error {
+	fields := make([]Field, len(node.Fields))
+	for i, field := range node.Fields {
+		inner := s.child(c.funcName(s)+"."+field.Name, nil)
+		if err := c.compileBody(inner, field.Expr); err != nil {
+			return err
+		}
+		fields[i] = Field{Name: field.Name, Proto: s.nested(inner.proto)}
+	}
+	s.proto.Objects = append(s.proto.Objects, fields)
+	where := token.Token{}
+	if len(node.Fields) > 0 {
+		where = node.Base()
+	}
+	s.emit(OpObject, len(s.proto.Objects)-1, where)
+
+	return nil
+}
+
+func literalValue(node *ast.Literal) (eval.Value, error) {
+	switch v := node.Literal.(type) {
+	case *big.Int:
+		if node.Kind == token.INTEGER {
+			return eval.Int{Int: v}, nil
+		}
+	case float64:
+		if node.Kind == token.FLOAT {
+			return eval.Float(v), nil
+		}
+	case string:
+		if node.Kind == token.STRING {
+			return eval.String(v), nil
+		}
+	}
+
+	return nil, utils.PosError{Where: node.Base(), Err: eval.InvalidLiteralError{Kind: node.Kind}}
+}
+
+// pattern compiles the pattern and allocates slots for its variables.
+func (s *scope) pattern(node ast.Node) (Pattern, error) {
+	switch node := node.(type) {
+	case *ast.Var:
+		return Pattern{Kind: PatBind, Slot: s.slot(node.Name), Value: nil, Tag: "", Elems: nil}, nil
+	case *ast.Paren:
+		return s.pattern(node.Expr)
+	case *ast.Literal:
+		value, err := literalValue(node)
+		if err != nil {
+			return Pattern{}, err
+		}
+
+		return Pattern{Kind: PatLiteral, Slot: 0, Value: value, Tag: "", Elems: nil}, nil
+	case *ast.Tuple:
+		elems, err := s.patterns(node.Exprs)
+		if err != nil {
+			return Pattern{}, err
+		}
+
+		return Pattern{Kind: PatTuple, Slot: 0, Value: nil, Tag: "", Elems: elems}, nil
+	case *ast.Call:
+		fn, ok := node.Func.(*ast.Var)
+		if !ok {
+			return Pattern{}, utils.PosError{Where: node.Base(), Err: eval.NotConstructorError{Node: node}}
+		}
+		elems, err := s.patterns(node.Args)
+		if err != nil {
+			return Pattern{}, err
+		}
+
+		return Pattern{Kind: PatData, Slot: 0, Value: nil, Tag: eval.Name(nameOf(fn.Name)), Elems: elems}, nil
+	}
+
+	return Pattern{}, utils.PosError{Where: node.Base(), Err: InvalidPatternError{Pattern: node}}
+}
+
+func (s *scope) patterns(nodes []ast.Node) ([]Pattern, error) {
+	patterns := make([]Pattern, len(nodes))
+	for i, node := range nodes {
+		var err error
+		patterns[i], err = s.pattern(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return patterns, nil
+}
+
+func (s *scope) child(name string, params []eval.Name) *scope {
+	return &scope{proto: &Proto{Name: name, Params: params}, slots: make(map[string]int), parent: s}
+}
+
+// slot returns the local slot of the variable, allocating it if needed.
+func (s *scope) slot(name token.Token) int {
+	if slot, ok := s.slots[nameOf(name)]; ok {
+		return slot
+	}
+	slot := s.temp()
+	s.slots[nameOf(name)] = slot
+
+	return slot
+}
+
+// temp allocates an anonymous local slot.
+func (s *scope) temp() int {
+	s.proto.Locals++
+
+	return s.proto.Locals - 1
+}
+
+func (s *scope) constant(value eval.Value) int {
+	s.proto.Consts = append(s.proto.Consts, value)
+
+	return len(s.proto.Consts) - 1
+}
+
+func (s *scope) name(name string) int {
+	for i, n := range s.proto.Names {
+		if n == name {
+			return i
+		}
+	}
+	s.proto.Names = append(s.proto.Names, name)
+
+	return len(s.proto.Names) - 1
+}
+
+func (s *scope) nested(proto *Proto) int {
+	s.proto.Protos = append(s.proto.Protos, proto)
+
+	return len(s.proto.Protos) - 1
+}
+
+// emit appends an instruction and returns its address.
+func (s *scope) emit(op Opcode, arg int, where token.Token) int {
+	if arg > maxArg {
+		panic(fmt.Sprintf("too large argument: %s %d", op, arg))
+	}
+	s.proto.Code = append(s.proto.Code, encode(op, arg))
+	s.proto.Where = append(s.proto.Where, where)
+
+	return len(s.proto.Code) - 1
+}
+
+func (s *scope) emitCall(argc int, where token.Token, tail bool) {
+	if tail {
+		s.emit(OpTailCall, argc, where)
+	} else {
+		s.emit(OpCall, argc, where)
+	}
+}
+
+// patch sets the target of the jump at addr to the current address.
+func (s *scope) patch(addr int) {
+	s.proto.Code[addr] = encode(s.proto.Code[addr].Op(), len(s.proto.Code))
+}
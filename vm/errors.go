@@ -0,0 +1,31 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/takoeight0821/anma/ast"
+)
+
+// TooManyVariablesError is an error that is returned when a variable cannot be encoded in an instruction.
+type TooManyVariablesError struct{}
+
+func (TooManyVariablesError) Error() string {
+	return "too many variables or too deeply nested functions"
+}
+
+func (TooManyVariablesError) Code() string {
+	return "E0801"
+}
+
+// InvalidPatternError is an error that is returned when a node cannot be compiled as a pattern.
+type InvalidPatternError struct {
+	Pattern ast.Node
+}
+
+func (e InvalidPatternError) Error() string {
+	return fmt.Sprintf("invalid pattern: %v", e.Pattern)
+}
+
+func (InvalidPatternError) Code() string {
+	return "E0802"
+}
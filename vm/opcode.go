@@ -0,0 +1,219 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/takoeight0821/anma/ast"
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/token"
+)
+
+// Opcode is an operation of the virtual machine.
+type Opcode uint8
+
+const (
+	OpConst     Opcode = iota // Push Consts[arg].
+	OpUnit                    // Push [].
+	OpLoad                    // Push the variable at depth arg>>16 and slot arg&0xffff.
+	OpStore                   // Pop a value into the local slot arg.
+	OpGlobal                  // Push the global variable arg.
+	OpSetGlobal               // Pop a value into the global variable arg.
+	OpTuple                   // Pop arg values and push a tuple of them.
+	OpClosure                 // Push a closure of Protos[arg].
+	OpObject                  // Push an object whose fields are Objects[arg].
+	OpAccess                  // Pop an object and push its field Names[arg].
+	OpCall                    // Pop a function and arg arguments, and push the result of the call.
+	OpTailCall                // Same as OpCall, but the call replaces the current frame.
+	OpPrim                    // Pop Prims[arg].Args arguments and push the result of the primitive.
+	OpTailPrim                // Same as OpPrim, but a call returned by the primitive replaces the current frame.
+	OpMatch                   // Match Matches[arg] and bind variables. If it fails, jump to its Fail.
+	OpFail                    // Fail with Fails[arg].
+	OpJump                    // Jump to arg.
+	OpPop                     // Pop a value.
+	OpReturn                  // Return the top of the stack.
+)
+
+var opcodeNames = [...]string{
+	OpConst:     "const",
+	OpUnit:      "unit",
+	OpLoad:      "load",
+	OpStore:     "store",
+	OpGlobal:    "global",
+	OpSetGlobal: "setglobal",
+	OpTuple:     "tuple",
+	OpClosure:   "closure",
+	OpObject:    "object",
+	OpAccess:    "access",
+	OpCall:      "call",
+	OpTailCall:  "tailcall",
+	OpPrim:      "prim",
+	OpTailPrim:  "tailprim",
+	OpMatch:     "match",
+	OpFail:      "fail",
+	OpJump:      "jump",
+	OpPop:       "pop",
+	OpReturn:    "return",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) {
+		return opcodeNames[op]
+	}
+
+	return fmt.Sprintf("Opcode(%d)", op)
+}
+
+// Instr is an instruction. The lower 8 bits are [Opcode] and the upper 24 bits are the argument.
+type Instr uint32
+
+const maxArg = 1<<24 - 1
+
+func encode(op Opcode, arg int) Instr {
+	return Instr(uint32(arg)<<8 | uint32(op))
+}
+
+func (i Instr) Op() Opcode {
+	return Opcode(i & 0xff)
+}
+
+func (i Instr) Arg() int {
+	return int(i >> 8)
+}
+
+// Proto is a compiled function, the body of an object field, or the top-level code.
+type Proto struct {
+	Name    string
+	Params  []eval.Name
+	Locals  int // The number of local slots. Parameters are the first slots.
+	Code    []Instr
+	Where   []token.Token // The source position of each instruction.
+	Consts  []eval.Value
+	Protos  []*Proto
+	Objects [][]Field
+	Names   []string
+	Prims   []Prim
+	Matches []Match
+	Fails   []Fail
+}
+
+// Prim is a call of a primitive.
+type Prim struct {
+	Name token.Token
+	Args int // The number of arguments on the stack.
+}
+
+// Field is a field of an object. Its body is evaluated lazily.
+type Field struct {
+	Name  string
+	Proto int // Index of Protos.
+}
+
+// Match matches values in local slots against patterns.
+type Match struct {
+	Scrutinees []int
+	Patterns   []Pattern
+	Fail       int // The address to jump to if the match fails.
+}
+
+// Fail describes a pattern match failure.
+type Fail struct {
+	Scrutinees []int
+	Clauses    [][]ast.Node // Patterns of all clauses that failed.
+}
+
+// PatternKind is a kind of [Pattern].
+type PatternKind uint8
+
+const (
+	PatBind    PatternKind = iota // Bind the value to Slot.
+	PatLiteral                    // Match Value.
+	PatTuple                      // Match a tuple of Elems.
+	PatData                       // Match data constructed by Tag with Elems.
+)
+
+// Pattern is a compiled pattern.
+type Pattern struct {
+	Kind  PatternKind
+	Slot  int
+	Value eval.Value
+	Tag   eval.Name
+	Elems []Pattern
+}
+
+func (p Pattern) String() string {
+	switch p.Kind {
+	case PatBind:
+		return fmt.Sprintf("$%d", p.Slot)
+	case PatLiteral:
+		return p.Value.String()
+	case PatTuple:
+		return "[" + patternsString(p.Elems) + "]"
+	case PatData:
+		return string(p.Tag) + "(" + patternsString(p.Elems) + ")"
+	default:
+		return fmt.Sprintf("PatternKind(%d)", p.Kind)
+	}
+}
+
+func patternsString(patterns []Pattern) string {
+	strs := make([]string, len(patterns))
+	for i, p := range patterns {
+		strs[i] = p.String()
+	}
+
+	return strings.Join(strs, ", ")
+}
+
+// String disassembles the proto and its nested protos.
+func (p *Proto) String() string {
+	var builder strings.Builder
+	p.disassemble(&builder, "")
+
+	return builder.String()
+}
+
+func (p *Proto) disassemble(builder *strings.Builder, indent string) {
+	fmt.Fprintf(builder, "%s%s %v locals=%d\n", indent, p.Name, p.Params, p.Locals)
+	for pc, instr := range p.Code {
+		fmt.Fprintf(builder, "%s  %04d %-9s %s\n", indent, pc, instr.Op(), p.operand(instr))
+	}
+	for _, proto := range p.Protos {
+		proto.disassemble(builder, indent+"  ")
+	}
+}
+
+// operand renders the argument of the instruction.
+func (p *Proto) operand(instr Instr) string {
+	arg := instr.Arg()
+	//exhaustive:ignore
+	switch instr.Op() {
+	case OpConst:
+		return p.Consts[arg].String()
+	case OpLoad:
+		return fmt.Sprintf("%d/%d", arg>>16, arg&0xffff)
+	case OpClosure:
+		return p.Protos[arg].Name
+	case OpObject:
+		names := make([]string, len(p.Objects[arg]))
+		for i, field := range p.Objects[arg] {
+			names[i] = field.Name + "=" + p.Protos[field.Proto].Name
+		}
+
+		return "{" + strings.Join(names, ", ") + "}"
+	case OpAccess:
+		return p.Names[arg]
+	case OpPrim, OpTailPrim:
+		return fmt.Sprintf("%s/%d", p.Prims[arg].Name.Lexeme, p.Prims[arg].Args)
+	case OpMatch:
+		match := p.Matches[arg]
+
+		return fmt.Sprintf("%v = [%s] else %04d", match.Scrutinees, patternsString(match.Patterns), match.Fail)
+	case OpFail:
+		return fmt.Sprintf("%v", p.Fails[arg].Scrutinees)
+	case OpUnit, OpPop, OpReturn:
+		return ""
+	default:
+		return fmt.Sprint(arg)
+	}
+}
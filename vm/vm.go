@@ -0,0 +1,533 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+)
+
+// VM runs a compiled [Program].
+// Values are shared with [eval.Evaluator], and primitives are called via the embedded evaluator,
+// so that Stdout, Stdin and [eval.Evaluator.RegisterPrim] work in the same way.
+type VM struct {
+	*eval.Evaluator
+	program *Program
+	globals []eval.Value
+}
+
+func NewVM(program *Program) *VM {
+	evaluator := eval.NewEvaluator()
+	evaluator.UseTags(program.Tags)
+
+	return &VM{
+		Evaluator: evaluator,
+		program:   program,
+		globals:   make([]eval.Value, len(program.Globals)),
+	}
+}
+
+// RunContext is the same as [VM.Run], but the program stops when ctx is done.
+func (vm *VM) RunContext(ctx context.Context) error {
+	defer vm.Budget().SetContext(vm.Budget().SetContext(ctx))
+
+	return vm.Run()
+}
+
+// Run runs the top-level code, which defines global variables.
+// The work is bounded by [eval.Evaluator.SetLimits].
+func (vm *VM) Run() error {
+	_, err := vm.execute(frame{
+		proto: vm.program.Main,
+		pc:    0,
+		env:   newEnv(nil, vm.program.Main.Locals),
+		base:  0,
+		memo:  nil,
+		call:  token.Location{},
+		trace: nil,
+		first: nil,
+	})
+
+	return err
+}
+
+// SearchMain returns the function bound to main, which is the resolved name of a `def main`.
+func (vm *VM) SearchMain(main token.Token) (eval.Callable, bool) {
+	for i, name := range vm.program.Globals {
+		if name == nameOf(main) {
+			f, ok := vm.globals[i].(eval.Foreign)
+			if !ok {
+				return nil, false
+			}
+			if _, ok := f.Value.(*closure); !ok {
+				return nil, false
+			}
+
+			return f, true
+		}
+	}
+
+	return nil, false
+}
+
+// env is an environment of a running proto. Closures capture it by reference.
+type env struct {
+	values []eval.Value
+	parent *env
+}
+
+func newEnv(parent *env, locals int) *env {
+	return &env{values: make([]eval.Value, locals), parent: parent}
+}
+
+// closure is a function value. It is wrapped in [eval.Foreign].
+type closure struct {
+	proto *Proto
+	env   *env
+	vm    *VM
+}
+
+func (c *closure) String() string {
+	var builder strings.Builder
+	builder.WriteString("<function")
+	for _, param := range c.proto.Params {
+		builder.WriteString(" ")
+		builder.WriteString(string(param))
+	}
+	builder.WriteString(">")
+
+	return builder.String()
+}
+
+// Apply calls the closure from outside of the VM, such as [eval.Foreign.Apply].
+func (c *closure) Apply(where token.Token, args ...eval.Value) (eval.Value, error) {
+	budget := c.vm.Budget()
+	if err := budget.Step(where); err != nil {
+		return nil, utils.PosError{Where: where, Err: err}
+	}
+	fr, err := c.enter(where, args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := budget.Enter(where); err != nil {
+		return nil, utils.PosError{Where: where, Err: err}
+	}
+	defer budget.Leave()
+
+	return c.vm.execute(fr)
+}
+
+// enter returns a frame that runs the body with the arguments.
+func (c *closure) enter(where token.Token, args []eval.Value, base int) (frame, error) {
+	if len(c.proto.Params) != len(args) {
+		return frame{}, utils.PosError{
+			Where: where,
+			Err:   eval.InvalidArgumentCountError{Expected: len(c.proto.Params), Actual: len(args)},
+		}
+	}
+	env := newEnv(c.env, c.proto.Locals)
+	copy(env.values, args)
+
+	return frame{proto: c.proto, pc: 0, env: env, base: base, memo: nil, call: where.Location, trace: nil, first: nil}, nil
+}
+
+// thunk is a field of an object that is not evaluated yet. It is wrapped in [eval.Foreign].
+type thunk struct {
+	proto *Proto
+	env   *env
+}
+
+func (*thunk) String() string {
+	return "<thunk>"
+}
+
+// frame is an activation of a proto.
+type frame struct {
+	proto *Proto
+	pc    int
+	env   *env
+	base  int   // The height of the stack when the frame is entered.
+	memo  *memo // If not nil, the result is stored in the field of the object.
+	call  token.Location
+	// trace is the frame in stack traces if it is entered by a tail call, and first is the frame that made the first of the tail calls.
+	// See [eval.Frame.Replace].
+	trace *eval.Frame
+	first *eval.Frame
+}
+
+// traceFrame returns the frame in stack traces.
+func (fr frame) traceFrame() eval.Frame {
+	if fr.trace != nil {
+		return *fr.trace
+	}
+
+	return eval.Frame{Name: fr.proto.Name, Call: fr.call}
+}
+
+type memo struct {
+	object eval.Object
+	field  string
+}
+
+// machine is the state of [VM.execute].
+type machine struct {
+	vm     *VM
+	stack  []eval.Value
+	frames []frame // Callers of fr.
+	fr     frame   // The running frame.
+}
+
+// execute runs fr and frames called from it until fr returns.
+func (vm *VM) execute(fr frame) (eval.Value, error) {
+	m := &machine{vm: vm, stack: nil, frames: nil, fr: fr}
+	for {
+		v, done, err := m.step()
+		if err != nil {
+			return nil, m.unwind(err)
+		}
+		if done {
+			return v, nil
+		}
+	}
+}
+
+// step runs an instruction. If the outermost frame returns, done is true and v is the result.
+func (m *machine) step() (eval.Value, bool, error) {
+	instr := m.fr.proto.Code[m.fr.pc]
+	where := m.fr.proto.Where[m.fr.pc]
+	arg := instr.Arg()
+	m.fr.pc++
+
+	switch instr.Op() {
+	case OpConst:
+		m.push(m.fr.proto.Consts[arg])
+	case OpUnit:
+		m.push(eval.Unit())
+	case OpLoad:
+		e := m.fr.env
+		for range arg >> 16 {
+			e = e.parent
+		}
+		if err := m.pushDefined(e.values[arg&0xffff], where); err != nil {
+			return nil, false, err
+		}
+	case OpStore:
+		m.fr.env.values[arg] = m.pop()
+	case OpGlobal:
+		if err := m.pushDefined(m.vm.globals[arg], where); err != nil {
+			return nil, false, err
+		}
+	case OpSetGlobal:
+		m.vm.globals[arg] = m.pop()
+	case OpTuple:
+		m.push(eval.Tuple(m.popN(arg)))
+	case OpClosure:
+		m.push(eval.Foreign{Value: &closure{proto: m.fr.proto.Protos[arg], env: m.fr.env, vm: m.vm}})
+	case OpObject:
+		fields := make(map[string]eval.Value)
+		for _, field := range m.fr.proto.Objects[arg] {
+			fields[field.Name] = eval.Foreign{Value: &thunk{proto: m.fr.proto.Protos[field.Proto], env: m.fr.env}}
+		}
+		m.push(eval.Object{Fields: fields})
+	case OpAccess:
+		if err := m.access(m.pop(), m.fr.proto.Names[arg], where); err != nil {
+			return nil, false, err
+		}
+	case OpCall, OpTailCall:
+		args := m.popN(arg)
+		callee := m.pop()
+		fn, ok := callee.(eval.Callable)
+		if !ok {
+			return nil, false, utils.PosError{Where: where, Err: eval.NotCallableError{Func: callee}}
+		}
+		tail := instr.Op() == OpTailCall
+		entered, err := m.call(fn, where, args, tail)
+		if err != nil {
+			return nil, false, err
+		}
+		if tail && !entered {
+			// A callable other than a closure returned the result.
+			return m.ret()
+		}
+	case OpPrim, OpTailPrim:
+		prim := m.fr.proto.Prims[arg]
+		tail := instr.Op() == OpTailPrim
+		v, err := m.vm.CallPrim(prim.Name, m.popN(prim.Args))
+		if err != nil {
+			return nil, false, err
+		}
+		entered := false
+		if call, ok := v.(eval.TailCall); ok {
+			// The primitive calls a continuation.
+			entered, err = m.call(call.Fn, call.Where, call.Args, tail)
+			if err != nil {
+				return nil, false, err
+			}
+		} else {
+			m.push(v)
+		}
+		if tail && !entered {
+			return m.ret()
+		}
+	case OpMatch:
+		match := m.fr.proto.Matches[arg]
+		if !matchAll(m.fr.env, match.Scrutinees, match.Patterns) {
+			m.fr.pc = match.Fail
+		}
+	case OpFail:
+		return nil, false, m.fail(m.fr.proto.Fails[arg], where)
+	case OpJump:
+		m.fr.pc = arg
+	case OpPop:
+		m.pop()
+	case OpReturn:
+		return m.ret()
+	}
+
+	return nil, false, nil
+}
+
+func (m *machine) push(v eval.Value) {
+	m.stack = append(m.stack, v)
+}
+
+// pushDefined pushes the value of the variable where. A nil value is an undefined variable.
+func (m *machine) pushDefined(v eval.Value, where token.Token) error {
+	if v == nil {
+		return utils.PosError{Where: where, Err: eval.UndefinedVariableError{Name: where}}
+	}
+	m.push(v)
+
+	return nil
+}
+
+func (m *machine) pop() eval.Value {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+
+	return v
+}
+
+func (m *machine) popN(n int) []eval.Value {
+	values := make([]eval.Value, n)
+	copy(values, m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:len(m.stack)-n]
+
+	return values
+}
+
+// ret returns the top of the stack from the running frame.
+func (m *machine) ret() (eval.Value, bool, error) {
+	v := m.pop()
+	m.stack = m.stack[:m.fr.base]
+	if m.fr.memo != nil {
+		m.fr.memo.object.Fields[m.fr.memo.field] = v
+	}
+	if len(m.frames) == 0 {
+		return v, true, nil
+	}
+	m.fr = m.frames[len(m.frames)-1]
+	m.frames = m.frames[:len(m.frames)-1]
+	m.vm.Budget().Leave()
+	m.push(v)
+
+	return nil, false, nil
+}
+
+// call calls fn and reports whether it entered a new frame.
+// A closure of this VM runs in a new frame, which replaces the running frame if tail is true.
+// Other callables are applied immediately, and the result is pushed.
+func (m *machine) call(fn eval.Callable, where token.Token, args []eval.Value, tail bool) (bool, error) {
+	if err := m.vm.Budget().Step(where); err != nil {
+		return false, utils.PosError{Where: where, Err: err}
+	}
+	if c, ok := closureOf(fn); ok && c.vm == m.vm {
+		base := len(m.stack)
+		if tail {
+			base = m.fr.base
+		}
+		next, err := c.enter(where, args, base)
+		if err != nil {
+			return false, err
+		}
+		if tail {
+			// Discard the running frame. The callee returns its result instead.
+			m.stack = m.stack[:base]
+			next.memo = m.fr.memo
+			replaced := m.fr.traceFrame()
+			trace := replaced.Replace(next.traceFrame())
+			next.trace, next.first = &trace, m.fr.first
+			if next.first == nil {
+				next.first = &replaced
+			}
+		} else {
+			if err := m.vm.Budget().Enter(where); err != nil {
+				return false, utils.PosError{Where: where, Err: err}
+			}
+			m.frames = append(m.frames, m.fr)
+		}
+		m.fr = next
+
+		return true, nil
+	}
+
+	v, err := fn.Apply(where, args...)
+	if err != nil {
+		return false, err
+	}
+	m.push(v)
+
+	return false, nil
+}
+
+// access pushes the field of the receiver.
+// If the field is not evaluated yet, it enters a frame that evaluates and memoizes it.
+func (m *machine) access(receiver eval.Value, name string, where token.Token) error {
+	object, ok := receiver.(eval.Object)
+	if !ok {
+		return utils.PosError{Where: where, Err: eval.NotObjectError{Receiver: receiver}}
+	}
+	value, ok := object.Fields[name]
+	if !ok {
+		return utils.PosError{Where: where, Err: eval.UndefinedFieldError{Receiver: object, Name: name}}
+	}
+	if f, ok := value.(eval.Foreign); ok {
+		if t, ok := f.Value.(*thunk); ok {
+			budget := m.vm.Budget()
+			if err := budget.Step(where); err != nil {
+				return utils.PosError{Where: where, Err: err}
+			}
+			if err := budget.Enter(where); err != nil {
+				return utils.PosError{Where: where, Err: err}
+			}
+			m.frames = append(m.frames, m.fr)
+			m.fr = frame{
+				proto: t.proto,
+				pc:    0,
+				env:   newEnv(t.env, t.proto.Locals),
+				base:  len(m.stack),
+				memo:  &memo{object: object, field: name},
+				call:  where.Location,
+				trace: nil,
+				first: nil,
+			}
+
+			return nil
+		}
+	}
+	m.push(value)
+
+	return nil
+}
+
+// fail reports that no clause matches.
+func (m *machine) fail(fail Fail, where token.Token) error {
+	values := make([]eval.Value, len(fail.Scrutinees))
+	for i, scr := range fail.Scrutinees {
+		values[i] = m.fr.env.values[scr]
+	}
+	var err error
+	for _, clause := range fail.Clauses {
+		err = errors.Join(err, eval.PatternMatchError{Patterns: clause, Values: values})
+	}
+
+	return utils.PosError{Where: where, Err: err}
+}
+
+// unwind leaves all frames and attaches the stack trace to err.
+func (m *machine) unwind(err error) error {
+	trace := make([]eval.Frame, 0, len(m.frames)+1)
+	for _, fr := range append(m.frames, m.fr) {
+		if fr.proto == m.vm.program.Main {
+			// The top-level code is not a call.
+			continue
+		}
+		if fr.first != nil {
+			trace = append(trace, *fr.first)
+		}
+		trace = append(trace, fr.traceFrame())
+	}
+	for range m.frames {
+		m.vm.Budget().Leave()
+	}
+
+	return eval.WithTrace(err, trace)
+}
+
+func closureOf(v eval.Callable) (*closure, bool) {
+	f, ok := v.(eval.Foreign)
+	if !ok {
+		return nil, false
+	}
+	c, ok := f.Value.(*closure)
+
+	return c, ok
+}
+
+// matchAll matches values in the slots against patterns, and binds variables on success.
+func matchAll(env *env, slots []int, patterns []Pattern) bool {
+	for i, slot := range slots {
+		if !match(env, patterns[i], env.values[slot]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// match follows the rules of [eval.Value]. A tuple or data pattern may have more elements than the value.
+func match(env *env, pattern Pattern, value eval.Value) bool {
+	switch pattern.Kind {
+	case PatBind:
+		env.values[pattern.Slot] = value
+
+		return true
+	case PatLiteral:
+		return matchLiteral(pattern.Value, value)
+	case PatTuple:
+		tuple, ok := value.(eval.Tuple)
+
+		return ok && matchElems(env, pattern.Elems, tuple)
+	case PatData:
+		data, ok := value.(eval.Data)
+
+		return ok && data.Tag == pattern.Tag && matchElems(env, pattern.Elems, data.Elems)
+	}
+
+	return false
+}
+
+func matchElems(env *env, patterns []Pattern, values []eval.Value) bool {
+	if len(values) > len(patterns) {
+		return false
+	}
+	for i, value := range values {
+		if !match(env, patterns[i], value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchLiteral(literal, value eval.Value) bool {
+	switch literal := literal.(type) {
+	case eval.Int:
+		v, ok := value.(eval.Int)
+
+		return ok && literal.Cmp(v.Int) == 0
+	case eval.Float:
+		v, ok := value.(eval.Float)
+
+		return ok && literal == v
+	case eval.String:
+		v, ok := value.(eval.String)
+
+		return ok && literal == v
+	}
+
+	return false
+}
@@ -0,0 +1,313 @@
+package vm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sebdah/goldie/v2"
+	"github.com/takoeight0821/anma/codata"
+	"github.com/takoeight0821/anma/driver"
+	"github.com/takoeight0821/anma/eval"
+	"github.com/takoeight0821/anma/module"
+	"github.com/takoeight0821/anma/token"
+	"github.com/takoeight0821/anma/utils"
+	"github.com/takoeight0821/anma/vm"
+)
+
+// TestGolden checks that the VM produces the same output as the golden files of the eval package.
+func TestGolden(t *testing.T) {
+	t.Parallel()
+
+	testfiles, err := utils.FindSourceFiles("../testdata")
+	if err != nil {
+		t.Errorf("failed to find test files: %v", err)
+
+		return
+	}
+
+	for _, testfile := range testfiles {
+		source, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", testfile, err)
+
+			return
+		}
+		golden, err := os.ReadFile(filepath.Join("../eval/testdata", filepath.Base(testfile)+".golden"))
+		if err != nil {
+			t.Errorf("failed to read golden file of %s: %v", testfile, err)
+
+			return
+		}
+
+		var builder strings.Builder
+		ret, err := run(t, testfile, string(source), func(machine *vm.VM) {
+			machine.Stdout = &builder
+			machine.Stdin = strings.NewReader("test input\n")
+		})
+		var exitErr eval.ExitError
+		if errors.As(err, &exitErr) {
+			fmt.Fprintf(&builder, "exit => %d\n", exitErr.Code)
+		} else if err != nil {
+			fmt.Fprintf(&builder, "error => %v\n", err)
+		}
+		if ret != nil {
+			fmt.Fprintf(&builder, "result => %s\n", ret.String())
+		}
+
+		if builder.String() != string(golden) {
+			t.Errorf("%s: unexpected output\nexpected:\n%s\nactual:\n%s", testfile, golden, builder.String())
+		}
+	}
+}
+
+// backends run main of the source and return the output.
+// The evaluator and the VM must agree on tail calls, limits and stack traces, so the tests below run on both.
+var backends = []struct {
+	name string
+	run  func(t *testing.T, path, source string, setup func(*eval.Evaluator)) (string, error)
+}{
+	{name: "eval", run: runEval},
+	{name: "vm", run: runVM},
+}
+
+// TestTailCall runs loops with a small Go stack.
+// It is not parallel because the maximum stack size is global.
+//
+//nolint:paralleltest
+func TestTailCall(t *testing.T) {
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	testfile := "../testdata/loop.anma"
+	source, err := os.ReadFile(testfile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", testfile, err)
+	}
+	for _, backend := range backends {
+		output, err := backend.run(t, testfile, string(source), func(*eval.Evaluator) {})
+		if err != nil {
+			t.Fatalf("%s: %s returned error: %v", backend.name, testfile, err)
+		}
+		if output != "50015001\n\"done\"\n" {
+			t.Errorf("%s: unexpected output: %q", backend.name, output)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	source := `
+def loop = { #(n) -> loop(n) }
+def deep = { #(n) -> prim(add, deep(n), 1) }
+def read = { #(n) -> prim(read_all_cps, { #(s) -> s }) }
+def main = { #() -> %s(1) }
+`
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		main     string
+		setup    func(*eval.Evaluator)
+		expected string
+		ok       func(err error) bool
+	}{
+		{
+			name:     "fuel",
+			main:     "loop",
+			setup:    func(evaluator *eval.Evaluator) { evaluator.SetLimits(eval.Limits{Fuel: 100, Depth: 0}) },
+			expected: "fuel exhausted at line 2",
+			ok: func(err error) bool {
+				var fuel eval.FuelExhaustedError
+
+				return errors.As(err, &fuel) && fuel.Location.Line == 2
+			},
+		},
+		{
+			name:     "depth",
+			main:     "deep",
+			setup:    func(evaluator *eval.Evaluator) { evaluator.SetLimits(eval.Limits{Fuel: 0, Depth: 10}) },
+			expected: "stack depth error at line 3",
+			ok: func(err error) bool {
+				var depth eval.StackDepthError
+
+				return errors.As(err, &depth) && depth.Location.Line == 3
+			},
+		},
+		{
+			name:     "canceled",
+			main:     "loop",
+			setup:    func(evaluator *eval.Evaluator) { evaluator.Budget().SetContext(canceled) },
+			expected: "canceled error",
+			ok: func(err error) bool {
+				var canceledErr eval.CanceledError
+
+				return errors.As(err, &canceledErr) && errors.Is(err, context.Canceled)
+			},
+		},
+		{
+			name: "blocking prim",
+			main: "read",
+			setup: func(evaluator *eval.Evaluator) {
+				// Nothing is written to stdin, so read_all_cps blocks until the timeout.
+				stdin, writer := io.Pipe()
+				t.Cleanup(func() { writer.Close() })
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				t.Cleanup(cancel)
+				evaluator.Stdin = stdin
+				evaluator.Budget().SetContext(ctx)
+			},
+			expected: "canceled error at line 4",
+			ok: func(err error) bool {
+				var canceledErr eval.CanceledError
+
+				return errors.As(err, &canceledErr) && canceledErr.Location.Line == 4 && errors.Is(err, context.DeadlineExceeded)
+			},
+		},
+	}
+	for _, backend := range backends {
+		for _, test := range tests {
+			_, err := backend.run(t, test.name, fmt.Sprintf(source, test.main), test.setup)
+			if !test.ok(err) {
+				t.Errorf("%s: expected %s, actual %v", backend.name, test.expected, err)
+			}
+		}
+	}
+}
+
+// TestStackTrace checks the stack traces of runtime errors against the golden files.
+// Both backends must print the same trace.
+func TestStackTrace(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{
+			name: "trace.anma",
+			source: `
+def fail = { #(x) -> prim(div, x, 0) }
+def twice = { #(f, x) -> prim(add, f(x), 1) }
+def main = { #() -> prim(print, twice({ #(x) -> prim(add, fail(x), 0) }, 1)) }
+`,
+		},
+		{
+			// g is replaced by the tail call of div, but stays paired with its own call site.
+			name: "tail.anma",
+			source: `
+def div = { #(x, y) -> prim(div, x, y) }
+def f = { #(n) -> prim(add, g(n), 1) }
+def g = { #(n) -> div(n, 0) }
+def main = { #() -> prim(print, f(1)) }
+`,
+		},
+		{
+			// h is replaced by the tail call of div after it replaced g.
+			name: "elided.anma",
+			source: `
+def div = { #(x, y) -> prim(div, x, y) }
+def f = { #(n) -> prim(add, g(n), 1) }
+def g = { #(n) -> h(n) }
+def h = { #(n) -> div(n, 0) }
+def main = { #() -> prim(print, f(1)) }
+`,
+		},
+	}
+	for _, test := range tests {
+		for _, backend := range backends {
+			_, err := backend.run(t, test.name, test.source, func(*eval.Evaluator) {})
+
+			var runtimeErr eval.RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("%s: expected runtime error in %s, actual %v", backend.name, test.name, err)
+			}
+			g := goldie.New(t)
+			g.Assert(t, test.name, []byte(runtimeErr.Traceback()))
+		}
+	}
+}
+
+// runEval evaluates main of the source with the evaluator and returns the output.
+func runEval(t *testing.T, path, source string, setup func(*eval.Evaluator)) (string, error) {
+	t.Helper()
+
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
+
+	nodes, err := runner.RunSource(path, source)
+	if err != nil {
+		t.Fatalf("%s returned error: %v", path, err)
+	}
+
+	evaluator := eval.NewEvaluator()
+	var builder strings.Builder
+	evaluator.Stdout = &builder
+	setup(evaluator)
+
+	for _, node := range nodes {
+		if _, err := evaluator.Eval(node); err != nil {
+			t.Fatalf("%s returned error: %v", path, err)
+		}
+	}
+	name, _ := module.MainName(nodes)
+	main, ok := evaluator.SearchMain(name)
+	if !ok {
+		t.Fatalf("%s does not have a main function", path)
+	}
+	top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
+	_, err = main.Apply(top)
+
+	return builder.String(), err
+}
+
+// runVM runs main of the source on the VM and returns the output.
+func runVM(t *testing.T, path, source string, setup func(*eval.Evaluator)) (string, error) {
+	t.Helper()
+
+	var builder strings.Builder
+	_, err := run(t, path, source, func(machine *vm.VM) {
+		machine.Stdout = &builder
+		setup(machine.Evaluator)
+	})
+
+	return builder.String(), err
+}
+
+// run compiles the source and calls main.
+func run(t *testing.T, path, source string, setup func(*vm.VM)) (eval.Value, error) {
+	t.Helper()
+
+	// The output has unique numbers of constructors, which the prelude would shift from the golden files of eval.
+	runner := driver.NewStandardRunner(driver.Options{Exhaustive: codata.CheckWarn, Until: driver.StageResolve, NoPrelude: true})
+
+	nodes, err := runner.RunSource(path, source)
+	if err != nil {
+		t.Fatalf("%s returned error: %v", path, err)
+	}
+	program, err := vm.Compile(nodes)
+	if err != nil {
+		t.Fatalf("%s failed to compile: %v", path, err)
+	}
+
+	machine := vm.NewVM(program)
+	setup(machine)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("%s returned error: %v", path, err)
+	}
+	name, _ := module.MainName(nodes)
+	main, ok := machine.SearchMain(name)
+	if !ok {
+		t.Fatalf("%s does not have a main function", path)
+	}
+	top := token.Token{Kind: token.IDENT, Lexeme: "toplevel", Location: token.Location{}, Literal: -1}
+
+	return main.Apply(top)
+}